@@ -1,16 +1,42 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"simple-multiplayer-service/internal/abandon"
+	"simple-multiplayer-service/internal/admin"
+	"simple-multiplayer-service/internal/auth"
+	"simple-multiplayer-service/internal/backplane"
 	"simple-multiplayer-service/internal/config"
+	"simple-multiplayer-service/internal/db"
 	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/db/redisdb"
+	"simple-multiplayer-service/internal/db/sqlite"
+	"simple-multiplayer-service/internal/health"
+	"simple-multiplayer-service/internal/lobby"
+	"simple-multiplayer-service/internal/logging"
 	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/metrics"
 	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/player"
+	"simple-multiplayer-service/internal/ratelimit"
+	"simple-multiplayer-service/internal/results"
+	"simple-multiplayer-service/internal/room"
+	"simple-multiplayer-service/internal/spectate"
 	"simple-multiplayer-service/internal/websocket"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -21,32 +47,327 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Create the structured logger and route the standard logger through it
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
 	// Create a Notification Service
 	notificationService := notification.NewNotificationService()
 
+	// Keep state shared by the nodes of a deployment in Redis
+	var redisStore *redisdb.Store
+	if cfg.SessionStore == "redis" || cfg.Backplane == "redis" || cfg.MatchmakingMode == "distributed" {
+		redisOptions, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			fatal(logger, "error parsing REDIS_URL", err)
+		}
+		redisStore = redisdb.NewStore(redis.NewClient(redisOptions), cfg.RedisKeyPrefix)
+		redisStore.SessionTTL = cfg.SessionTTL
+		redisStore.HistoryTTL = cfg.SessionHistoryTTL
+	}
+
 	// Create a DB client
-	localDB := local.DB{}
+	var sessionDB interface {
+		matchmaking.SessionStore
+		results.Store
+		admin.SessionStore
+		abandon.Store
+	}
+	switch cfg.SessionStore {
+	case "memory":
+		sessionDB = local.DB{HistoryTTL: cfg.SessionHistoryTTL}
+	case "redis":
+		sessionDB = redisStore
+	default:
+		fatal(logger, "error configuring session store", fmt.Errorf("unknown store %q", cfg.SessionStore))
+	}
 
 	// Create a Matchmaking Service
-	matchmakingService := matchmaking.NewMatchmakingService(cfg.SessionLimit, localDB, notificationService)
+	matchmakingService := matchmaking.NewMatchmakingService(cfg.SessionLimit, sessionDB, notificationService)
+
+	// Collect metrics from the services
+	serviceMetrics := metrics.New()
+	serviceMetrics.WatchQueue("matchmaking_queue_depth", "Number of matchmaking requests waiting in SessionQueue.", func() int {
+		return len(matchmakingService.SessionQueue)
+	})
+	serviceMetrics.WatchQueue("notification_queue_depth", "Number of session notifications waiting to be delivered.", func() int {
+		return len(notificationService.Channel)
+	})
+	matchmakingService.Metrics = serviceMetrics
+	matchmakingService.Logger = logger
+	matchmakingService.Sessions.Metrics = serviceMetrics
+	matchmakingService.Sessions.Logger = logger
 
 	// Create a new connection manager
 	manager := websocket.NewConnectionManager(matchmakingService, notificationService)
+	manager.SetLogger(logger, cfg.LogMessageContent)
+
+	// Configure which origins may connect and how connections are upgraded
+	manager.SetUpgradeOptions(websocket.UpgradeOptions{
+		AllowedOrigins:       cfg.AllowedOrigins,
+		ReadBufferSize:       cfg.WSReadBufferSize,
+		WriteBufferSize:      cfg.WSWriteBufferSize,
+		Subprotocols:         cfg.WSSubprotocols,
+		EnableCompression:    cfg.WSEnableCompression,
+		CompressionLevel:     cfg.WSCompressionLevel,
+		CompressionThreshold: cfg.WSCompressionThreshold,
+	})
+
+	// Record connection and message metrics
+	manager.SetMetrics(serviceMetrics)
+
+	// Reject oversized frames and message content
+	manager.SetMessageLimits(cfg.MaxFrameSize, cfg.MaxContentLength)
+
+	// Limit connections per IP and message rates per connection and IP
+	manager.SetRateLimiter(ratelimit.NewLimiter(ratelimit.Options{
+		MessagesPerSecond:      cfg.RateMessagesPerSecond,
+		MessageBurst:           cfg.RateMessageBurst,
+		MatchmakingPerMinute:   cfg.RateMatchmakingPerMinute,
+		IPMessagesPerSecond:    cfg.RateIPMessagesPerSecond,
+		IPMatchmakingPerMinute: cfg.RateIPMatchmakingPerMinute,
+		MaxConnectionsPerIP:    cfg.RateMaxConnectionsPerIP,
+		MaxViolations:          cfg.RateMaxViolations,
+	}))
+
+	// Require a valid token on upgrade when authentication is configured
+	if cfg.AuthEnabled() {
+		authenticator, err := auth.NewAuthenticator(auth.Options{
+			HMACSecret: cfg.AuthHMACSecret,
+			JWKSFile:   cfg.AuthJWKSFile,
+			Issuer:     cfg.AuthIssuer,
+			Audience:   cfg.AuthAudience,
+			QueryParam: cfg.AuthQueryParam,
+		})
+		if err != nil {
+			fatal(logger, "error configuring authentication", err)
+		}
+		manager.SetAuthenticator(authenticator)
+	}
+
+	// Resend session notifications until the client acknowledges them
+	manager.SetDeliveryRetry(cfg.DeliveryRetryInterval, cfg.DeliveryMaxAttempts)
+
+	// Keep recent chat room messages for late joiners
+	manager.SetRooms(room.NewManager(cfg.RoomHistorySize))
+
+	// Let friends set up matches in private lobbies joined by code
+	manager.SetLobbies(lobby.NewManager(cfg.LobbyMaxPlayers))
+
+	// Let clients watch sessions they aren't playing
+	manager.SetSpectators(spectate.NewManager(sessionDB, cfg.MaxSpectators, cfg.SpectatorDelay))
+
+	// Let players report session results and read their match history
+	resultService := results.NewService(sessionDB, matchmakingService.Sessions, cfg.ResultReportTimeout)
+	resultService.Logger = logger
+	manager.SetResults(resultService)
+
+	// Pause the sessions of disconnected players and abandon them when they don't come back
+	abandonTracker := abandon.NewTracker(sessionDB, matchmakingService.Sessions, cfg.ReconnectWindow)
+	abandonTracker.Forfeit = cfg.AbandonForfeit
+	abandonTracker.Penalty = cfg.LeaverPenalty
+	abandonTracker.Logger = logger
+	manager.SetAbandonment(abandonTracker)
+
+	// Keep direct messages for offline players. Only authenticated players
+	// keep their ID across connections, so anonymous IDs would never collect them.
+	var messageStore db.MessageStore
+	switch cfg.OfflineMessageStore {
+	case "":
+	case "memory":
+		messageStore = local.NewMessageStore(cfg.OfflineMessageLimit)
+	case "sqlite":
+		messageStore, err = sqlite.NewMessageStore(cfg.OfflineMessageDBPath, cfg.OfflineMessageLimit)
+		if err != nil {
+			fatal(logger, "error opening offline message store", err)
+		}
+	default:
+		fatal(logger, "error configuring offline message store", fmt.Errorf("unknown store %q", cfg.OfflineMessageStore))
+	}
+	if messageStore != nil {
+		if cfg.AuthEnabled() {
+			manager.SetMessageStore(messageStore)
+		} else {
+			logger.Warn("offline messages need authentication, not storing them")
+		}
+	}
+
+	// Decide how players with several connections are handled
+	connectionPolicy, err := player.ParseConnectionPolicy(cfg.MultiConnectionPolicy)
+	if err != nil {
+		fatal(logger, "error configuring connection policy", err)
+	}
+	manager.SetConnectionPolicy(connectionPolicy)
+
+	// Connect to the other nodes so messages reach clients connected anywhere
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		nodeID, _ = os.Hostname()
+	}
+	var nodeBackplane backplane.Backplane
+	switch cfg.Backplane {
+	case "":
+	case "redis":
+		redisOptions, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			fatal(logger, "error parsing REDIS_URL", err)
+		}
+		redisBackplane := backplane.NewRedis(redis.NewClient(redisOptions), cfg.BackplaneChannelPrefix)
+		redisBackplane.Logger = logger
+		nodeBackplane = redisBackplane
+	default:
+		fatal(logger, "error configuring backplane", fmt.Errorf("unknown backplane %q", cfg.Backplane))
+	}
+	if nodeBackplane != nil {
+		manager.SetBackplane(nodeBackplane, nodeID)
+		if err := manager.StartBackplane(context.Background()); err != nil {
+			fatal(logger, "error starting backplane", err)
+		}
+		logger.Info("connected to backplane", slog.String("backplane", cfg.Backplane), slog.String("node_id", nodeID))
 
-	// Start the matchmaking service
-	go manager.StartMatchmakingService()
+		// Send messages for remote players straight to their node
+		manager.SetPresence(redisStore, cfg.PresenceTTL)
+		go manager.StartPresence(context.Background())
+	}
+
+	// Tell players about the sessions matched for them
+	go manager.StartNotifications(context.Background())
+
+	// Match players on this node, or on whichever node leads the deployment
+	matchmakingRunning := matchmakingService.Running
+	queueService := matchmakingService
+	matchmakingCtx, stopMatchmaking := context.WithCancel(context.Background())
+	defer stopMatchmaking()
+	matchmakingDone := make(chan struct{})
+	switch cfg.MatchmakingMode {
+	case "local":
+		go manager.StartMatchmakingService()
+	case "distributed":
+		if nodeBackplane == nil {
+			fatal(logger, "error configuring matchmaking", errors.New("distributed matchmaking needs a backplane"))
+		}
+		cluster := matchmaking.NewCluster(nodeID, matchmakingService, nodeBackplane, redisStore, cfg.LeaderLeaseTTL)
+		matchmakingRunning = cluster.Running
+		// Only the leader has a queue to show and drain
+		queueService = cluster.Engine
+		go func() {
+			defer close(matchmakingDone)
+			if err := cluster.Run(matchmakingCtx); err != nil {
+				fatal(logger, "error starting distributed matchmaking", err)
+			}
+		}()
+	default:
+		fatal(logger, "error configuring matchmaking", fmt.Errorf("unknown matchmaking mode %q", cfg.MatchmakingMode))
+	}
 
 	// Set up the WebSocket endpoint
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		websocket.HandleWebSocket(manager, w, r)
 	})
 
+	// Serve the same clients over server-sent events and POST where websockets are blocked
+	http.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleSSE(manager, w, r)
+	})
+
+	// Expose metrics in the Prometheus text format
+	http.Handle("/metrics", serviceMetrics.Handler())
+
+	// Report liveness and readiness to the orchestrator
+	checker := health.NewChecker(cfg.ReadinessTimeout)
+	checker.AddReadinessCheck("matchmaking", func(ctx context.Context) error {
+		if !matchmakingRunning() {
+			return errors.New("matchmaking loop is not running")
+		}
+		return nil
+	})
+	checker.AddReadinessCheck("session_db", func(ctx context.Context) error {
+		if pinger, ok := matchmakingService.SessionDB.(db.Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+	checker.AddReadinessCheck("message_store", func(ctx context.Context) error {
+		if pinger, ok := messageStore.(db.Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+	checker.AddReadinessCheck("backplane", func(ctx context.Context) error {
+		if pinger, ok := nodeBackplane.(db.Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	})
+	checker.AddReadinessCheck("redis", func(ctx context.Context) error {
+		if redisStore != nil {
+			return redisStore.Ping(ctx)
+		}
+		return nil
+	})
+	http.Handle("/healthz", checker.LivenessHandler())
+	http.Handle("/readyz", checker.ReadinessHandler())
+
+	// Expose the operator API when a token is configured
+	if cfg.AdminToken != "" {
+		adminServer := admin.NewServer(cfg.AdminToken, manager, queueService, sessionDB, logger)
+		adminServer.SetResults(resultService)
+		http.Handle("/admin/", adminServer)
+	}
+
 	// Start the server
 	port := ":8080"
-	log.Printf("Starting WebSocket server on %s", port)
-	log.Printf("Connect to ws://localhost%s/ws", port)
-	err = http.ListenAndServe(port, nil)
-	if err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	server := &http.Server{Addr: port}
+	go func() {
+		logger.Info("starting websocket server", slog.String("addr", port), slog.String("url", "ws://localhost"+port+"/ws"))
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal(logger, "error starting server", err)
+		}
+	}()
+
+	// On shutdown, report not ready so the orchestrator stops routing new
+	// players here, then stop accepting requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	logger.Info("draining before shutdown", slog.Duration("delay", cfg.ShutdownDrainDelay))
+	checker.SetDraining(true)
+	time.Sleep(cfg.ShutdownDrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("error shutting down server", logging.Err(err))
+	}
+	if cfg.MatchmakingMode == "distributed" {
+		// Hand the leader lease over before disconnecting
+		stopMatchmaking()
+		<-matchmakingDone
+	}
+	if redisStore != nil {
+		if err := redisStore.Close(); err != nil {
+			logger.Error("error closing redis store", logging.Err(err))
+		}
+	}
+	if nodeBackplane != nil {
+		if err := nodeBackplane.Close(); err != nil {
+			logger.Error("error closing backplane", logging.Err(err))
+		}
 	}
+	if closer, ok := messageStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("error closing offline message store", logging.Err(err))
+		}
+	}
+}
+
+// fatal logs err and exits
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, logging.Err(err))
+	os.Exit(1)
 }
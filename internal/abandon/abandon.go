@@ -0,0 +1,247 @@
+// Package abandon handles players who lose their last connection in the
+// middle of a session.
+//
+// The session pauses and its other members are told how long the player has
+// to come back. A player who is back in time resumes the session; one who
+// isn't abandons it, optionally forfeiting to the only player left and being
+// kept out of matchmaking for a while.
+package abandon
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+// Store finds the sessions a disconnected player may be playing
+type Store interface {
+	// OpenSessions returns the sessions of a player that aren't over
+	OpenSessions(playerID string) ([]matchmaking.Session, error)
+}
+
+// departure is a player's reconnection window in a session
+type departure struct {
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// absence tracks the players away from a session
+type absence struct {
+	session matchmaking.Session
+	away    map[string]*departure // player ID -> reconnection window
+	paused  bool                  // whether the tracker paused the session
+}
+
+// Tracker pauses the sessions of disconnected players and abandons them
+// when the players don't reconnect in time. Reconnection windows live on
+// the node where the player disconnected.
+type Tracker struct {
+	// Forfeit gives the win of an abandoned session to the only player left
+	Forfeit bool
+	// Penalty keeps a player who abandoned a session out of matchmaking
+	// for that long, zero for no penalty
+	Penalty time.Duration
+	// DisconnectedFunc tells the other members of a session that a player
+	// left and must be back by deadline
+	DisconnectedFunc func(session matchmaking.Session, playerID string, deadline time.Time)
+	// ReconnectedFunc tells the other members of a session that a player
+	// came back in time
+	ReconnectedFunc func(session matchmaking.Session, playerID string)
+	// OnlineFunc reports whether a player has a connection open on any
+	// node; a player who came back elsewhere doesn't abandon the session
+	OnlineFunc func(playerID string) bool
+	Logger     *slog.Logger
+
+	store    Store
+	sessions *matchmaking.Lifecycle
+	window   time.Duration
+
+	mutex     sync.Mutex
+	absences  map[string]*absence  // session ID -> players away
+	penalties map[string]time.Time // player ID -> end of penalty
+}
+
+// NewTracker creates a tracker finding sessions in store and moving them
+// through sessions. Disconnected players have window to come back.
+func NewTracker(store Store, sessions *matchmaking.Lifecycle, window time.Duration) *Tracker {
+	t := &Tracker{
+		Logger:    slog.Default(),
+		store:     store,
+		sessions:  sessions,
+		window:    window,
+		absences:  make(map[string]*absence),
+		penalties: make(map[string]time.Time),
+	}
+	sessions.Subscribe(t.sessionChanged)
+	return t
+}
+
+func (t *Tracker) logger() *slog.Logger {
+	return logging.OrDefault(t.Logger)
+}
+
+// Disconnected starts the reconnection window of a player who closed its
+// last connection in every active or paused session it plays
+func (t *Tracker) Disconnected(playerID string) {
+	sessions, err := t.store.OpenSessions(playerID)
+	if err != nil {
+		t.logger().Error("error listing sessions of disconnected player", slog.String(logging.KeyPlayerID, playerID), logging.Err(err))
+		return
+	}
+	for _, session := range sessions {
+		if session.Status == matchmaking.StatusActive || session.Status == matchmaking.StatusPaused {
+			t.leave(session, playerID)
+		}
+	}
+}
+
+// Reconnected stops the reconnection windows of a player who is back,
+// resuming the sessions nobody else is away from
+func (t *Tracker) Reconnected(playerID string) {
+	var back []matchmaking.Session
+	var resume []string
+	t.mutex.Lock()
+	for sessionID, a := range t.absences {
+		d, away := a.away[playerID]
+		if !away {
+			continue
+		}
+		d.timer.Stop()
+		delete(a.away, playerID)
+		back = append(back, a.session)
+		if len(a.away) == 0 {
+			delete(t.absences, sessionID)
+			if a.paused {
+				resume = append(resume, sessionID)
+			}
+		}
+	}
+	t.mutex.Unlock()
+
+	for _, session := range back {
+		t.logger().Info("player reconnected to session", slog.String(logging.KeySessionID, session.SessionID), slog.String(logging.KeyPlayerID, playerID))
+		if t.ReconnectedFunc != nil {
+			t.ReconnectedFunc(session, playerID)
+		}
+	}
+	for _, sessionID := range resume {
+		if _, err := t.sessions.Transition(sessionID, matchmaking.StatusActive); err != nil {
+			t.logger().Warn("error resuming session", slog.String(logging.KeySessionID, sessionID), logging.Err(err))
+		}
+	}
+}
+
+// Penalized reports whether a player is kept out of matchmaking and until when
+func (t *Tracker) Penalized(playerID string) (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	until, exists := t.penalties[playerID]
+	if !exists {
+		return time.Time{}, false
+	}
+	if !time.Now().Before(until) {
+		delete(t.penalties, playerID)
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// leave starts a player's reconnection window in a session, pausing it
+func (t *Tracker) leave(session matchmaking.Session, playerID string) {
+	sessionID := session.SessionID
+	d := &departure{deadline: time.Now().Add(t.window)}
+
+	t.mutex.Lock()
+	a, exists := t.absences[sessionID]
+	if !exists {
+		a = &absence{session: session, away: make(map[string]*departure)}
+		t.absences[sessionID] = a
+	}
+	if _, away := a.away[playerID]; away {
+		t.mutex.Unlock()
+		return
+	}
+	a.away[playerID] = d
+	d.timer = time.AfterFunc(t.window, func() { t.expire(sessionID, playerID, d) })
+	pause := !a.paused && session.Status == matchmaking.StatusActive
+	a.paused = a.paused || pause
+	t.mutex.Unlock()
+
+	t.logger().Info("player left session", slog.String(logging.KeySessionID, sessionID), slog.String(logging.KeyPlayerID, playerID), slog.Time("reconnect_by", d.deadline))
+	if pause {
+		if _, err := t.sessions.Transition(sessionID, matchmaking.StatusPaused); err != nil {
+			// Paused or ended elsewhere meanwhile; resuming it isn't ours to do
+			t.logger().Debug("session not paused", slog.String(logging.KeySessionID, sessionID), logging.Err(err))
+			t.mutex.Lock()
+			a.paused = false
+			t.mutex.Unlock()
+		}
+	}
+	if t.DisconnectedFunc != nil {
+		t.DisconnectedFunc(session, playerID, d.deadline)
+	}
+}
+
+// expire abandons a session when a player's reconnection window closes
+func (t *Tracker) expire(sessionID, playerID string, d *departure) {
+	// The player may have come back on another node
+	if t.OnlineFunc != nil && t.OnlineFunc(playerID) {
+		t.Reconnected(playerID)
+		return
+	}
+
+	t.mutex.Lock()
+	a, exists := t.absences[sessionID]
+	if !exists || a.away[playerID] != d {
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.absences, sessionID)
+	for _, other := range a.away {
+		other.timer.Stop()
+	}
+	var remaining []string
+	for _, id := range a.session.Players() {
+		if _, away := a.away[id]; !away {
+			remaining = append(remaining, id)
+		}
+	}
+	if t.Penalty > 0 {
+		t.penalties[playerID] = time.Now().Add(t.Penalty)
+	}
+	t.mutex.Unlock()
+
+	var outcome *message.Outcome
+	if t.Forfeit && len(remaining) == 1 {
+		outcome = &message.Outcome{WinnerID: remaining[0], Resolution: message.ResolutionForfeit}
+	}
+	t.logger().Info("player abandoned session", slog.String(logging.KeySessionID, sessionID), slog.String(logging.KeyPlayerID, playerID))
+	if _, err := t.sessions.Abandon(sessionID, outcome); err != nil {
+		if errors.Is(err, db.ErrSessionFinished) || errors.Is(err, db.ErrIllegalTransition) {
+			t.logger().Debug("session already over", slog.String(logging.KeySessionID, sessionID), logging.Err(err))
+			return
+		}
+		t.logger().Error("error abandoning session", slog.String(logging.KeySessionID, sessionID), logging.Err(err))
+	}
+}
+
+// sessionChanged forgets the players away from a session that ended otherwise
+func (t *Tracker) sessionChanged(event matchmaking.SessionEvent) {
+	if !event.To.Over() {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if a, exists := t.absences[event.Session.SessionID]; exists {
+		for _, d := range a.away {
+			d.timer.Stop()
+		}
+		delete(t.absences, event.Session.SessionID)
+	}
+}
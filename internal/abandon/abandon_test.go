@@ -0,0 +1,151 @@
+package abandon
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+// events collects the statuses a lifecycle moves sessions to
+type events struct {
+	mutex    sync.Mutex
+	statuses map[string][]matchmaking.Status // session ID -> statuses
+	sessions map[string]matchmaking.Session  // session ID -> last version
+}
+
+func (e *events) add(event matchmaking.SessionEvent) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.statuses[event.Session.SessionID] = append(e.statuses[event.Session.SessionID], event.To)
+	e.sessions[event.Session.SessionID] = event.Session
+}
+
+// wait waits for a session to reach status and returns it
+func (e *events) wait(t *testing.T, sessionID string, status matchmaking.Status) matchmaking.Session {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		e.mutex.Lock()
+		session := e.sessions[sessionID]
+		e.mutex.Unlock()
+		if session.Status == status {
+			return session
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected session %s to become %s, got %+v", sessionID, status, session)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (e *events) get(sessionID string) []matchmaking.Status {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]matchmaking.Status(nil), e.statuses[sessionID]...)
+}
+
+// newTestTracker creates a tracker over an active session between
+// playerIDs. The local DB is shared by the tests, so each uses its own players.
+func newTestTracker(window time.Duration, sessionID string, playerIDs ...string) (*Tracker, *events) {
+	store := local.DB{}
+	lifecycle := matchmaking.NewLifecycle(store)
+	lifecycle.Create(sessionID, matchmaking.ModeMatchmaking, playerIDs)
+	lifecycle.Transition(sessionID, matchmaking.StatusActive)
+	e := &events{statuses: make(map[string][]matchmaking.Status), sessions: make(map[string]matchmaking.Session)}
+	lifecycle.Subscribe(e.add)
+	return NewTracker(store, lifecycle, window), e
+}
+
+func TestAbandonAfterWindow(t *testing.T) {
+	tracker, e := newTestTracker(20*time.Millisecond, "abandon-forfeit", "alice", "bob")
+	tracker.Forfeit = true
+	tracker.Penalty = time.Minute
+	var mutex sync.Mutex
+	var deadline time.Time
+	tracker.DisconnectedFunc = func(session matchmaking.Session, playerID string, reconnectBy time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if session.SessionID == "abandon-forfeit" && playerID == "alice" {
+			deadline = reconnectBy
+		}
+	}
+
+	tracker.Disconnected("alice")
+	mutex.Lock()
+	if deadline.IsZero() {
+		t.Error("Expected the other players to be told alice left")
+	}
+	mutex.Unlock()
+	if _, penalized := tracker.Penalized("alice"); penalized {
+		t.Error("Expected no penalty before the window closed")
+	}
+
+	session := e.wait(t, "abandon-forfeit", matchmaking.StatusAbandoned)
+	if session.Outcome == nil || session.Outcome.WinnerID != "bob" || session.Outcome.Resolution != message.ResolutionForfeit || session.EndedAt == nil {
+		t.Errorf("Expected bob to win by forfeit, got %+v", session)
+	}
+	statuses := e.get("abandon-forfeit")
+	if len(statuses) != 2 || statuses[0] != matchmaking.StatusPaused {
+		t.Errorf("Expected the session to pause then be abandoned, got %v", statuses)
+	}
+	if until, penalized := tracker.Penalized("alice"); !penalized || until.Before(time.Now()) {
+		t.Errorf("Expected alice to be penalized, got %v %v", until, penalized)
+	}
+	if _, penalized := tracker.Penalized("bob"); penalized {
+		t.Error("Expected bob not to be penalized")
+	}
+}
+
+func TestReconnectInTime(t *testing.T) {
+	tracker, e := newTestTracker(50*time.Millisecond, "abandon-reconnected", "carol", "dave")
+	var reconnected []string
+	tracker.ReconnectedFunc = func(session matchmaking.Session, playerID string) {
+		reconnected = append(reconnected, playerID)
+	}
+
+	tracker.Disconnected("carol")
+	e.wait(t, "abandon-reconnected", matchmaking.StatusPaused)
+	tracker.Reconnected("carol")
+	e.wait(t, "abandon-reconnected", matchmaking.StatusActive)
+	if len(reconnected) != 1 || reconnected[0] != "carol" {
+		t.Errorf("Expected the other players to be told carol is back, got %v", reconnected)
+	}
+
+	// The closed window doesn't abandon the session
+	time.Sleep(100 * time.Millisecond)
+	if statuses := e.get("abandon-reconnected"); len(statuses) != 2 {
+		t.Errorf("Expected the session to pause and resume only, got %v", statuses)
+	}
+}
+
+func TestReconnectElsewhere(t *testing.T) {
+	tracker, e := newTestTracker(20*time.Millisecond, "abandon-elsewhere", "erin", "frank")
+	tracker.OnlineFunc = func(playerID string) bool { return playerID == "erin" }
+
+	tracker.Disconnected("erin")
+	e.wait(t, "abandon-elsewhere", matchmaking.StatusPaused)
+	e.wait(t, "abandon-elsewhere", matchmaking.StatusActive)
+	if _, penalized := tracker.Penalized("erin"); penalized {
+		t.Error("Expected no penalty for a player back on another node")
+	}
+}
+
+func TestSessionEndsWhileAway(t *testing.T) {
+	tracker, e := newTestTracker(30*time.Millisecond, "abandon-finished", "grace", "heidi")
+	tracker.Penalty = time.Minute
+
+	tracker.Disconnected("grace")
+	tracker.sessions.Finish("abandon-finished", message.Outcome{WinnerID: "grace"})
+
+	time.Sleep(80 * time.Millisecond)
+	if statuses := e.get("abandon-finished"); len(statuses) != 2 || statuses[1] != matchmaking.StatusFinished {
+		t.Errorf("Expected the session to stay finished, got %v", statuses)
+	}
+	if _, penalized := tracker.Penalized("grace"); penalized {
+		t.Error("Expected no penalty once the session finished")
+	}
+}
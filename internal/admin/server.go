@@ -0,0 +1,391 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/results"
+	"simple-multiplayer-service/internal/websocket"
+)
+
+// SessionStore is implemented by session backends that can be inspected
+type SessionStore interface {
+	ListSessions() ([]matchmaking.Session, error)
+	GetSession(sessionID string) (matchmaking.Session, error)
+}
+
+// ClientInfo describes a connected client
+type ClientInfo struct {
+	ID          string            `json:"id"`
+	PlayerID    string            `json:"playerId"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	IPAddress   string            `json:"ipAddress"`
+	ConnectedAt time.Time         `json:"connectedAt"`
+}
+
+// QueueInfo describes the matchmaking queue
+type QueueInfo struct {
+	Waiting []matchmaking.WaitingPlayer `json:"waiting"`
+	Pending int                         `json:"pending"`
+}
+
+// Server is the operator API for inspecting and managing the live server
+type Server struct {
+	token       string
+	manager     *websocket.ConnectionManager
+	matchmaking *matchmaking.Service
+	sessions    SessionStore
+	results     *results.Service
+	logger      *slog.Logger
+	mux         *http.ServeMux
+}
+
+// NewServer creates the admin API. Every request must carry token as a bearer token.
+func NewServer(token string, manager *websocket.ConnectionManager, matchmakingService *matchmaking.Service, sessions SessionStore, logger *slog.Logger) *Server {
+	s := &Server{
+		token:       token,
+		manager:     manager,
+		matchmaking: matchmakingService,
+		sessions:    sessions,
+		logger:      logging.OrDefault(logger),
+		mux:         http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/admin/clients", s.handleClients)
+	s.mux.HandleFunc("/admin/clients/", s.handleClientAction)
+	s.mux.HandleFunc("/admin/queue", s.handleQueue)
+	s.mux.HandleFunc("/admin/queue/drain", s.handleDrainQueue)
+	s.mux.HandleFunc("/admin/sessions", s.handleSessions)
+	s.mux.HandleFunc("/admin/sessions/", s.handleSessionAction)
+	s.mux.HandleFunc("/admin/players/", s.handlePlayerAction)
+	s.mux.HandleFunc("/admin/broadcast", s.handleBroadcast)
+
+	return s
+}
+
+// SetResults lets operators settle session results and read match history
+func (s *Server) SetResults(svc *results.Service) {
+	s.results = svc
+}
+
+// ServeHTTP authenticates the request and routes it
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || s.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// GET /admin/clients
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	clients := s.manager.ListClients()
+	infos := make([]ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		info := ClientInfo{ID: c.ID, PlayerID: c.PlayerID(), IPAddress: c.IPAddress, ConnectedAt: c.ConnectedAt}
+		if c.Player != nil {
+			info.DisplayName = c.Player.DisplayName
+			info.Metadata = c.Player.Metadata
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// POST /admin/clients/{id}/kick
+func (s *Server) handleClientAction(w http.ResponseWriter, r *http.Request) {
+	clientID, action := splitAction(r.URL.Path, "/admin/clients/")
+	if action != "kick" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if err := s.manager.KickClient(clientID, "kicked by operator"); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.logger.Info("admin kicked client", slog.String(logging.KeyClientID, clientID))
+	writeJSON(w, http.StatusOK, map[string]string{"kicked": clientID})
+}
+
+// GET /admin/queue
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, QueueInfo{
+		Waiting: s.matchmaking.Waiting(),
+		Pending: len(s.matchmaking.SessionQueue),
+	})
+}
+
+// POST /admin/queue/drain
+func (s *Server) handleDrainQueue(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	drained := s.matchmaking.Drain()
+	for _, playerID := range drained {
+		notice := message.NewServerMessage(playerID, message.MatchmakingCancelled{
+			Type:   message.MatchmakingCancelledType,
+			Reason: "queue drained by operator",
+		})
+		if err := s.manager.SendMessageToClient(notice); err != nil {
+			s.logger.Warn("error notifying drained player", slog.String(logging.KeyPlayerID, playerID), logging.Err(err))
+		}
+	}
+	s.logger.Info("admin drained matchmaking queue", slog.Int("players", len(drained)))
+	writeJSON(w, http.StatusOK, map[string][]string{"drained": drained})
+}
+
+// GET /admin/sessions
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	sessions, err := s.sessions.ListSessions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// POST /admin/sessions/{id}/end
+// POST /admin/sessions/{id}/pause
+// POST /admin/sessions/{id}/resume
+// POST /admin/sessions/{id}/result
+// GET /admin/sessions/{id}/spectators
+func (s *Server) handleSessionAction(w http.ResponseWriter, r *http.Request) {
+	sessionID, action := splitAction(r.URL.Path, "/admin/sessions/")
+	switch action {
+	case "end":
+		s.handleEndSession(w, r, sessionID)
+	case "pause":
+		s.handleSessionTransition(w, r, sessionID, matchmaking.StatusPaused)
+	case "resume":
+		s.handleSessionTransition(w, r, sessionID, matchmaking.StatusActive)
+	case "result":
+		s.handleSessionResult(w, r, sessionID)
+	case "spectators":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.manager.Spectators(sessionID))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) handleEndSession(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	session, err := s.matchmaking.Sessions.Abandon(sessionID, nil)
+	if errors.Is(err, db.ErrIllegalTransition) || errors.Is(err, db.ErrSessionFinished) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.manager.EndSession(session, "ended by operator")
+	s.logger.Info("admin ended session", slog.String(logging.KeySessionID, sessionID))
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleSessionTransition(w http.ResponseWriter, r *http.Request, sessionID string, to matchmaking.Status) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	session, err := s.matchmaking.Sessions.Transition(sessionID, to)
+	if errors.Is(err, db.ErrIllegalTransition) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	s.logger.Info("admin changed session status", slog.String(logging.KeySessionID, sessionID), slog.String("status", string(to)))
+	writeJSON(w, http.StatusOK, session)
+}
+
+// POST /admin/sessions/{id}/result {"winner_id": "...", "draw": false, "scores": {...}}
+func (s *Server) handleSessionResult(w http.ResponseWriter, r *http.Request, sessionID string) {
+	if s.results == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var outcome message.Outcome
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&outcome); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be {\"winner_id\": \"...\"} or {\"draw\": true}")
+		return
+	}
+
+	session, err := s.results.Resolve(sessionID, outcome)
+	switch {
+	case errors.Is(err, results.ErrSessionNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, results.ErrSessionFinished):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, results.ErrInvalidOutcome):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.logger.Info("admin settled session result", slog.String(logging.KeySessionID, sessionID), slog.String("winner_id", outcome.WinnerID))
+	writeJSON(w, http.StatusOK, session)
+}
+
+// GET /admin/players/{id}/history?limit=N
+func (s *Server) handlePlayerAction(w http.ResponseWriter, r *http.Request) {
+	playerID, action := splitAction(r.URL.Path, "/admin/players/")
+	if action != "history" || s.results == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	limit := message.MaxMatchHistory
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > message.MaxMatchHistory {
+			writeError(w, http.StatusBadRequest, "limit must be 1 to "+strconv.Itoa(message.MaxMatchHistory))
+			return
+		}
+		limit = n
+	}
+
+	sessions, err := s.results.History(playerID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// Broadcast targets
+const (
+	TargetAll     = "all"
+	TargetQueue   = "queue"
+	TargetSession = "session"
+)
+
+// BroadcastRequest is the body of POST /admin/broadcast
+type BroadcastRequest struct {
+	Message string `json:"message"`
+	// Target is all (default), queue or session
+	Target    string `json:"target,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// POST /admin/broadcast {"message": "...", "target": "all|queue|session", "session_id": "..."}
+func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var body BroadcastRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil || body.Message == "" {
+		writeError(w, http.StatusBadRequest, "body must be {\"message\": \"...\"}")
+		return
+	}
+
+	announcement := message.NewServerMessage("", message.Announcement{
+		Type:    message.AnnouncementType,
+		Message: body.Message,
+	})
+
+	var delivered int
+	switch body.Target {
+	case "", TargetAll:
+		delivered = s.manager.Broadcast(announcement)
+	case TargetQueue:
+		delivered = s.manager.BroadcastToQueue(announcement)
+	case TargetSession:
+		session, err := s.sessions.GetSession(body.SessionID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		delivered = s.manager.BroadcastToSession(session, announcement)
+	default:
+		writeError(w, http.StatusBadRequest, "target must be all, queue or session")
+		return
+	}
+
+	s.logger.Info("admin broadcast announcement", slog.String("target", body.Target), slog.Int("delivered", delivered))
+	writeJSON(w, http.StatusOK, map[string]int{"delivered": delivered})
+}
+
+// splitAction splits "/prefix/{id}/{action}" into id and action
+func splitAction(path, prefix string) (string, string) {
+	id, action, found := strings.Cut(strings.TrimPrefix(path, prefix), "/")
+	if !found || id == "" {
+		return "", ""
+	}
+	return id, action
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, text string) {
+	writeJSON(w, status, map[string]string{"error": text})
+}
@@ -0,0 +1,475 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/results"
+	"simple-multiplayer-service/internal/spectate"
+	ws "simple-multiplayer-service/internal/websocket"
+
+	"github.com/gorilla/websocket"
+)
+
+const testToken = "admin-secret"
+
+// MockSessionStore is an in-memory SessionStore
+type MockSessionStore struct {
+	sessions map[string]matchmaking.Session
+}
+
+func (m *MockSessionStore) CreateSession(sessionID, mode string, playerIDs []string, createdAt time.Time) error {
+	m.sessions[sessionID] = matchmaking.NewSession(sessionID, mode, playerIDs, createdAt)
+	return nil
+}
+
+func (m *MockSessionStore) ListSessions() ([]matchmaking.Session, error) {
+	sessions := make([]matchmaking.Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (m *MockSessionStore) GetSession(sessionID string) (matchmaking.Session, error) {
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return matchmaking.Session{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	return session, nil
+}
+
+func (m *MockSessionStore) SetSessionStatus(sessionID, status string, at time.Time) (string, error) {
+	session, ok := m.sessions[sessionID]
+	if !ok {
+		return "", fmt.Errorf("session %s not found", sessionID)
+	}
+	from, err := session.Transition(matchmaking.Status(status), at)
+	if err == nil {
+		m.sessions[sessionID] = session
+	}
+	return string(from), err
+}
+
+func (m *MockSessionStore) FinishSession(sessionID string, outcome message.Outcome, endedAt time.Time) (string, error) {
+	from, err := m.SetSessionStatus(sessionID, string(matchmaking.StatusFinished), endedAt)
+	if err == nil {
+		session := m.sessions[sessionID]
+		session.Outcome = &outcome
+		m.sessions[sessionID] = session
+	}
+	return from, err
+}
+
+func (m *MockSessionStore) AbandonSession(sessionID string, outcome *message.Outcome, endedAt time.Time) (string, error) {
+	from, err := m.SetSessionStatus(sessionID, string(matchmaking.StatusAbandoned), endedAt)
+	if err == nil {
+		session := m.sessions[sessionID]
+		session.Outcome = outcome
+		m.sessions[sessionID] = session
+	}
+	return from, err
+}
+
+func (m *MockSessionStore) PlayerSessions(playerID string, limit int) ([]matchmaking.Session, error) {
+	sessions := []matchmaking.Session{}
+	for _, session := range m.sessions {
+		if session.Finished() && session.HasPlayer(playerID) && len(sessions) < limit {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+type testEnv struct {
+	manager  *ws.ConnectionManager
+	mmSvc    *matchmaking.Service
+	sessions *MockSessionStore
+	admin    *Server
+	wsURL    string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	sessions := &MockSessionStore{sessions: make(map[string]matchmaking.Session)}
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, sessions, notifSvc)
+	manager := ws.NewConnectionManager(mmSvc, notifSvc)
+	manager.SetSpectators(spectate.NewManager(sessions, 0, 0))
+	resultSvc := results.NewService(sessions, mmSvc.Sessions, time.Minute)
+	manager.SetResults(resultSvc)
+	admin := NewServer(testToken, manager, mmSvc, sessions, nil)
+	admin.SetResults(resultSvc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.HandleWebSocket(manager, w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return &testEnv{
+		manager:  manager,
+		mmSvc:    mmSvc,
+		sessions: sessions,
+		admin:    admin,
+		wsURL:    "ws" + strings.TrimPrefix(server.URL, "http") + "/ws",
+	}
+}
+
+// connect dials the websocket server and returns the connection and its ID
+func (e *testEnv) connect(t *testing.T) (*websocket.Conn, string) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(e.wsURL, nil)
+	if err != nil {
+		t.Fatalf("Could not connect to WebSocket server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var welcome message.Message
+	if err := conn.ReadJSON(&welcome); err != nil {
+		t.Fatalf("Error reading welcome message: %v", err)
+	}
+	return conn, welcome.To
+}
+
+// startSession creates an active session between playerIDs
+func (e *testEnv) startSession(t *testing.T, sessionID string, playerIDs ...string) {
+	t.Helper()
+	if _, err := e.mmSvc.Sessions.Create(sessionID, matchmaking.ModeMatchmaking, playerIDs); err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	if _, err := e.mmSvc.Sessions.Transition(sessionID, matchmaking.StatusActive); err != nil {
+		t.Fatalf("Error starting session: %v", err)
+	}
+}
+
+func (e *testEnv) do(method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	e.admin.ServeHTTP(w, r)
+	return w
+}
+
+func readContent(t *testing.T, conn *websocket.Conn, into interface{}) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg message.Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Error reading message: %v", err)
+	}
+	if msg.From != message.ServerID {
+		t.Errorf("Expected message from '%s', got '%s'", message.ServerID, msg.From)
+	}
+	if err := json.Unmarshal([]byte(msg.Content), into); err != nil {
+		t.Fatalf("Error decoding content: %v", err)
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	env := newTestEnv(t)
+
+	for name, header := range map[string]string{
+		"missing": "",
+		"wrong":   "Bearer nope",
+		"scheme":  "Basic " + testToken,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/admin/clients", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		env.admin.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, w.Code)
+		}
+	}
+
+	if w := env.do(http.MethodGet, "/admin/clients", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with a valid token, got %d", w.Code)
+	}
+	if w := env.do(http.MethodPost, "/admin/clients", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST /admin/clients, got %d", w.Code)
+	}
+}
+
+func TestAdminClientsAndKick(t *testing.T) {
+	env := newTestEnv(t)
+	conn, clientID := env.connect(t)
+
+	w := env.do(http.MethodGet, "/admin/clients", "")
+	var clients []ClientInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &clients); err != nil {
+		t.Fatalf("Error decoding clients: %v", err)
+	}
+	if len(clients) != 1 || clients[0].ID != clientID || clients[0].ConnectedAt.IsZero() {
+		t.Fatalf("Expected one client '%s' with a connection time, got %+v", clientID, clients)
+	}
+
+	if w := env.do(http.MethodPost, "/admin/clients/"+clientID+"/kick", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 kicking client, got %d", w.Code)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected policy violation close, got %v", err)
+	}
+	if _, exists := env.manager.GetClient(clientID); exists {
+		t.Error("Expected kicked client to be unregistered")
+	}
+
+	if w := env.do(http.MethodPost, "/admin/clients/unknown/kick", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 kicking unknown client, got %d", w.Code)
+	}
+}
+
+func TestAdminQueueDrain(t *testing.T) {
+	env := newTestEnv(t)
+	go env.mmSvc.Start()
+
+	conn, playerID := env.connect(t)
+	env.mmSvc.SessionQueue <- message.MatchmakingRequest{PlayerID: playerID, ConnectionID: playerID}
+
+	deadline := time.Now().Add(time.Second)
+	for len(env.mmSvc.Waiting()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var queue QueueInfo
+	json.Unmarshal(env.do(http.MethodGet, "/admin/queue", "").Body.Bytes(), &queue)
+	if len(queue.Waiting) != 1 || queue.Waiting[0].PlayerID != playerID {
+		t.Fatalf("Expected '%s' to be waiting, got %+v", playerID, queue)
+	}
+
+	if w := env.do(http.MethodPost, "/admin/queue/drain", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 draining queue, got %d", w.Code)
+	}
+	var cancelled message.MatchmakingCancelled
+	readContent(t, conn, &cancelled)
+	if cancelled.Type != message.MatchmakingCancelledType {
+		t.Errorf("Expected type '%s', got '%s'", message.MatchmakingCancelledType, cancelled.Type)
+	}
+	if waiting := env.mmSvc.Waiting(); len(waiting) != 0 {
+		t.Errorf("Expected empty queue after drain, got %+v", waiting)
+	}
+}
+
+func TestAdminEndSession(t *testing.T) {
+	env := newTestEnv(t)
+	conn1, player1 := env.connect(t)
+	conn2, player2 := env.connect(t)
+	env.startSession(t, "session1", player1, player2)
+
+	var sessions []matchmaking.Session
+	json.Unmarshal(env.do(http.MethodGet, "/admin/sessions", "").Body.Bytes(), &sessions)
+	if len(sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(sessions))
+	}
+
+	// A third client watches the session
+	spectatorConn, spectatorID := env.connect(t)
+	spectatorConn.WriteJSON(message.Message{Content: `{"type":"spectate","session_id":"session1"}`})
+	var spectating message.Spectating
+	readContent(t, spectatorConn, &spectating)
+	var spectators []message.Spectator
+	json.Unmarshal(env.do(http.MethodGet, "/admin/sessions/session1/spectators", "").Body.Bytes(), &spectators)
+	if len(spectators) != 1 || spectators[0].ConnectionID != spectatorID {
+		t.Errorf("Expected the spectator to be listed, got %+v", spectators)
+	}
+
+	events := make(chan matchmaking.SessionEvent, 1)
+	env.mmSvc.Sessions.Subscribe(func(event matchmaking.SessionEvent) {
+		select {
+		case events <- event:
+		default:
+		}
+	})
+
+	if w := env.do(http.MethodPost, "/admin/sessions/missing/end", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown session, got %d", w.Code)
+	}
+	w := env.do(http.MethodPost, "/admin/sessions/session1/end", "")
+	var session matchmaking.Session
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if w.Code != http.StatusOK || session.Status != matchmaking.StatusAbandoned || session.EndedAt == nil {
+		t.Fatalf("Expected the session to be abandoned, got %d %s", w.Code, w.Body.String())
+	}
+	select {
+	case event := <-events:
+		if event.Session.SessionID != "session1" || event.From != matchmaking.StatusActive || event.To != matchmaking.StatusAbandoned {
+			t.Errorf("Unexpected session event %+v", event)
+		}
+	default:
+		t.Error("Expected ending the session to emit a session event")
+	}
+
+	for _, conn := range []*websocket.Conn{conn1, conn2} {
+		var status message.SessionStatus
+		readContent(t, conn, &status)
+		if status.Type != message.SessionStatusType || status.Status != "abandoned" {
+			t.Errorf("Expected the players to learn the session was abandoned, got %+v", status)
+		}
+		var ended message.SessionEnded
+		readContent(t, conn, &ended)
+		if ended.SessionID != "session1" || ended.Reason != "ended by operator" {
+			t.Errorf("Expected session 'session1' to end, got %+v", ended)
+		}
+	}
+	var relayed message.SpectatedMessage
+	readContent(t, spectatorConn, &relayed)
+	var stopped message.SpectatingStopped
+	readContent(t, spectatorConn, &stopped)
+	if relayed.Type != message.SpectatedMessageType || stopped.Type != message.SpectatingStoppedType || stopped.SessionID != "session1" {
+		t.Errorf("Expected the spectator to see the end and stop, got %+v, %+v", relayed, stopped)
+	}
+
+	var history []matchmaking.Session
+	json.Unmarshal(env.do(http.MethodGet, "/admin/players/"+player1+"/history?limit=5", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].SessionID != "session1" || history[0].Status != matchmaking.StatusAbandoned {
+		t.Errorf("Expected the ended session in the player's history, got %+v", history)
+	}
+
+	if w := env.do(http.MethodPost, "/admin/sessions/session1/end", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 ending a session that is over, got %d", w.Code)
+	}
+}
+
+func TestAdminPauseAndResumeSession(t *testing.T) {
+	env := newTestEnv(t)
+	conn1, player1 := env.connect(t)
+	conn2, player2 := env.connect(t)
+	env.startSession(t, "session1", player1, player2)
+
+	if w := env.do(http.MethodPost, "/admin/sessions/missing/pause", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown session, got %d", w.Code)
+	}
+	if w := env.do(http.MethodPost, "/admin/sessions/session1/resume", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 resuming an active session, got %d", w.Code)
+	}
+
+	w := env.do(http.MethodPost, "/admin/sessions/session1/pause", "")
+	var session matchmaking.Session
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if w.Code != http.StatusOK || session.Status != matchmaking.StatusPaused {
+		t.Fatalf("Expected the session to pause, got %d %s", w.Code, w.Body.String())
+	}
+	for _, conn := range []*websocket.Conn{conn1, conn2} {
+		var status message.SessionStatus
+		readContent(t, conn, &status)
+		if status.Type != message.SessionStatusType || status.Status != "paused" || status.Previous != "active" {
+			t.Errorf("Expected both players to learn the session paused, got %+v", status)
+		}
+	}
+
+	if w := env.do(http.MethodPost, "/admin/sessions/session1/resume", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 resuming, got %d", w.Code)
+	}
+	var status message.SessionStatus
+	readContent(t, conn1, &status)
+	if status.Status != "active" || status.Previous != "paused" {
+		t.Errorf("Expected the session to resume, got %+v", status)
+	}
+}
+
+func TestAdminSessionResult(t *testing.T) {
+	env := newTestEnv(t)
+	conn1, player1 := env.connect(t)
+	conn2, player2 := env.connect(t)
+	env.startSession(t, "session1", player1, player2)
+
+	if w := env.do(http.MethodPost, "/admin/sessions/missing/result", `{"draw": true}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown session, got %d", w.Code)
+	}
+	if w := env.do(http.MethodPost, "/admin/sessions/session1/result", `{"winner_id": "someone-else"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a winner outside the session, got %d", w.Code)
+	}
+
+	body := fmt.Sprintf(`{"winner_id": %q, "scores": {%q: 2}}`, player2, player2)
+	w := env.do(http.MethodPost, "/admin/sessions/session1/result", body)
+	var session matchmaking.Session
+	json.Unmarshal(w.Body.Bytes(), &session)
+	if w.Code != http.StatusOK || session.Outcome == nil || session.Outcome.Resolution != message.ResolutionAuthoritative {
+		t.Fatalf("Expected an authoritative result, got %d %s", w.Code, w.Body.String())
+	}
+	for _, conn := range []*websocket.Conn{conn1, conn2} {
+		var finished message.SessionFinished
+		readContent(t, conn, &finished)
+		if finished.SessionID != "session1" || finished.Outcome.WinnerID != player2 {
+			t.Errorf("Expected both players to learn the outcome, got %+v", finished)
+		}
+	}
+	if w := env.do(http.MethodPost, "/admin/sessions/session1/result", `{"draw": true}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 settling a finished session, got %d", w.Code)
+	}
+
+	var history []matchmaking.Session
+	json.Unmarshal(env.do(http.MethodGet, "/admin/players/"+player1+"/history?limit=5", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].SessionID != "session1" {
+		t.Errorf("Expected the session in the player's history, got %+v", history)
+	}
+	if w := env.do(http.MethodGet, "/admin/players/"+player1+"/history?limit=0", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad limit, got %d", w.Code)
+	}
+}
+
+func TestAdminBroadcast(t *testing.T) {
+	env := newTestEnv(t)
+	conn1, _ := env.connect(t)
+	conn2, _ := env.connect(t)
+
+	if w := env.do(http.MethodPost, "/admin/broadcast", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for empty broadcast, got %d", w.Code)
+	}
+
+	w := env.do(http.MethodPost, "/admin/broadcast", `{"message": "maintenance in 5 minutes"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 broadcasting, got %d", w.Code)
+	}
+	for _, conn := range []*websocket.Conn{conn1, conn2} {
+		var announcement message.Announcement
+		readContent(t, conn, &announcement)
+		if announcement.Message != "maintenance in 5 minutes" {
+			t.Errorf("Expected announcement text, got '%s'", announcement.Message)
+		}
+	}
+}
+
+func TestAdminBroadcastToSession(t *testing.T) {
+	env := newTestEnv(t)
+	conn1, player1 := env.connect(t)
+	_, player2 := env.connect(t)
+	conn3, _ := env.connect(t)
+	env.startSession(t, "session1", player1, player2)
+
+	if w := env.do(http.MethodPost, "/admin/broadcast", `{"message": "hi", "target": "session", "session_id": "missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown session, got %d", w.Code)
+	}
+	if w := env.do(http.MethodPost, "/admin/broadcast", `{"message": "hi", "target": "everyone"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown target, got %d", w.Code)
+	}
+
+	w := env.do(http.MethodPost, "/admin/broadcast", `{"message": "round 2", "target": "session", "session_id": "session1"}`)
+	var result map[string]int
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if w.Code != http.StatusOK || result["delivered"] != 2 {
+		t.Fatalf("Expected session broadcast to reach 2 clients, got %d %v", w.Code, result)
+	}
+
+	var announcement message.Announcement
+	readContent(t, conn1, &announcement)
+	if announcement.Message != "round 2" {
+		t.Errorf("Expected announcement 'round 2', got '%s'", announcement.Message)
+	}
+
+	// Clients outside the session receive nothing
+	conn3.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := conn3.ReadMessage(); err == nil {
+		t.Error("Expected client outside the session to receive nothing")
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SubprotocolBearer is the websocket subprotocol a client offers, followed by
+// the token itself, when it cannot set headers (e.g. browsers).
+const SubprotocolBearer = "bearer"
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid bearer token")
+	ErrNoKeys       = errors.New("no verification keys configured")
+)
+
+// Options configures how tokens are located and verified
+type Options struct {
+	HMACSecret string
+	JWKSFile   string
+	Issuer     string
+	Audience   string
+	QueryParam string
+}
+
+// Claims are the token claims the service understands
+type Claims struct {
+	jwt.RegisteredClaims
+	Name     string            `json:"name,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Identity is the verified caller of an upgrade request
+type Identity struct {
+	UserID      string
+	DisplayName string
+	Metadata    map[string]string
+	// Subprotocol is set when the token was presented through the
+	// Sec-WebSocket-Protocol header and must be echoed back on upgrade.
+	Subprotocol string
+}
+
+// Authenticator validates JWTs presented on the websocket upgrade request
+type Authenticator struct {
+	hmacSecret []byte
+	rsaKeys    *KeySet
+	parser     *jwt.Parser
+	queryParam string
+}
+
+// NewAuthenticator creates an authenticator accepting HMAC signed tokens when
+// a secret is set and RSA signed tokens when a JWKS file is set.
+func NewAuthenticator(opts Options) (*Authenticator, error) {
+	a := &Authenticator{queryParam: opts.QueryParam}
+
+	methods := make([]string, 0, 6)
+	if opts.HMACSecret != "" {
+		a.hmacSecret = []byte(opts.HMACSecret)
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if opts.JWKSFile != "" {
+		keys, err := LoadKeySet(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKeys = keys
+		methods = append(methods, "RS256", "RS384", "RS512")
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	a.parser = jwt.NewParser(parserOpts...)
+
+	return a, nil
+}
+
+// Authenticate extracts the token from the request and returns the verified identity
+func (a *Authenticator) Authenticate(r *http.Request) (Identity, error) {
+	token, subprotocol := a.extractToken(r)
+	if token == "" {
+		return Identity{}, ErrMissingToken
+	}
+
+	identity, err := a.Verify(token)
+	if err != nil {
+		return Identity{}, err
+	}
+	identity.Subprotocol = subprotocol
+
+	return identity, nil
+}
+
+// Verify checks the token signature and claims and returns the identity it carries
+func (a *Authenticator) Verify(token string) (Identity, error) {
+	claims := &Claims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.keyFunc); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return Identity{UserID: claims.Subject, DisplayName: claims.Name, Metadata: claims.Metadata}, nil
+}
+
+func (a *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return a.hmacSecret, nil
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		return a.rsaKeys.Key(kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+}
+
+// extractToken looks for the token in the Authorization header, then the
+// query string, then the Sec-WebSocket-Protocol header.
+func (a *Authenticator) extractToken(r *http.Request) (string, string) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), ""
+		}
+	}
+
+	if a.queryParam != "" {
+		if token := r.URL.Query().Get(a.queryParam); token != "" {
+			return token, ""
+		}
+	}
+
+	protocols := strings.Split(r.Header.Get("Sec-Websocket-Protocol"), ",")
+	for i := 0; i < len(protocols)-1; i++ {
+		if strings.TrimSpace(protocols[i]) == SubprotocolBearer {
+			return strings.TrimSpace(protocols[i+1]), SubprotocolBearer
+		}
+	}
+
+	return "", ""
+}
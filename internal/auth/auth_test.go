@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func signHMAC(t *testing.T, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	return token
+}
+
+func validClaims(subject string) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestAuthenticateHMACLocations(t *testing.T) {
+	authenticator, err := NewAuthenticator(Options{HMACSecret: testSecret, QueryParam: "token"})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+	token := signHMAC(t, validClaims("user1"))
+
+	// Authorization header
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	identity, err := authenticator.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Expected header token to be accepted, got %v", err)
+	}
+	if identity.UserID != "user1" {
+		t.Errorf("Expected UserID to be 'user1', got '%s'", identity.UserID)
+	}
+
+	// Query parameter
+	r = httptest.NewRequest("GET", "/ws?token="+token, nil)
+	identity, err = authenticator.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Expected query token to be accepted, got %v", err)
+	}
+	if identity.Subprotocol != "" {
+		t.Errorf("Expected no subprotocol for query token, got '%s'", identity.Subprotocol)
+	}
+
+	// Subprotocol
+	r = httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", SubprotocolBearer+", "+token)
+	identity, err = authenticator.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Expected subprotocol token to be accepted, got %v", err)
+	}
+	if identity.Subprotocol != SubprotocolBearer {
+		t.Errorf("Expected subprotocol '%s', got '%s'", SubprotocolBearer, identity.Subprotocol)
+	}
+}
+
+func TestAuthenticateRejectsInvalidTokens(t *testing.T) {
+	authenticator, err := NewAuthenticator(Options{HMACSecret: testSecret, Issuer: "issuer"})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+
+	// Missing token
+	r := httptest.NewRequest("GET", "/ws", nil)
+	if _, err := authenticator.Authenticate(r); !errors.Is(err, ErrMissingToken) {
+		t.Errorf("Expected ErrMissingToken, got %v", err)
+	}
+
+	expired := validClaims("user1")
+	expired.Issuer = "issuer"
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	wrongIssuer := validClaims("user1")
+	wrongIssuer.Issuer = "someone-else"
+
+	noSubject := validClaims("")
+	noSubject.Issuer = "issuer"
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims("user1")).SignedString([]byte("wrong-secret"))
+
+	for name, token := range map[string]string{
+		"expired":      signHMAC(t, expired),
+		"wrong issuer": signHMAC(t, wrongIssuer),
+		"no subject":   signHMAC(t, noSubject),
+		"forged":       forged,
+	} {
+		if _, err := authenticator.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+func TestAuthenticateRSAFromJWKSFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatalf("Error writing JWKS file: %v", err)
+	}
+
+	authenticator, err := NewAuthenticator(Options{JWKSFile: path})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims("user2"))
+	token.Header["kid"] = "key1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+
+	identity, err := authenticator.Verify(signed)
+	if err != nil {
+		t.Fatalf("Expected RSA token to be accepted, got %v", err)
+	}
+	if identity.UserID != "user2" {
+		t.Errorf("Expected UserID to be 'user2', got '%s'", identity.UserID)
+	}
+
+	// HMAC tokens must not be accepted when only RSA keys are configured
+	if _, err := authenticator.Verify(signHMAC(t, validClaims("user2"))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected HMAC token to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyProfileClaims(t *testing.T) {
+	authenticator, err := NewAuthenticator(Options{HMACSecret: testSecret})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: validClaims("user1"),
+		Name:             "Alice",
+		Metadata:         map[string]string{"rank": "gold"},
+	}).SignedString([]byte(testSecret))
+
+	identity, err := authenticator.Verify(token)
+	if err != nil {
+		t.Fatalf("Expected token to be accepted, got %v", err)
+	}
+	if identity.DisplayName != "Alice" {
+		t.Errorf("Expected DisplayName to be 'Alice', got '%s'", identity.DisplayName)
+	}
+	if identity.Metadata["rank"] != "gold" {
+		t.Errorf("Expected rank metadata to be 'gold', got '%s'", identity.Metadata["rank"])
+	}
+}
+
+func TestNewAuthenticatorWithoutKeys(t *testing.T) {
+	if _, err := NewAuthenticator(Options{}); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("Expected ErrNoKeys, got %v", err)
+	}
+}
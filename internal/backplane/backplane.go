@@ -0,0 +1,59 @@
+package backplane
+
+import (
+	"context"
+
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+)
+
+// Kinds of envelope routed between nodes
+const (
+	// KindMessage delivers Message to the connection or player named in Message.To
+	KindMessage = "message"
+	// KindBroadcast delivers Message to every connection
+	KindBroadcast = "broadcast"
+	// KindAck passes a client's ack to the node that sent the acknowledged message
+	KindAck = "ack"
+	// KindMatchmaking passes a matchmaking request to the matchmaking leader
+	KindMatchmaking = "matchmaking"
+	// KindMatchmakingLeave tells the matchmaking leader PlayerID left the queue
+	KindMatchmakingLeave = "matchmaking_leave"
+	// KindSessionCreated announces a match; Node delivers the session notification
+	KindSessionCreated = "session_created"
+)
+
+// Envelope is what nodes send each other
+type Envelope struct {
+	Kind string `json:"kind"`
+	// Origin is the ID of the publishing node
+	Origin  string          `json:"origin"`
+	Message message.Message `json:"message,omitempty"`
+	Ack     *Ack            `json:"ack,omitempty"`
+
+	// Node names the node that should act on the envelope
+	Node        string                            `json:"node,omitempty"`
+	PlayerID    string                            `json:"player_id,omitempty"`
+	Matchmaking *message.MatchmakingRequest       `json:"matchmaking,omitempty"`
+	Session     *notification.SessionNotification `json:"session,omitempty"`
+}
+
+// Ack identifies an acknowledged message and who acknowledged it
+type Ack struct {
+	MessageID    string `json:"message_id"`
+	ConnectionID string `json:"connection_id"`
+	PlayerID     string `json:"player_id"`
+}
+
+// Backplane connects the nodes of a deployment so that messages reach
+// clients connected to any of them
+type Backplane interface {
+	// Publish sends env to every node; the nodes holding its target deliver it
+	Publish(ctx context.Context, env Envelope) error
+	// PublishToNode sends env to a single node
+	PublishToNode(ctx context.Context, nodeID string, env Envelope) error
+	// Subscribe passes envelopes published to every node or to nodeID to
+	// handler, from a single goroutine, until ctx is done
+	Subscribe(ctx context.Context, nodeID string, handler func(Envelope)) error
+	Close() error
+}
@@ -0,0 +1,67 @@
+package backplane
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testBackplane checks that envelopes published to all nodes reach every
+// node and envelopes published to one node reach only that node
+func testBackplane(t *testing.T, bp Backplane) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := map[string]chan Envelope{"node1": make(chan Envelope, 10), "node2": make(chan Envelope, 10)}
+	for nodeID, envelopes := range received {
+		envelopes := envelopes
+		if err := bp.Subscribe(ctx, nodeID, func(env Envelope) { envelopes <- env }); err != nil {
+			t.Fatalf("Error subscribing %s: %v", nodeID, err)
+		}
+	}
+
+	expect := func(nodeID, content string) {
+		t.Helper()
+		select {
+		case env := <-received[nodeID]:
+			if env.Message.Content != content {
+				t.Errorf("%s: expected '%s', got '%s'", nodeID, content, env.Message.Content)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timed out waiting for '%s'", nodeID, content)
+		}
+	}
+
+	if err := bp.Publish(ctx, Envelope{Kind: KindMessage, Origin: "node1", Message: message.Message{To: "p1", Content: "everyone"}}); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	expect("node1", "everyone")
+	expect("node2", "everyone")
+
+	if err := bp.PublishToNode(ctx, "node2", Envelope{Kind: KindMessage, Origin: "node1", Message: message.Message{To: "p2", Content: "node2 only"}}); err != nil {
+		t.Fatalf("Error publishing to node: %v", err)
+	}
+	expect("node2", "node2 only")
+	select {
+	case env := <-received["node1"]:
+		t.Errorf("Expected node1 to receive nothing, got %+v", env)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBackplane(t, NewMemory())
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	bp := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test")
+	defer bp.Close()
+
+	testBackplane(t, bp)
+}
@@ -0,0 +1,90 @@
+package backplane
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is an in-process backplane. Nodes sharing a Memory reach each
+// other, which makes it useful for tests and single-process deployments.
+type Memory struct {
+	mutex       sync.RWMutex
+	subscribers map[string][]chan Envelope // node ID -> subscriptions
+}
+
+// NewMemory creates an in-process backplane
+func NewMemory() *Memory {
+	return &Memory{subscribers: make(map[string][]chan Envelope)}
+}
+
+func (m *Memory) Publish(ctx context.Context, env Envelope) error {
+	m.mutex.RLock()
+	var subscriptions []chan Envelope
+	for _, node := range m.subscribers {
+		subscriptions = append(subscriptions, node...)
+	}
+	m.mutex.RUnlock()
+
+	deliver(ctx, subscriptions, env)
+	return nil
+}
+
+func (m *Memory) PublishToNode(ctx context.Context, nodeID string, env Envelope) error {
+	m.mutex.RLock()
+	subscriptions := append([]chan Envelope(nil), m.subscribers[nodeID]...)
+	m.mutex.RUnlock()
+
+	deliver(ctx, subscriptions, env)
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, nodeID string, handler func(Envelope)) error {
+	envelopes := make(chan Envelope, 256)
+
+	m.mutex.Lock()
+	m.subscribers[nodeID] = append(m.subscribers[nodeID], envelopes)
+	m.mutex.Unlock()
+
+	go func() {
+		for {
+			select {
+			case env := <-envelopes:
+				handler(env)
+			case <-ctx.Done():
+				m.unsubscribe(nodeID, envelopes)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) unsubscribe(nodeID string, envelopes chan Envelope) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	subscriptions := m.subscribers[nodeID]
+	for i, subscription := range subscriptions {
+		if subscription == envelopes {
+			m.subscribers[nodeID] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+			break
+		}
+	}
+	if len(m.subscribers[nodeID]) == 0 {
+		delete(m.subscribers, nodeID)
+	}
+}
+
+// deliver queues env for each subscription, giving up when ctx is done
+func deliver(ctx context.Context, subscriptions []chan Envelope, env Envelope) {
+	for _, subscription := range subscriptions {
+		select {
+		case subscription <- env:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
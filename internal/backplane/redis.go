@@ -0,0 +1,90 @@
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"simple-multiplayer-service/internal/logging"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a backplane built on Redis pub/sub. It works with any server
+// speaking the Redis protocol.
+type Redis struct {
+	client *redis.Client
+	prefix string
+	Logger *slog.Logger
+}
+
+// NewRedis creates a backplane publishing on channels named after prefix
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix, Logger: slog.Default()}
+}
+
+func (r *Redis) allChannel() string {
+	return r.prefix + ":all"
+}
+
+func (r *Redis) nodeChannel(nodeID string) string {
+	return r.prefix + ":node:" + nodeID
+}
+
+func (r *Redis) Publish(ctx context.Context, env Envelope) error {
+	return r.publish(ctx, r.allChannel(), env)
+}
+
+func (r *Redis) PublishToNode(ctx context.Context, nodeID string, env Envelope) error {
+	return r.publish(ctx, r.nodeChannel(nodeID), env)
+}
+
+func (r *Redis) publish(ctx context.Context, channel string, env Envelope) error {
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, channel, payload).Err()
+}
+
+func (r *Redis) Subscribe(ctx context.Context, nodeID string, handler func(Envelope)) error {
+	pubsub := r.client.Subscribe(ctx, r.allChannel(), r.nodeChannel(nodeID))
+	// Wait for the subscription to be confirmed so nothing published after
+	// Subscribe returns is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("subscribing to backplane: %w", err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var env Envelope
+				if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+					logging.OrDefault(r.Logger).Warn("dropping malformed backplane envelope", slog.String("channel", msg.Channel), logging.Err(err))
+					continue
+				}
+				handler(env)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// Ping checks the Redis server is reachable
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
@@ -2,11 +2,20 @@ package client
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/codec"
+	"simple-multiplayer-service/internal/logging"
 
 	"simple-multiplayer-service/internal/matchmaking"
 	"simple-multiplayer-service/internal/message"
-	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/metrics"
+	"simple-multiplayer-service/internal/player"
+	"simple-multiplayer-service/internal/ratelimit"
+	"simple-multiplayer-service/internal/transport"
 
 	"github.com/gorilla/websocket"
 )
@@ -14,120 +23,316 @@ import (
 // Client represents a single WebSocket connection
 type Client struct {
 	ID                  string
+	Player              *player.Player
 	IPAddress           string
-	Connection          *websocket.Conn
+	ConnectedAt         time.Time
+	Connection          transport.Conn
 	MatchmakingService  *matchmaking.Service
-	NotificationService *notification.Service
 	SendMessageFunc     func(message message.Message) error
 	UnregisterFunc      func(clientID string)
-	Done                chan struct{}
+	RoomRequestFunc     func(c *Client, request message.RoomRequest)
+	SpectateRequestFunc func(c *Client, request message.SpectateRequest)
+	ResultReportFunc    func(c *Client, report message.ResultReport)
+	MatchHistoryFunc    func(c *Client, request message.MatchHistoryRequest)
+	LobbyRequestFunc    func(c *Client, request message.LobbyRequest)
+	AckMessageFunc      func(c *Client, ack message.AckMessage)
+	RateLimiter         *ratelimit.ConnectionLimiter
+	MaxFrameSize        int64 // bytes per frame, zero for no limit
+	MaxContentLength    int   // bytes of Message.Content, zero for no limit
+	Metrics             *metrics.Metrics
+	// DeliverMessageFunc routes direct messages from this client, keeping them
+	// for offline recipients, and returns the receipt status; SendMessageFunc
+	// is used when it is nil
+	DeliverMessageFunc func(message message.Message) (string, error)
+	// AdmitMatchmakingFunc decides whether the client may queue for a
+	// match, answering it when it may not; every request is queued when it
+	// is nil
+	AdmitMatchmakingFunc func(c *Client) bool
+	// Logger carries the client's client_id, player_id and ip fields
+	Logger *slog.Logger
+	// LogContent includes message content in logs, off by default for privacy
+	LogContent bool
+	// Codec encodes the frames of the connection, JSON when nil
+	Codec codec.Codec
+	// CompressionThreshold is the smallest frame in bytes written compressed
+	// when the connection negotiated compression
+	CompressionThreshold int
+	Done                 chan struct{}
+
+	// writeMutex serialises writes, gorilla connections allow a single concurrent writer
+	writeMutex sync.Mutex
+}
+
+// PlayerID returns the identity used for matchmaking and routing: the
+// player behind the connection, or the connection ID for anonymous clients
+func (c *Client) PlayerID() string {
+	if c.Player != nil && c.Player.ID != "" {
+		return c.Player.ID
+	}
+	return c.ID
+}
+
+// WriteMessage encodes msg with the client's codec and writes it to the
+// connection. It is safe to call from several goroutines, e.g. a direct
+// message racing a broadcast.
+func (c *Client) WriteMessage(msg message.Message) error {
+	data, err := c.codec().Encode(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	// Does nothing unless the connection negotiated compression
+	transport.EnableWriteCompression(c.Connection, len(data) >= c.CompressionThreshold)
+	return c.Connection.WriteMessage(c.codec().FrameType(), data)
+}
+
+func (c *Client) codec() codec.Codec {
+	if c.Codec == nil {
+		return codec.JSON
+	}
+	return c.Codec
+}
+
+func (c *Client) logger() *slog.Logger {
+	return logging.OrDefault(c.Logger)
 }
 
 // HandleMessage processes incoming messages from clients
 func (c *Client) HandleMessage(message message.Message) {
+	started := time.Now()
+
 	// Set the sender ID
-	message.From = c.ID
+	message.From = c.PlayerID()
 
 	// Log the message
-	log.Printf("Message from %s to %s: %s", message.From, message.To, message.Content)
+	attrs := []any{slog.String(logging.KeyRecipient, message.To), slog.String(logging.KeyMessageType, "direct")}
+	if c.LogContent {
+		attrs = append(attrs, slog.String(logging.KeyContent, message.Content))
+	}
+	c.logger().Debug("message received", attrs...)
 
 	// Send the message to the target client
-	err := c.SendMessageFunc(message)
+	status, err := c.deliver(message)
+	if message.ID != "" {
+		c.sendReceipt(message.ID, status, err)
+	}
 	if err != nil {
-		log.Printf("Error sending message: %v", err)
+		c.logger().Warn("error sending message", append(attrs, logging.Err(err))...)
+		return
+	}
+	c.Metrics.MessageDelivered(started)
+}
+
+// deliver routes a direct message and returns its receipt status
+func (c *Client) deliver(msg message.Message) (string, error) {
+	if c.DeliverMessageFunc != nil {
+		return c.DeliverMessageFunc(msg)
+	}
+	if err := c.SendMessageFunc(msg); err != nil {
+		return message.ReceiptFailed, err
+	}
+	return message.ReceiptDelivered, nil
+}
+
+// sendReceipt tells the client what happened to the message it sent with an ID
+func (c *Client) sendReceipt(messageID, status string, deliveryErr error) {
+	receipt := message.Receipt{Type: message.ReceiptType, ID: messageID, Status: status}
+	if deliveryErr != nil {
+		receipt.Reason = deliveryErr.Error()
+	}
+	if err := c.SendMessageFunc(message.NewServerMessage(c.ID, receipt)); err != nil {
+		c.logger().Warn("error sending receipt", slog.String("message_id", messageID), logging.Err(err))
 	}
 }
 
 // HandleMatchmakingRequest processes incoming matchmaking requests from clients
 func (c *Client) HandleMatchmakingRequest(mmr message.MatchmakingRequest) {
+	// never trust the identity supplied by the client
+	mmr.PlayerID = c.PlayerID()
+	mmr.ConnectionID = c.ID
+
+	if c.AdmitMatchmakingFunc != nil && !c.AdmitMatchmakingFunc(c) {
+		return
+	}
+
 	// put matchmaking request into the queue
 	c.MatchmakingService.SessionQueue <- mmr
 }
 
+// HandleRoomRequest passes a join, leave, list or post request to the room handler
+func (c *Client) HandleRoomRequest(request message.RoomRequest) {
+	if c.RoomRequestFunc == nil {
+		return
+	}
+	c.logger().Debug("room request received", slog.String(logging.KeyMessageType, request.Type), slog.String(logging.KeyRoom, request.Room))
+	c.RoomRequestFunc(c, request)
+}
+
+// HandleLobbyRequest passes a request to create, join, run or chat in a lobby to the lobby handler
+func (c *Client) HandleLobbyRequest(request message.LobbyRequest) {
+	if c.LobbyRequestFunc == nil {
+		return
+	}
+	c.logger().Debug("lobby request received", slog.String(logging.KeyMessageType, request.Type))
+	c.LobbyRequestFunc(c, request)
+}
+
+// HandleSpectateRequest passes a request to spectate a session to the spectator handler
+func (c *Client) HandleSpectateRequest(request message.SpectateRequest) {
+	if c.SpectateRequestFunc == nil {
+		return
+	}
+	c.logger().Debug("spectate request received", slog.String(logging.KeyMessageType, request.Type), slog.String(logging.KeySessionID, request.SessionID))
+	c.SpectateRequestFunc(c, request)
+}
+
+// HandleResultReport passes the result a player reports for a session to the result handler
+func (c *Client) HandleResultReport(report message.ResultReport) {
+	if c.ResultReportFunc == nil {
+		return
+	}
+	c.logger().Debug("result report received", slog.String(logging.KeySessionID, report.SessionID))
+	c.ResultReportFunc(c, report)
+}
+
+// HandleMatchHistory passes a request for the player's match history to the result handler
+func (c *Client) HandleMatchHistory(request message.MatchHistoryRequest) {
+	if c.MatchHistoryFunc == nil {
+		return
+	}
+	c.MatchHistoryFunc(c, request)
+}
+
 // ReadMessages continuously reads messages from the client
 func (c *Client) ReadMessages() {
 	defer func() {
 		c.Connection.Close()
 		c.UnregisterFunc(c.ID)
+		c.RateLimiter.Release()
 		close(c.Done)
 	}()
 
+	// Frames over the limit make the connection close with 1009
+	if c.MaxFrameSize > 0 {
+		transport.SetReadLimit(c.Connection, c.MaxFrameSize)
+	}
+
 	for {
-		var msg message.Message
-		err := c.Connection.ReadJSON(&msg)
+		_, data, err := c.Connection.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Error reading message: %v", err)
+				c.logger().Warn("error reading message", logging.Err(err))
 			}
 			break
 		}
 
-		// Try to unmarshal for matchmaking request
-		var content map[string]interface{}
-		if err := json.Unmarshal([]byte(msg.Content), &content); err == nil {
-			if contentType, ok := content["type"].(string); ok && contentType == message.MatchmakingRequestType {
-				var mmr message.MatchmakingRequest
-				if err := json.Unmarshal([]byte(msg.Content), &mmr); err == nil {
-					c.HandleMatchmakingRequest(mmr)
+		// Reject malformed or oversized messages before dispatching them
+		msg, err := c.codec().Decode(data)
+		if err == nil {
+			err = message.Validate(msg, c.MaxContentLength)
+		}
+		if err != nil {
+			c.Metrics.MessageReceived("invalid")
+			c.logger().Warn("closing client after invalid message", logging.Err(err))
+			code := websocket.CloseInvalidFramePayloadData
+			if errors.Is(err, message.ErrContentTooLong) {
+				code = websocket.CloseMessageTooBig
+			}
+			c.closeWithCode(code, err.Error())
+			return
+		}
+
+		if message.ContentType(msg.Content) == message.MatchmakingRequestType {
+			c.Metrics.MessageReceived(message.MatchmakingRequestType)
+			var mmr message.MatchmakingRequest
+			if err := json.Unmarshal([]byte(msg.Content), &mmr); err == nil {
+				if !c.RateLimiter.AllowMatchmaking() {
+					if c.rejectRateLimited("too many matchmaking requests") {
+						return
+					}
 					continue
 				}
+				c.HandleMatchmakingRequest(mmr)
+				continue
 			}
 		}
 
-		c.HandleMessage(msg)
+		label, handle := message.ContentType(msg.Content), c.typedHandler(msg.Content)
+		if handle == nil {
+			label, handle = "direct", func() { c.HandleMessage(msg) }
+		}
+		if c.dispatch(label, handle) {
+			return
+		}
 	}
 }
 
-func (c *Client) HandleSessionCreatedNotification(session notification.SessionNotification, targetUserID1, targetUserID2 string) error {
-	sessionByte, err := json.Marshal(session)
-	if err != nil {
-		log.Printf("Error marshalling session: %v", err)
-		return err
+// typedHandler returns the handler of a typed request, or nil for content
+// that isn't one and is sent on as a direct message
+func (c *Client) typedHandler(content string) func() {
+	contentType := message.ContentType(content)
+	switch {
+	case contentType == message.AckMessageType:
+		return decoded(content, func(ack message.AckMessage) {
+			if c.AckMessageFunc != nil {
+				c.AckMessageFunc(c, ack)
+			}
+		})
+	case message.IsRoomRequest(contentType):
+		return decoded(content, c.HandleRoomRequest)
+	case message.IsSpectateRequest(contentType):
+		return decoded(content, c.HandleSpectateRequest)
+	case message.IsLobbyRequest(contentType):
+		return decoded(content, c.HandleLobbyRequest)
+	case contentType == message.ReportResultType:
+		return decoded(content, c.HandleResultReport)
+	case contentType == message.MatchHistoryType:
+		return decoded(content, c.HandleMatchHistory)
 	}
-	notificationMessage1 := message.Message{
-		From:    "Server",
-		To:      targetUserID1,
-		Content: string(sessionByte),
+	return nil
+}
+
+// dispatch counts a message under label and rate limits it before handling
+// it. It reports whether the client was disconnected for flooding.
+func (c *Client) dispatch(label string, handle func()) bool {
+	c.Metrics.MessageReceived(label)
+	if !c.RateLimiter.AllowMessage() {
+		return c.rejectRateLimited("too many messages")
 	}
+	handle()
+	return false
+}
 
-	log.Printf("Sending message to %s", notificationMessage1.To)
-	err = c.SendMessageFunc(notificationMessage1)
-	if err != nil {
-		log.Printf("Error sending notificationMessage: %v", err)
-		return err
+// decoded returns a handler passing content, decoded as a T, to handle.
+// Content that doesn't decode is dropped; it was validated on receipt.
+func decoded[T any](content string, handle func(T)) func() {
+	return func() {
+		var request T
+		if err := json.Unmarshal([]byte(content), &request); err == nil {
+			handle(request)
+		}
 	}
+}
 
-	notificationMessage2 := message.Message{
-		From:    "Server",
-		To:      targetUserID2,
-		Content: string(sessionByte),
+// rejectRateLimited answers a rate limited request with an error frame and
+// reports whether the client has offended often enough to be disconnected
+func (c *Client) rejectRateLimited(reason string) bool {
+	c.logger().Warn("client rate limited", slog.String("reason", reason))
+
+	if c.RateLimiter.Violation() {
+		c.closeWithCode(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return true
 	}
 
-	log.Printf("Sending message to %s", notificationMessage2.To)
-	err = c.SendMessageFunc(notificationMessage2)
+	err := c.SendMessageFunc(message.NewErrorMessage(c.ID, message.ErrorCodeRateLimited, reason))
 	if err != nil {
-		log.Printf("Error sending notificationMessage: %v", err)
-		return err
+		c.logger().Warn("error sending error message", logging.Err(err))
 	}
-
-	return nil
+	return false
 }
 
-// CheckNotifications check notifications from the service's notification channel
-func (c *Client) CheckNotifications() {
-	for {
-		select {
-		case notif := <-c.NotificationService.Channel:
-			if notif.Player1ConnectionID == c.ID || notif.Player2ConnectionID == c.ID {
-				log.Println("handling session created notification")
-				err := c.HandleSessionCreatedNotification(notif, notif.Player1ConnectionID, notif.Player2ConnectionID)
-				if err != nil {
-					log.Printf("Error handling session created notification: %v", err)
-					continue
-				}
-			}
-		case <-c.Done:
-			return
-		}
-	}
+// closeWithCode sends a close frame; the read loop closes the connection on return
+func (c *Client) closeWithCode(code int, reason string) {
+	_ = transport.WriteClose(c.Connection, code, reason)
 }
@@ -1,12 +1,19 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"simple-multiplayer-service/internal/logging"
 	"simple-multiplayer-service/internal/matchmaking"
 	"simple-multiplayer-service/internal/message"
-	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/transport"
+
+	"github.com/gorilla/websocket"
 )
 
 func TestHandleMessage(t *testing.T) {
@@ -47,6 +54,34 @@ func TestHandleMessage(t *testing.T) {
 	}
 }
 
+func TestHandleMessageContentLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := logging.New(&buf, "debug", "json")
+
+	client := &Client{
+		ID:              "client1",
+		Logger:          logger,
+		SendMessageFunc: func(msg message.Message) error { return nil },
+	}
+
+	// Content is not logged by default
+	client.HandleMessage(message.Message{To: "client2", Content: "secret"})
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("Expected content to be omitted from logs, got %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"recipient":"client2"`) {
+		t.Errorf("Expected recipient field in logs, got %s", buf.String())
+	}
+
+	// Content is logged when enabled
+	buf.Reset()
+	client.LogContent = true
+	client.HandleMessage(message.Message{To: "client2", Content: "visible"})
+	if !strings.Contains(buf.String(), `"content":"visible"`) {
+		t.Errorf("Expected content field in logs, got %s", buf.String())
+	}
+}
+
 func TestHandleMatchmakingRequest(t *testing.T) {
 	// Setup
 	sessionQueue := make(chan message.MatchmakingRequest, 1)
@@ -82,67 +117,91 @@ func TestHandleMatchmakingRequest(t *testing.T) {
 	}
 }
 
-func TestHandleSessionCreatedNotification(t *testing.T) {
-	// Setup
-	sentMessages := make([]message.Message, 0)
+// Note: ReadMessages is not tested here because it relies heavily on the websocket.Conn interface,
+// which is difficult to mock effectively. In a real-world scenario, you might use a library like
+// github.com/stretchr/testify/mock to create a proper mock for websocket.Conn.
+
+func TestHandleMessageReceipts(t *testing.T) {
+	var sent []message.Message
 	client := &Client{
-		ID: "server",
+		ID: "client1",
 		SendMessageFunc: func(msg message.Message) error {
-			sentMessages = append(sentMessages, msg)
+			sent = append(sent, msg)
 			return nil
 		},
+		DeliverMessageFunc: func(msg message.Message) (string, error) {
+			if msg.To == "offline" {
+				return message.ReceiptFailed, errors.New("client not found: offline")
+			}
+			return message.ReceiptDelivered, nil
+		},
 	}
 
-	// Test notification
-	session := notification.SessionNotification{
-		SessionID:           "session1",
-		Player1ConnectionID: "client1",
-		Player2ConnectionID: "client2",
+	// Messages without an ID get no receipt
+	client.HandleMessage(message.Message{To: "client2", Content: "Hello"})
+	if len(sent) != 0 {
+		t.Fatalf("Expected no receipt, got %v", sent)
 	}
 
-	// Execute
-	err := client.HandleSessionCreatedNotification(session, "client1", "client2")
-
-	// Verify
-	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
-	}
-	if len(sentMessages) != 2 {
-		t.Errorf("Expected 2 messages to be sent, got %d", len(sentMessages))
+	for _, test := range []struct {
+		to     string
+		status string
+	}{
+		{"client2", message.ReceiptDelivered},
+		{"offline", message.ReceiptFailed},
+	} {
+		sent = nil
+		client.HandleMessage(message.Message{ID: "m1", To: test.to, Content: "Hello"})
+		if len(sent) != 1 || sent[0].To != "client1" {
+			t.Fatalf("Expected one receipt to the sender, got %v", sent)
+		}
+		var receipt message.Receipt
+		json.Unmarshal([]byte(sent[0].Content), &receipt)
+		if receipt.Type != message.ReceiptType || receipt.ID != "m1" || receipt.Status != test.status {
+			t.Errorf("Expected %s receipt for m1, got %+v", test.status, receipt)
+		}
 	}
+}
 
-	// Check first message
-	if sentMessages[0].From != "Server" {
-		t.Errorf("Expected From to be 'Server', got '%s'", sentMessages[0].From)
-	}
-	if sentMessages[0].To != "client1" {
-		t.Errorf("Expected To to be 'client1', got '%s'", sentMessages[0].To)
+func TestReadMessagesOverPipe(t *testing.T) {
+	conn, peer := transport.Pipe()
+	sent := make(chan message.Message, 1)
+	unregistered := make(chan string, 1)
+	client := &Client{
+		ID:               "client1",
+		Connection:       conn,
+		MaxFrameSize:     256,
+		MaxContentLength: 16,
+		SendMessageFunc: func(msg message.Message) error {
+			sent <- msg
+			return nil
+		},
+		UnregisterFunc: func(clientID string) { unregistered <- clientID },
+		Done:           make(chan struct{}),
 	}
+	go client.ReadMessages()
 
-	// Check second message
-	if sentMessages[1].From != "Server" {
-		t.Errorf("Expected From to be 'Server', got '%s'", sentMessages[1].From)
-	}
-	if sentMessages[1].To != "client2" {
-		t.Errorf("Expected To to be 'client2', got '%s'", sentMessages[1].To)
+	peer.WriteMessage(transport.TextMessage, []byte(`{"to":"client2","content":"Hello"}`))
+	select {
+	case msg := <-sent:
+		if msg.From != "client1" || msg.To != "client2" || msg.Content != "Hello" {
+			t.Errorf("Unexpected message %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the message to be sent on")
 	}
 
-	// Verify content contains session data
-	var sessionData1 notification.SessionNotification
-	err = json.Unmarshal([]byte(sentMessages[0].Content), &sessionData1)
-	if err != nil {
-		t.Errorf("Failed to unmarshal message content: %v", err)
+	// Content over the limit closes the connection with 1009
+	peer.WriteMessage(transport.TextMessage, []byte(`{"to":"client2","content":"far too long for the limit"}`))
+	if _, _, err := peer.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected close code 1009, got %v", err)
+	}
+	select {
+	case <-client.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the read loop to end")
 	}
-	if sessionData1.SessionID != "session1" {
-		t.Errorf("Expected SessionID to be 'session1', got '%s'", sessionData1.SessionID)
+	if clientID := <-unregistered; clientID != "client1" {
+		t.Errorf("Expected client1 to be unregistered, got %s", clientID)
 	}
 }
-
-// Note: ReadMessages is not tested here because it relies heavily on the websocket.Conn interface,
-// which is difficult to mock effectively. In a real-world scenario, you might use a library like
-// github.com/stretchr/testify/mock to create a proper mock for websocket.Conn.
-
-// Note: CheckNotifications is not tested here because it contains an infinite loop that
-// continuously reads from a channel, making it difficult to test in a unit test context.
-// In a real-world scenario, you might refactor the function to accept a context for cancellation
-// or use a testing framework that supports testing goroutines with infinite loops.
@@ -0,0 +1,63 @@
+// Package codec encodes the messages exchanged with clients. Clients pick a
+// codec by offering its name as a websocket subprotocol; JSON is used when
+// they offer none.
+package codec
+
+import (
+	"strings"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+// Names of the supported codecs, which are also their websocket subprotocols
+const (
+	NameJSON     = "json"
+	NameMsgpack  = "msgpack"
+	NameProtobuf = "protobuf"
+)
+
+// Codec converts messages to and from websocket frames
+type Codec interface {
+	// Name is the subprotocol selecting the codec
+	Name() string
+	// FrameType is the websocket message type to write, TextMessage or BinaryMessage
+	FrameType() int
+	Encode(msg message.Message) ([]byte, error)
+	// Decode parses a client frame, rejecting anything but a well-formed
+	// message with an error wrapping message.ErrInvalidPayload
+	Decode(data []byte) (message.Message, error)
+}
+
+// JSON is the default codec
+var JSON Codec = jsonCodec{}
+
+// Msgpack encodes messages as MessagePack maps keyed like the JSON fields
+var Msgpack Codec = msgpackCodec{}
+
+// Protobuf encodes messages as the protobuf message described in message.proto
+var Protobuf Codec = protobufCodec{}
+
+var codecs = []Codec{JSON, Msgpack, Protobuf}
+
+// Names returns the supported codec names
+func Names() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// Negotiate returns the first codec named among the subprotocols a client
+// offers, in its order of preference, and whether there was one. JSON is
+// returned when there wasn't.
+func Negotiate(subprotocols []string) (Codec, bool) {
+	for _, subprotocol := range subprotocols {
+		for _, c := range codecs {
+			if c.Name() == strings.TrimSpace(subprotocol) {
+				return c, true
+			}
+		}
+	}
+	return JSON, false
+}
@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// typedContent lists a value of every content type exchanged with clients
+func typedContent() map[string]interface{} {
+	sentAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	member := message.RoomMember{ConnectionID: "conn1", PlayerID: "alice", DisplayName: "Alice"}
+	roomMessage := message.RoomMessage{Type: message.RoomMessageType, Room: "lobby", From: member, Text: "hi", SentAt: sentAt}
+	outcome := message.Outcome{WinnerID: "alice", Scores: map[string]int{"alice": 3, "bob": 1}, Resolution: message.ResolutionAgreed}
+	return map[string]interface{}{
+		"matchmakingRequest":   &message.MatchmakingRequest{Type: message.MatchmakingRequestType, PlayerID: "alice", ConnectionID: "conn1"},
+		"ackMessage":           &message.AckMessage{Type: message.AckMessageType, ID: "msg1"},
+		"roomRequest":          &message.RoomRequest{Type: message.RoomMessageType, Room: "lobby", Text: "hi"},
+		"error":                &message.Error{Type: message.ErrorType, Code: message.ErrorCodeRateLimited, Message: "slow down"},
+		"announcement":         &message.Announcement{Type: message.AnnouncementType, Message: "maintenance"},
+		"sessionEnded":         &message.SessionEnded{Type: message.SessionEndedType, SessionID: "session1", Reason: "ended"},
+		"sessionStatus":        &message.SessionStatus{Type: message.SessionStatusType, SessionID: "session1", Status: "paused", Previous: "active", At: sentAt},
+		"playerDisconnected":   &message.PlayerDisconnected{Type: message.PlayerDisconnectedType, SessionID: "session1", PlayerID: "alice", ReconnectBy: sentAt},
+		"playerReconnected":    &message.PlayerReconnected{Type: message.PlayerReconnectedType, SessionID: "session1", PlayerID: "alice"},
+		"lobbyUpdated":         &message.LobbyUpdated{Type: message.LobbyUpdatedType, Lobby: message.Lobby{Code: "ABC234", HostID: "alice", MaxPlayers: 4, Locked: true, Members: []message.LobbyMember{{PlayerID: "alice", DisplayName: "Alice"}, {PlayerID: "bob"}}}},
+		"lobbyMessage":         &message.LobbyMessage{Type: message.LobbyMessageType, Code: "ABC234", From: message.LobbyMember{PlayerID: "bob"}, Text: "ready?", SentAt: sentAt},
+		"lobbyStarted":         &message.LobbyStarted{Type: message.LobbyStartedType, Code: "ABC234", SessionID: "session1"},
+		"matchmakingCancelled": &message.MatchmakingCancelled{Type: message.MatchmakingCancelledType, Reason: "drained"},
+		"offlineMessage":       &message.OfflineMessage{Type: message.OfflineMessageType, ID: "msg1", From: "bob", Content: "later", SentAt: sentAt},
+		"receipt":              &message.Receipt{Type: message.ReceiptType, ID: "msg1", Status: message.ReceiptFailed, Reason: "offline"},
+		"roomMessage":          &roomMessage,
+		"roomJoined":           &message.RoomJoined{Type: message.RoomJoinedType, Room: "lobby", Members: []message.RoomMember{member}, History: []message.RoomMessage{roomMessage}},
+		"roomMembers":          &message.RoomMembers{Type: message.RoomMembersType, Room: "lobby", Members: []message.RoomMember{member}},
+		"roomMemberEvent":      &message.RoomMemberEvent{Type: message.RoomMemberJoinedType, Room: "lobby", Member: member},
+		"sessionNotification":  &notification.SessionNotification{SessionID: "session1", Player1ID: "alice", Player2ID: "bob"},
+		"spectateRequest":      &message.SpectateRequest{Type: message.SpectateType, SessionID: "session1"},
+		"spectating":           &message.Spectating{Type: message.SpectatingType, SessionID: "session1", Player1ID: "alice", Player2ID: "bob", DelaySeconds: 5, Spectators: []message.Spectator{{ConnectionID: "conn2", PlayerID: "carol"}}},
+		"spectatedMessage":     &message.SpectatedMessage{Type: message.SpectatedMessageType, SessionID: "session1", From: "alice", To: "bob", Content: "e2e4", SentAt: sentAt},
+		"resultReport":         &message.ResultReport{Type: message.ReportResultType, SessionID: "session1", WinnerID: "alice", Scores: map[string]int{"alice": 3, "bob": 1}},
+		"sessionFinished":      &message.SessionFinished{Type: message.SessionFinishedType, SessionID: "session1", EndedAt: sentAt, Outcome: outcome},
+		"matchHistory":         &message.MatchHistory{Type: message.MatchHistoryType, Matches: []message.MatchRecord{{SessionID: "session1", Player1ID: "alice", Player2ID: "bob", Status: "finished", EndedAt: sentAt, Outcome: outcome}}},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []Codec{JSON, Msgpack, Protobuf} {
+		t.Run(c.Name(), func(t *testing.T) {
+			messages := map[string]message.Message{
+				"direct":          {From: "alice", To: "bob", Content: "hello"},
+				"direct with id":  {ID: "msg1", From: "alice", To: "bob", Content: "hello"},
+				"empty":           {},
+				"unicode content": {From: "alice", To: "bob", Content: "héllo 👋\n\"quoted\""},
+			}
+			for name, content := range typedContent() {
+				messages[name] = message.NewServerMessage("conn1", content)
+			}
+
+			for name, msg := range messages {
+				data, err := c.Encode(msg)
+				if err != nil {
+					t.Fatalf("%s: error encoding: %v", name, err)
+				}
+				decoded, err := c.Decode(data)
+				if err != nil {
+					t.Fatalf("%s: error decoding: %v", name, err)
+				}
+				if decoded != msg {
+					t.Errorf("%s: expected %+v, got %+v", name, msg, decoded)
+				}
+			}
+
+			// Typed content comes back as the value that was sent
+			for name, content := range typedContent() {
+				data, _ := c.Encode(message.NewServerMessage("conn1", content))
+				decoded, _ := c.Decode(data)
+				value := reflect.New(reflect.TypeOf(content).Elem()).Interface()
+				if err := json.Unmarshal([]byte(decoded.Content), value); err != nil {
+					t.Fatalf("%s: error decoding content: %v", name, err)
+				}
+				if !reflect.DeepEqual(value, content) {
+					t.Errorf("%s: expected %+v, got %+v", name, content, value)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameTypes(t *testing.T) {
+	if JSON.FrameType() != websocket.TextMessage {
+		t.Error("Expected JSON in text frames")
+	}
+	if Msgpack.FrameType() != websocket.BinaryMessage || Protobuf.FrameType() != websocket.BinaryMessage {
+		t.Error("Expected binary codecs in binary frames")
+	}
+}
+
+func TestDecodeRejectsMalformedFrames(t *testing.T) {
+	unknownField, _ := msgpack.Marshal(map[string]string{"to": "bob", "extra": "x"})
+	cases := map[string]struct {
+		codec Codec
+		data  []byte
+	}{
+		"json garbage":             {JSON, []byte("not json")},
+		"msgpack garbage":          {Msgpack, []byte{0xc1}},
+		"msgpack unknown field":    {Msgpack, unknownField},
+		"msgpack trailing data":    {Msgpack, append(mustEncode(t, Msgpack), 0x01)},
+		"protobuf truncated":       {Protobuf, mustEncode(t, Protobuf)[:5]},
+		"protobuf wrong wire type": {Protobuf, []byte{0x10, 0x01}}, // field 2 as a varint
+		// field 9 as a varint, then to = "bob"
+		"protobuf unknown field": {Protobuf, append([]byte{0x48, 0x07}, mustEncode(t, Protobuf)...)},
+	}
+	for name, tc := range cases {
+		if _, err := tc.codec.Decode(tc.data); !errors.Is(err, message.ErrInvalidPayload) {
+			t.Errorf("%s: expected ErrInvalidPayload, got %v", name, err)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		offered  []string
+		expected Codec
+		ok       bool
+	}{
+		{[]string{"msgpack"}, Msgpack, true},
+		{[]string{"protobuf", "json"}, Protobuf, true},
+		{[]string{"bearer", "token", "json"}, JSON, true},
+		{[]string{"bearer", "token"}, JSON, false},
+		{nil, JSON, false},
+	}
+	for _, tc := range cases {
+		if c, ok := Negotiate(tc.offered); c != tc.expected || ok != tc.ok {
+			t.Errorf("%v: expected %s, %v, got %s, %v", tc.offered, tc.expected.Name(), tc.ok, c.Name(), ok)
+		}
+	}
+	if names := Names(); !reflect.DeepEqual(names, []string{"json", "msgpack", "protobuf"}) {
+		t.Errorf("Unexpected codec names %v", names)
+	}
+}
+
+func mustEncode(t *testing.T, c Codec) []byte {
+	t.Helper()
+	data, err := c.Encode(message.Message{From: "alice", To: "bob", Content: "hello"})
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	return data
+}
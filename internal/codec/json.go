@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"encoding/json"
+
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/gorilla/websocket"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return NameJSON }
+
+func (jsonCodec) FrameType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(msg message.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Decode(data []byte) (message.Message, error) {
+	return message.Decode(data)
+}
@@ -0,0 +1,42 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return NameMsgpack }
+
+func (msgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(msg message.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Decode(data []byte) (message.Message, error) {
+	var msg message.Message
+	reader := bytes.NewReader(data)
+	decoder := msgpack.NewDecoder(reader)
+	decoder.SetCustomStructTag("json")
+	decoder.DisallowUnknownFields(true)
+	if err := decoder.Decode(&msg); err != nil {
+		return message.Message{}, fmt.Errorf("%w: %v", message.ErrInvalidPayload, err)
+	}
+	if reader.Len() > 0 {
+		return message.Message{}, fmt.Errorf("%w: trailing data after message", message.ErrInvalidPayload)
+	}
+	return msg, nil
+}
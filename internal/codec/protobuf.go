@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"fmt"
+
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of message.proto
+const (
+	fieldID      protowire.Number = 1
+	fieldFrom    protowire.Number = 2
+	fieldTo      protowire.Number = 3
+	fieldContent protowire.Number = 4
+)
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return NameProtobuf }
+
+func (protobufCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (protobufCodec) Encode(msg message.Message) ([]byte, error) {
+	var data []byte
+	for _, field := range []struct {
+		number protowire.Number
+		value  string
+	}{
+		{fieldID, msg.ID},
+		{fieldFrom, msg.From},
+		{fieldTo, msg.To},
+		{fieldContent, msg.Content},
+	} {
+		// proto3 leaves empty strings out
+		if field.value == "" {
+			continue
+		}
+		data = protowire.AppendTag(data, field.number, protowire.BytesType)
+		data = protowire.AppendString(data, field.value)
+	}
+	return data, nil
+}
+
+// Decode rejects unknown fields, like the JSON and MessagePack codecs
+func (protobufCodec) Decode(data []byte) (message.Message, error) {
+	var msg message.Message
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return message.Message{}, fmt.Errorf("%w: %v", message.ErrInvalidPayload, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		var target *string
+		switch number {
+		case fieldID:
+			target = &msg.ID
+		case fieldFrom:
+			target = &msg.From
+		case fieldTo:
+			target = &msg.To
+		case fieldContent:
+			target = &msg.Content
+		default:
+			return message.Message{}, fmt.Errorf("%w: unknown field %d", message.ErrInvalidPayload, number)
+		}
+		if wireType != protowire.BytesType {
+			return message.Message{}, fmt.Errorf("%w: field %d is not a string", message.ErrInvalidPayload, number)
+		}
+		value, n := protowire.ConsumeString(data)
+		if n < 0 {
+			return message.Message{}, fmt.Errorf("%w: %v", message.ErrInvalidPayload, protowire.ParseError(n))
+		}
+		*target = value
+		data = data[n:]
+	}
+	return msg, nil
+}
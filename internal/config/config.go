@@ -1,5 +1,118 @@
 package config
 
+import (
+	"time"
+)
+
 type Config struct {
 	SessionLimit int `env:"SESSION_LIMIT" envDefault:"10"`
+
+	// Logging: level is debug, info, warn or error; format is text or json.
+	// Message content is only logged when LOG_MESSAGE_CONTENT is set.
+	LogLevel          string `env:"LOG_LEVEL" envDefault:"info"`
+	LogFormat         string `env:"LOG_FORMAT" envDefault:"text"`
+	LogMessageContent bool   `env:"LOG_MESSAGE_CONTENT" envDefault:"false"`
+
+	// Health checks and graceful shutdown
+	ReadinessTimeout   time.Duration `env:"READINESS_TIMEOUT" envDefault:"2s"`
+	ShutdownDrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" envDefault:"5s"`
+	ShutdownTimeout    time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s"`
+
+	// Authentication on the websocket upgrade, disabled when neither a
+	// secret nor a JWKS file is set
+	AuthHMACSecret string `env:"AUTH_HMAC_SECRET"`
+	AuthJWKSFile   string `env:"AUTH_JWKS_FILE"`
+	AuthIssuer     string `env:"AUTH_ISSUER"`
+	AuthAudience   string `env:"AUTH_AUDIENCE"`
+	AuthQueryParam string `env:"AUTH_QUERY_PARAM" envDefault:"token"`
+
+	// WebSocket upgrade policy. Without allowed origins only same-origin
+	// browser requests are accepted; "*" accepts any origin
+	AllowedOrigins      []string `env:"ALLOWED_ORIGINS" envSeparator:","`
+	WSReadBufferSize    int      `env:"WS_READ_BUFFER_SIZE" envDefault:"1024"`
+	WSWriteBufferSize   int      `env:"WS_WRITE_BUFFER_SIZE" envDefault:"1024"`
+	WSSubprotocols      []string `env:"WS_SUBPROTOCOLS" envSeparator:","`
+	WSEnableCompression bool     `env:"WS_ENABLE_COMPRESSION" envDefault:"false"`
+	// Flate level of compressed frames (-2 to 9, zero for the default) and
+	// the smallest frame in bytes worth compressing
+	WSCompressionLevel     int `env:"WS_COMPRESSION_LEVEL" envDefault:"0"`
+	WSCompressionThreshold int `env:"WS_COMPRESSION_THRESHOLD" envDefault:"512"`
+
+	// Message size limits in bytes, zero disables a limit
+	MaxFrameSize     int64 `env:"MAX_FRAME_SIZE" envDefault:"65536"`
+	MaxContentLength int   `env:"MAX_CONTENT_LENGTH" envDefault:"4096"`
+
+	// Rate limits, zero disables a limit
+	RateMessagesPerSecond      float64 `env:"RATE_MESSAGES_PER_SECOND" envDefault:"10"`
+	RateMessageBurst           int     `env:"RATE_MESSAGE_BURST" envDefault:"20"`
+	RateMatchmakingPerMinute   float64 `env:"RATE_MATCHMAKING_PER_MINUTE" envDefault:"10"`
+	RateIPMessagesPerSecond    float64 `env:"RATE_IP_MESSAGES_PER_SECOND" envDefault:"50"`
+	RateIPMatchmakingPerMinute float64 `env:"RATE_IP_MATCHMAKING_PER_MINUTE" envDefault:"30"`
+	RateMaxConnectionsPerIP    int     `env:"RATE_MAX_CONNECTIONS_PER_IP" envDefault:"20"`
+	RateMaxViolations          int     `env:"RATE_MAX_VIOLATIONS" envDefault:"10"`
+
+	// What happens when a player opens another connection: allow, kick-old or reject-new
+	MultiConnectionPolicy string `env:"MULTI_CONNECTION_POLICY" envDefault:"allow"`
+
+	// Messages kept per chat room for late joiners, zero disables history
+	RoomHistorySize int `env:"ROOM_HISTORY_SIZE" envDefault:"20"`
+
+	// Most players a host can let into a private lobby
+	LobbyMaxPlayers int `env:"LOBBY_MAX_PLAYERS" envDefault:"8"`
+
+	// Spectators allowed per session, zero for no limit, and how long after
+	// being sent session messages reach them
+	MaxSpectators  int           `env:"MAX_SPECTATORS" envDefault:"20"`
+	SpectatorDelay time.Duration `env:"SPECTATOR_DELAY" envDefault:"0s"`
+
+	// How long a player has to report a session result once the opponent
+	// reported, and how long finished sessions stay in the match history
+	ResultReportTimeout time.Duration `env:"RESULT_REPORT_TIMEOUT" envDefault:"30s"`
+	SessionHistoryTTL   time.Duration `env:"SESSION_HISTORY_TTL" envDefault:"720h"`
+
+	// How long a player who lost its last connection has to come back
+	// before abandoning its sessions, whether the only player left then
+	// wins by forfeit, and how long leavers stay out of matchmaking (zero
+	// for no penalty)
+	ReconnectWindow time.Duration `env:"RECONNECT_WINDOW" envDefault:"60s"`
+	AbandonForfeit  bool          `env:"ABANDON_FORFEIT" envDefault:"true"`
+	LeaverPenalty   time.Duration `env:"LEAVER_PENALTY" envDefault:"5m"`
+
+	// Where direct messages to offline players are kept: memory, sqlite, or empty to drop them
+	OfflineMessageStore  string `env:"OFFLINE_MESSAGE_STORE"`
+	OfflineMessageDBPath string `env:"OFFLINE_MESSAGE_DB_PATH" envDefault:"offline-messages.db"`
+	OfflineMessageLimit  int    `env:"OFFLINE_MESSAGE_LIMIT" envDefault:"100"`
+
+	// How server events such as session notifications are resent until acknowledged
+	DeliveryRetryInterval time.Duration `env:"DELIVERY_RETRY_INTERVAL" envDefault:"2s"`
+	DeliveryMaxAttempts   int           `env:"DELIVERY_MAX_ATTEMPTS" envDefault:"5"`
+
+	// Unique name of this node, defaults to the hostname
+	NodeID string `env:"NODE_ID"`
+	// Pub/sub backplane connecting the nodes of a deployment: redis, or empty for a single node
+	Backplane              string `env:"BACKPLANE"`
+	BackplaneChannelPrefix string `env:"BACKPLANE_CHANNEL_PREFIX" envDefault:"multiplayer"`
+	RedisURL               string `env:"REDIS_URL" envDefault:"redis://localhost:6379/0"`
+
+	// Where sessions are kept: memory, or redis to share them between nodes
+	// for SESSION_TTL. Redis keys are named "<REDIS_KEY_PREFIX>:...".
+	SessionStore   string        `env:"SESSION_STORE" envDefault:"memory"`
+	SessionTTL     time.Duration `env:"SESSION_TTL" envDefault:"24h"`
+	RedisKeyPrefix string        `env:"REDIS_KEY_PREFIX" envDefault:"multiplayer"`
+	// How long a connection stays in the presence registry without a refresh
+	PresenceTTL time.Duration `env:"PRESENCE_TTL" envDefault:"30s"`
+
+	// Where players are matched: local matches each node's players on their
+	// own, distributed matches every node's players on the node holding the
+	// leader lease in Redis and needs the backplane
+	MatchmakingMode string        `env:"MATCHMAKING_MODE" envDefault:"local"`
+	LeaderLeaseTTL  time.Duration `env:"LEADER_LEASE_TTL" envDefault:"10s"`
+
+	// Bearer token for the admin API, empty disables it
+	AdminToken string `env:"ADMIN_TOKEN"`
+}
+
+// AuthEnabled reports whether upgrade requests must carry a valid token
+func (c Config) AuthEnabled() bool {
+	return c.AuthHMACSecret != "" || c.AuthJWKSFile != ""
 }
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Leases grant time-limited ownership of a key to a single holder, e.g. the
+// node running matchmaking. A holder keeps a lease by renewing it before it expires.
+type Leases interface {
+	// AcquireLease takes the lease on key for holder, or renews it if holder
+	// already owns it, and reports whether holder owns it afterwards
+	AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives the lease up if holder owns it
+	ReleaseLease(ctx context.Context, key, holder string) error
+	// LeaseHolder returns the current holder of key, empty when it is free
+	LeaseHolder(ctx context.Context, key string) (string, error)
+}
@@ -0,0 +1,57 @@
+package local
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+// Leases keeps leases in process memory, for tests and single-process deployments
+type Leases struct {
+	mutex  sync.Mutex
+	leases map[string]lease
+}
+
+// NewLeases creates an in-memory lease store
+func NewLeases() *Leases {
+	return &Leases{leases: make(map[string]lease)}
+}
+
+func (l *Leases) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	current, held := l.leases[key]
+	if held && current.holder != holder && now.Before(current.expires) {
+		return false, nil
+	}
+	l.leases[key] = lease{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (l *Leases) ReleaseLease(ctx context.Context, key, holder string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if current, held := l.leases[key]; held && current.holder == holder {
+		delete(l.leases, key)
+	}
+	return nil
+}
+
+func (l *Leases) LeaseHolder(ctx context.Context, key string) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current, held := l.leases[key]
+	if !held || !time.Now().Before(current.expires) {
+		return "", nil
+	}
+	return current.holder, nil
+}
@@ -0,0 +1,44 @@
+package local
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+	ctx := context.Background()
+	leases := NewLeases()
+
+	if ok, _ := leases.AcquireLease(ctx, "leader", "node1", 50*time.Millisecond); !ok {
+		t.Fatal("Expected node1 to acquire the free lease")
+	}
+	if ok, _ := leases.AcquireLease(ctx, "leader", "node2", 50*time.Millisecond); ok {
+		t.Error("Expected node2 not to acquire a held lease")
+	}
+	if ok, _ := leases.AcquireLease(ctx, "leader", "node1", 50*time.Millisecond); !ok {
+		t.Error("Expected node1 to renew its lease")
+	}
+	if holder, _ := leases.LeaseHolder(ctx, "leader"); holder != "node1" {
+		t.Errorf("Expected node1 to hold the lease, got '%s'", holder)
+	}
+
+	// Releasing someone else's lease does nothing
+	leases.ReleaseLease(ctx, "leader", "node2")
+	if holder, _ := leases.LeaseHolder(ctx, "leader"); holder != "node1" {
+		t.Errorf("Expected node1 to still hold the lease, got '%s'", holder)
+	}
+	leases.ReleaseLease(ctx, "leader", "node1")
+	if ok, _ := leases.AcquireLease(ctx, "leader", "node2", 50*time.Millisecond); !ok {
+		t.Fatal("Expected node2 to acquire the released lease")
+	}
+
+	// An expired lease is free for anyone
+	time.Sleep(60 * time.Millisecond)
+	if holder, _ := leases.LeaseHolder(ctx, "leader"); holder != "" {
+		t.Errorf("Expected the lease to expire, got holder '%s'", holder)
+	}
+	if ok, _ := leases.AcquireLease(ctx, "leader", "node1", 50*time.Millisecond); !ok {
+		t.Error("Expected node1 to acquire the expired lease")
+	}
+}
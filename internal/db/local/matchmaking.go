@@ -1,20 +1,202 @@
 package local
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
 	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
 )
 
 var LocalDB = make(map[string]interface{})
 
+// mutex guards LocalDB, which is shared by the matchmaking loop and the admin API
+var mutex sync.RWMutex
+
+// pruneInterval is how often stores go through LocalDB for expired history
+const pruneInterval = time.Minute
+
+// lastPruned is when LocalDB was last pruned; mutex guards it
+var lastPruned time.Time
+
+// openSessions indexes the sessions that aren't over by player; mutex guards it
+var openSessions = make(map[string]map[string]struct{}) // player ID -> session IDs
+
 type DB struct {
+	// HistoryTTL is how long a session that is over is kept for match
+	// history; zero keeps it until restart
+	HistoryTTL time.Duration
+}
+
+// CreateSession stores a proposed session of mode with playerIDs in slot order
+func (l DB) CreateSession(sessionID, mode string, playerIDs []string, createdAt time.Time) error {
+	session := matchmaking.NewSession(sessionID, mode, playerIDs, createdAt)
+	mutex.Lock()
+	defer mutex.Unlock()
+	LocalDB[sessionID] = session
+	for _, playerID := range session.Players() {
+		if openSessions[playerID] == nil {
+			openSessions[playerID] = make(map[string]struct{})
+		}
+		openSessions[playerID][sessionID] = struct{}{}
+	}
+	l.prune(time.Now())
+	return nil
+}
+
+// ListSessions returns every stored session ordered by ID
+func (l DB) ListSessions() ([]matchmaking.Session, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	sessions := make([]matchmaking.Session, 0, len(LocalDB))
+	for _, value := range LocalDB {
+		if session, ok := value.(matchmaking.Session); ok {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions, nil
+}
+
+// GetSession returns a stored session
+func (l DB) GetSession(sessionID string) (matchmaking.Session, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	session, ok := LocalDB[sessionID].(matchmaking.Session)
+	if !ok {
+		return matchmaking.Session{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	return session, nil
+}
+
+// EndSession removes a session and returns it
+func (l DB) EndSession(sessionID string) (matchmaking.Session, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	session, ok := LocalDB[sessionID].(matchmaking.Session)
+	if !ok {
+		return matchmaking.Session{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	delete(LocalDB, sessionID)
+	closeSession(session)
+	return session, nil
+}
+
+// OpenSessions returns the sessions of a player that aren't over, ordered by ID
+func (l DB) OpenSessions(playerID string) ([]matchmaking.Session, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	sessions := make([]matchmaking.Session, 0, len(openSessions[playerID]))
+	for sessionID := range openSessions[playerID] {
+		if session, ok := LocalDB[sessionID].(matchmaking.Session); ok && !session.Status.Over() {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions, nil
 }
 
-func (l DB) CreateSession(sessionID, player1ConnectionID, player2ConnectionID string) error {
-	session := matchmaking.Session{
-		SessionID:           sessionID,
-		Player1ConnectionID: player1ConnectionID,
-		Player2ConnectionID: player2ConnectionID,
+// closeSession takes a session out of its players' open sessions; the
+// caller holds the write lock
+func closeSession(session matchmaking.Session) {
+	for _, playerID := range session.Players() {
+		delete(openSessions[playerID], session.SessionID)
+		if len(openSessions[playerID]) == 0 {
+			delete(openSessions, playerID)
+		}
+	}
+}
+
+// SetSessionStatus moves a stored session to status and returns the status it left
+func (l DB) SetSessionStatus(sessionID, status string, at time.Time) (string, error) {
+	return l.update(sessionID, matchmaking.Status(status), at, nil)
+}
+
+// FinishSession records the end and outcome of a stored session
+func (l DB) FinishSession(sessionID string, outcome message.Outcome, endedAt time.Time) (string, error) {
+	return l.update(sessionID, matchmaking.StatusFinished, endedAt, &outcome)
+}
+
+// AbandonSession marks a stored session abandoned, recording outcome when it is set
+func (l DB) AbandonSession(sessionID string, outcome *message.Outcome, endedAt time.Time) (string, error) {
+	return l.update(sessionID, matchmaking.StatusAbandoned, endedAt, outcome)
+}
+
+// update moves a stored session to status, recording outcome when it is set
+func (l DB) update(sessionID string, status matchmaking.Status, at time.Time, outcome *message.Outcome) (string, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	session, ok := LocalDB[sessionID].(matchmaking.Session)
+	if !ok {
+		return "", fmt.Errorf("session %s not found", sessionID)
+	}
+	if outcome != nil && session.Finished() {
+		return string(session.Status), fmt.Errorf("%w: %s", db.ErrSessionFinished, sessionID)
+	}
+	from, err := session.Transition(status, at)
+	if err != nil {
+		return string(from), err
+	}
+	if outcome != nil {
+		session.Outcome = outcome
 	}
 	LocalDB[sessionID] = session
+	if session.Status.Over() {
+		closeSession(session)
+	}
+	l.prune(time.Now())
+	return string(from), nil
+}
+
+// expired reports whether a session is over and past the history TTL
+func (l DB) expired(session matchmaking.Session, now time.Time) bool {
+	return l.HistoryTTL > 0 && session.Finished() && session.EndedAt != nil && now.Sub(*session.EndedAt) > l.HistoryTTL
+}
+
+// prune deletes sessions past the history TTL, at most once per
+// pruneInterval; the caller holds the write lock
+func (l DB) prune(now time.Time) {
+	if l.HistoryTTL <= 0 || now.Sub(lastPruned) < pruneInterval {
+		return
+	}
+	lastPruned = now
+	for sessionID, value := range LocalDB {
+		if session, ok := value.(matchmaking.Session); ok && l.expired(session, now) {
+			delete(LocalDB, sessionID)
+		}
+	}
+}
+
+// PlayerSessions returns the last limit finished sessions of a player within
+// the history TTL, newest first
+func (l DB) PlayerSessions(playerID string, limit int) ([]matchmaking.Session, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	now := time.Now()
+	sessions := []matchmaking.Session{}
+	for _, value := range LocalDB {
+		if session, ok := value.(matchmaking.Session); ok && session.Finished() && session.HasPlayer(playerID) && !l.expired(session, now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].EndedAt.After(*sessions[j].EndedAt)
+	})
+	if len(sessions) > limit {
+		sessions = sessions[:limit]
+	}
+	return sessions, nil
+}
+
+// Ping always succeeds as the local DB lives in process memory
+func (l DB) Ping(ctx context.Context) error {
 	return nil
 }
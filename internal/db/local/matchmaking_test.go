@@ -1,9 +1,13 @@
 package local
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"simple-multiplayer-service/internal/db"
 	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
 )
 
 func TestCreateSession(t *testing.T) {
@@ -19,7 +23,8 @@ func TestCreateSession(t *testing.T) {
 	player2ID := "player2"
 
 	// Execute
-	err := db.CreateSession(sessionID, player1ID, player2ID)
+	createdAt := time.Now()
+	err := db.CreateSession(sessionID, matchmaking.ModeMatchmaking, []string{player1ID, player2ID}, createdAt)
 
 	// Verify
 	if err != nil {
@@ -38,14 +43,157 @@ func TestCreateSession(t *testing.T) {
 		t.Errorf("Expected session to be of type matchmaking.Session, got %T", session)
 	}
 
-	// Note: The implementation in matchmaking.go swaps player1 and player2
 	if sessionObj.SessionID != sessionID {
 		t.Errorf("Expected SessionID to be %s, got %s", sessionID, sessionObj.SessionID)
 	}
-	if sessionObj.Player1ConnectionID != player2ID {
-		t.Errorf("Expected Player1ConnectionID to be %s, got %s", player2ID, sessionObj.Player1ConnectionID)
+	if sessionObj.Player1ID != player1ID {
+		t.Errorf("Expected Player1ID to be %s, got %s", player1ID, sessionObj.Player1ID)
 	}
-	if sessionObj.Player2ConnectionID != player1ID {
-		t.Errorf("Expected Player2ConnectionID to be %s, got %s", player1ID, sessionObj.Player2ConnectionID)
+	if sessionObj.Player2ID != player2ID {
+		t.Errorf("Expected Player2ID to be %s, got %s", player2ID, sessionObj.Player2ID)
+	}
+	if sessionObj.Status != matchmaking.StatusProposed || sessionObj.Mode != matchmaking.ModeMatchmaking || !sessionObj.CreatedAt.Equal(createdAt) {
+		t.Errorf("Expected a proposed matchmaking session created at %v, got %+v", createdAt, sessionObj)
+	}
+}
+
+func TestListAndEndSessions(t *testing.T) {
+	// Clear the LocalDB before the test
+	for k := range LocalDB {
+		delete(LocalDB, k)
+	}
+
+	db := DB{}
+	_ = db.CreateSession("session-b", matchmaking.ModeMatchmaking, []string{"player3", "player4"}, time.Now())
+	_ = db.CreateSession("session-a", matchmaking.ModeMatchmaking, []string{"player1", "player2"}, time.Now())
+
+	sessions, err := db.ListSessions()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].SessionID != "session-a" || sessions[1].SessionID != "session-b" {
+		t.Errorf("Expected sessions a and b in order, got %v", sessions)
+	}
+
+	ended, err := db.EndSession("session-a")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ended.Player1ID != "player1" {
+		t.Errorf("Expected ended session to be returned, got %v", ended)
+	}
+	if _, ok := LocalDB["session-a"]; ok {
+		t.Error("Expected session-a to be removed")
+	}
+
+	if _, err := db.EndSession("session-a"); err == nil {
+		t.Error("Expected error ending an unknown session")
+	}
+}
+
+func TestFinishSessionAndHistory(t *testing.T) {
+	for k := range LocalDB {
+		delete(LocalDB, k)
+	}
+
+	store := DB{}
+	_ = store.CreateSession("session-1", matchmaking.ModeMatchmaking, []string{"alice", "bob"}, time.Now())
+	_ = store.CreateSession("session-2", matchmaking.ModeMatchmaking, []string{"carol", "alice"}, time.Now())
+	_ = store.CreateSession("session-3", matchmaking.ModeMatchmaking, []string{"alice", "dave"}, time.Now())
+
+	if _, err := store.FinishSession("missing", message.Outcome{Draw: true}, time.Now()); err == nil {
+		t.Error("Expected finishing an unknown session to fail")
+	}
+	if _, err := store.FinishSession("session-1", message.Outcome{Draw: true}, time.Now()); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected finishing a session that never started to fail, got %v", err)
+	}
+	start := time.Now()
+	for _, sessionID := range []string{"session-1", "session-2", "session-3"} {
+		if from, err := store.SetSessionStatus(sessionID, string(matchmaking.StatusActive), start); err != nil || from != string(matchmaking.StatusProposed) {
+			t.Fatalf("Expected to start %s, got %q %v", sessionID, from, err)
+		}
+	}
+	store.FinishSession("session-1", message.Outcome{WinnerID: "alice"}, start)
+	store.FinishSession("session-2", message.Outcome{Draw: true}, start.Add(time.Minute))
+
+	if _, err := store.FinishSession("session-1", message.Outcome{WinnerID: "bob"}, start); !errors.Is(err, db.ErrSessionFinished) {
+		t.Errorf("Expected finishing twice to fail, got %v", err)
+	}
+	if _, err := store.SetSessionStatus("session-1", string(matchmaking.StatusPaused), start); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected pausing a finished session to fail, got %v", err)
+	}
+
+	session, _ := store.GetSession("session-1")
+	if !session.Finished() || session.Status != matchmaking.StatusFinished || session.Outcome.WinnerID != "alice" || !session.EndedAt.Equal(start) || !session.StartedAt.Equal(start) {
+		t.Errorf("Expected session-1 to be finished, got %+v", session)
+	}
+
+	// Unfinished sessions aren't history
+	history, err := store.PlayerSessions("alice", 10)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(history) != 2 || history[0].SessionID != "session-2" || history[1].SessionID != "session-1" {
+		t.Errorf("Expected alice's finished sessions, newest first, got %v", history)
+	}
+	if history, _ := store.PlayerSessions("alice", 1); len(history) != 1 || history[0].SessionID != "session-2" {
+		t.Errorf("Expected the limit to keep the newest session, got %v", history)
+	}
+	if history, _ := store.PlayerSessions("dave", 10); len(history) != 0 {
+		t.Errorf("Expected dave to have no history, got %v", history)
+	}
+}
+
+func TestAbandonSession(t *testing.T) {
+	store := DB{}
+	_ = store.CreateSession("abandoned-1", matchmaking.ModeMatchmaking, []string{"erin", "frank"}, time.Now())
+	start := time.Now()
+	store.SetSessionStatus("abandoned-1", string(matchmaking.StatusActive), start)
+	if open, _ := store.OpenSessions("erin"); len(open) != 1 || open[0].SessionID != "abandoned-1" || open[0].Status != matchmaking.StatusActive {
+		t.Errorf("Expected erin's open session, got %+v", open)
+	}
+
+	forfeit := &message.Outcome{WinnerID: "frank", Resolution: message.ResolutionForfeit}
+	if from, err := store.AbandonSession("abandoned-1", forfeit, start); err != nil || from != string(matchmaking.StatusActive) {
+		t.Fatalf("Expected to abandon the active session, got %q %v", from, err)
+	}
+	if _, err := store.AbandonSession("abandoned-1", nil, start); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected abandoning twice to fail, got %v", err)
+	}
+	if open, _ := store.OpenSessions("frank"); len(open) != 0 {
+		t.Errorf("Expected the abandoned session to be closed, got %+v", open)
+	}
+
+	history, _ := store.PlayerSessions("erin", 10)
+	if len(history) != 1 || history[0].Status != matchmaking.StatusAbandoned || history[0].Outcome.WinnerID != "frank" {
+		t.Errorf("Expected the abandoned session in erin's history, got %+v", history)
+	}
+}
+
+func TestHistoryTTL(t *testing.T) {
+	store := DB{HistoryTTL: time.Hour}
+	now := time.Now()
+	for _, sessionID := range []string{"expiring-old", "expiring-new"} {
+		_ = store.CreateSession(sessionID, matchmaking.ModeMatchmaking, []string{"grace", "heidi"}, now.Add(-3*time.Hour))
+		store.SetSessionStatus(sessionID, string(matchmaking.StatusActive), now.Add(-3*time.Hour))
+	}
+	store.FinishSession("expiring-old", message.Outcome{Draw: true}, now.Add(-2*time.Hour))
+	store.FinishSession("expiring-new", message.Outcome{Draw: true}, now)
+
+	history, _ := store.PlayerSessions("grace", 10)
+	if len(history) != 1 || history[0].SessionID != "expiring-new" {
+		t.Errorf("Expected only the session within the TTL, got %+v", history)
+	}
+
+	// Expired sessions are dropped from the store
+	mutex.Lock()
+	lastPruned = time.Time{}
+	mutex.Unlock()
+	_ = store.CreateSession("expiring-next", matchmaking.ModeMatchmaking, []string{"grace", "heidi"}, now)
+	if _, err := store.GetSession("expiring-old"); err == nil {
+		t.Error("Expected the expired session to be deleted")
+	}
+	if _, err := store.GetSession("expiring-new"); err != nil {
+		t.Errorf("Expected the recent session to be kept, got %v", err)
 	}
 }
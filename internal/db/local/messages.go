@@ -0,0 +1,79 @@
+package local
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/google/uuid"
+)
+
+// MessageStore keeps offline messages in process memory; they are lost on restart
+type MessageStore struct {
+	mutex    sync.Mutex
+	messages map[string][]db.StoredMessage // player ID -> pending messages
+	players  map[string]struct{}
+	limit    int
+}
+
+// NewMessageStore creates a store holding up to limit messages per player, zero for no limit
+func NewMessageStore(limit int) *MessageStore {
+	return &MessageStore{messages: make(map[string][]db.StoredMessage), players: make(map[string]struct{}), limit: limit}
+}
+
+func (s *MessageStore) AddPlayer(playerID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.players[playerID] = struct{}{}
+	return nil
+}
+
+func (s *MessageStore) StoreMessage(playerID string, msg message.Message) (db.StoredMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, known := s.players[playerID]; !known {
+		return db.StoredMessage{}, db.ErrUnknownPlayer
+	}
+	if s.limit > 0 && len(s.messages[playerID]) >= s.limit {
+		return db.StoredMessage{}, db.ErrMailboxFull
+	}
+	stored := db.StoredMessage{ID: uuid.New().String(), PlayerID: playerID, Message: msg, StoredAt: time.Now().UTC()}
+	s.messages[playerID] = append(s.messages[playerID], stored)
+	return stored, nil
+}
+
+func (s *MessageStore) PendingMessages(playerID string) ([]db.StoredMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending := make([]db.StoredMessage, len(s.messages[playerID]))
+	copy(pending, s.messages[playerID])
+	return pending, nil
+}
+
+func (s *MessageStore) AckMessage(playerID, messageID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending := s.messages[playerID]
+	for i, stored := range pending {
+		if stored.ID == messageID {
+			s.messages[playerID] = append(pending[:i:i], pending[i+1:]...)
+			if len(s.messages[playerID]) == 0 {
+				delete(s.messages, playerID)
+			}
+			return nil
+		}
+	}
+	return db.ErrMessageNotFound
+}
+
+// Ping always succeeds as the store lives in process memory
+func (s *MessageStore) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package local
+
+import (
+	"errors"
+	"testing"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/message"
+)
+
+func TestMessageStore(t *testing.T) {
+	store := NewMessageStore(2)
+
+	if _, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "hello?"}); !errors.Is(err, db.ErrUnknownPlayer) {
+		t.Errorf("Expected ErrUnknownPlayer before alice is added, got %v", err)
+	}
+	store.AddPlayer("alice")
+
+	first, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "first"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "second"})
+
+	if _, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "third"}); !errors.Is(err, db.ErrMailboxFull) {
+		t.Errorf("Expected ErrMailboxFull, got %v", err)
+	}
+
+	pending, _ := store.PendingMessages("alice")
+	if len(pending) != 2 || pending[0].Message.Content != "first" || pending[1].Message.Content != "second" {
+		t.Fatalf("Expected both messages oldest first, got %v", pending)
+	}
+
+	if err := store.AckMessage("alice", first.ID); err != nil {
+		t.Errorf("Expected no error acknowledging, got %v", err)
+	}
+	if err := store.AckMessage("alice", first.ID); !errors.Is(err, db.ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound acknowledging twice, got %v", err)
+	}
+	if err := store.AckMessage("bob", pending[1].ID); !errors.Is(err, db.ErrMessageNotFound) {
+		t.Errorf("Expected another player's ack to fail, got %v", err)
+	}
+
+	pending, _ = store.PendingMessages("alice")
+	if len(pending) != 1 || pending[0].Message.Content != "second" {
+		t.Errorf("Expected only the second message to remain, got %v", pending)
+	}
+}
@@ -1,5 +1,36 @@
 package db
 
+import (
+	"context"
+	"errors"
+	"time"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+var (
+	// ErrSessionFinished is returned when finishing a session that is already over
+	ErrSessionFinished = errors.New("session already finished")
+	// ErrIllegalTransition is returned when a session can't move to the requested status
+	ErrIllegalTransition = errors.New("illegal session status transition")
+)
+
 type Session interface {
-	CreateSession(sessionID, player1ConnectionID, player2ConnectionID string) error
+	// CreateSession stores a proposed session of mode with playerIDs in slot order
+	CreateSession(sessionID, mode string, playerIDs []string, createdAt time.Time) error
+	// SetSessionStatus moves a session to status at the given time and
+	// returns the status it left, refusing illegal transitions with
+	// ErrIllegalTransition
+	SetSessionStatus(sessionID, status string, at time.Time) (string, error)
+	// FinishSession records when a session ended and its outcome, keeping
+	// it for the players' match history, and returns the status it left
+	FinishSession(sessionID string, outcome message.Outcome, endedAt time.Time) (string, error)
+	// AbandonSession marks a session abandoned, recording outcome when a
+	// player forfeited, and returns the status it left
+	AbandonSession(sessionID string, outcome *message.Outcome, endedAt time.Time) (string, error)
+}
+
+// Pinger is implemented by session backends that can report their health
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+var (
+	// ErrMailboxFull is returned when a player already has the maximum number of pending messages
+	ErrMailboxFull = errors.New("mailbox full")
+	// ErrMessageNotFound is returned when acknowledging a message that isn't pending
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrUnknownPlayer is returned when storing a message for a player who never connected
+	ErrUnknownPlayer = errors.New("unknown player")
+)
+
+// StoredMessage is a direct message kept until its recipient acknowledges it
+type StoredMessage struct {
+	ID       string
+	PlayerID string
+	Message  message.Message
+	StoredAt time.Time
+}
+
+// MessageStore persists direct messages for players who are offline
+type MessageStore interface {
+	// AddPlayer records playerID as a player whose messages may be stored
+	AddPlayer(playerID string) error
+	// StoreMessage keeps msg for playerID until it is acknowledged, failing
+	// with ErrUnknownPlayer for players never added
+	StoreMessage(playerID string, msg message.Message) (StoredMessage, error)
+	// PendingMessages returns a player's unacknowledged messages, oldest first
+	PendingMessages(playerID string) ([]StoredMessage, error)
+	// AckMessage deletes a delivered message
+	AckMessage(playerID, messageID string) error
+}
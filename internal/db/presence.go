@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Presence records which node each connection is open on, so a message for a
+// player connected elsewhere can be sent straight to that node. Entries
+// expire unless refreshed, which clears the connections of a node that died.
+type Presence interface {
+	// SetPresence records that connectionID of playerID is open on nodeID for ttl
+	SetPresence(ctx context.Context, nodeID, connectionID, playerID string, ttl time.Duration) error
+	// RemovePresence forgets a closed connection
+	RemovePresence(ctx context.Context, connectionID, playerID string) error
+	// ConnectionNode returns the node a connection is open on, empty when unknown
+	ConnectionNode(ctx context.Context, connectionID string) (string, error)
+	// PlayerNodes returns the nodes a player has connections open on
+	PlayerNodes(ctx context.Context, playerID string) ([]string, error)
+}
@@ -0,0 +1,83 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A connection is kept at <prefix>:presence:conn:<connection ID> holding its
+// node, and in the hash <prefix>:presence:player:<player ID> mapping the
+// player's connection IDs to their nodes. Both expire unless refreshed.
+
+func (s *Store) SetPresence(ctx context.Context, nodeID, connectionID, playerID string, ttl time.Duration) error {
+	playerKey := s.key("presence", "player", playerID)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key("presence", "conn", connectionID), nodeID, ttl)
+		pipe.HSet(ctx, playerKey, connectionID, nodeID)
+		pipe.PExpire(ctx, playerKey, ttl)
+		return nil
+	})
+	return err
+}
+
+func (s *Store) RemovePresence(ctx context.Context, connectionID, playerID string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.key("presence", "conn", connectionID))
+		pipe.HDel(ctx, s.key("presence", "player", playerID), connectionID)
+		return nil
+	})
+	return err
+}
+
+func (s *Store) ConnectionNode(ctx context.Context, connectionID string) (string, error) {
+	nodeID, err := s.client.Get(ctx, s.key("presence", "conn", connectionID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return nodeID, err
+}
+
+// PlayerNodes skips connections that expired while another of the player's
+// connections kept the hash alive, and removes them from the hash
+func (s *Store) PlayerNodes(ctx context.Context, playerID string) ([]string, error) {
+	playerKey := s.key("presence", "player", playerID)
+	connections, err := s.client.HGetAll(ctx, playerKey).Result()
+	if err != nil || len(connections) == 0 {
+		return nil, err
+	}
+
+	connectionIDs := make([]string, 0, len(connections))
+	keys := make([]string, 0, len(connections))
+	for connectionID := range connections {
+		connectionIDs = append(connectionIDs, connectionID)
+		keys = append(keys, s.key("presence", "conn", connectionID))
+	}
+	live, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var nodes []string
+	var expired []string
+	for i, value := range live {
+		nodeID, ok := value.(string)
+		if !ok {
+			expired = append(expired, connectionIDs[i])
+			continue
+		}
+		if !seen[nodeID] {
+			seen[nodeID] = true
+			nodes = append(nodes, nodeID)
+		}
+	}
+	if len(expired) > 0 {
+		s.client.HDel(ctx, playerKey, expired...)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
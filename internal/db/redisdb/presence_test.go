@@ -0,0 +1,49 @@
+package redisdb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t)
+
+	store.SetPresence(ctx, "node1", "conn1", "alice", time.Minute)
+	store.SetPresence(ctx, "node2", "conn2", "alice", time.Minute)
+	store.SetPresence(ctx, "node2", "conn3", "bob", 10*time.Second)
+
+	if node, err := store.ConnectionNode(ctx, "conn1"); node != "node1" || err != nil {
+		t.Errorf("Expected conn1 on node1, got '%s', %v", node, err)
+	}
+	if nodes, err := store.PlayerNodes(ctx, "alice"); !reflect.DeepEqual(nodes, []string{"node1", "node2"}) || err != nil {
+		t.Errorf("Expected alice on node1 and node2, got %v, %v", nodes, err)
+	}
+	if node, _ := store.ConnectionNode(ctx, "unknown"); node != "" {
+		t.Errorf("Expected an unknown connection to have no node, got '%s'", node)
+	}
+
+	store.RemovePresence(ctx, "conn2", "alice")
+	if nodes, _ := store.PlayerNodes(ctx, "alice"); !reflect.DeepEqual(nodes, []string{"node1"}) {
+		t.Errorf("Expected alice only on node1 after closing conn2, got %v", nodes)
+	}
+
+	// Connections that aren't refreshed expire
+	server.FastForward(30 * time.Second)
+	if nodes, _ := store.PlayerNodes(ctx, "bob"); len(nodes) != 0 {
+		t.Errorf("Expected bob's connection to expire, got %v", nodes)
+	}
+
+	// A connection expiring while the player's hash lives on is skipped and pruned
+	store.SetPresence(ctx, "node1", "conn4", "carol", 10*time.Second)
+	store.SetPresence(ctx, "node2", "conn5", "carol", time.Minute)
+	server.FastForward(30 * time.Second)
+	if nodes, _ := store.PlayerNodes(ctx, "carol"); !reflect.DeepEqual(nodes, []string{"node2"}) {
+		t.Errorf("Expected carol only on node2, got %v", nodes)
+	}
+	if fields, _ := server.HKeys("test:presence:player:carol"); !reflect.DeepEqual(fields, []string{"conn5"}) {
+		t.Errorf("Expected the expired connection to be pruned, got %v", fields)
+	}
+}
@@ -0,0 +1,299 @@
+package redisdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CreateSession stores a proposed session that expires after SessionTTL and
+// adds it to the open sessions of its players, a set at <prefix>:open:<player ID>
+func (s *Store) CreateSession(sessionID, mode string, playerIDs []string, createdAt time.Time) error {
+	session := matchmaking.NewSession(sessionID, mode, playerIDs, createdAt)
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.key("session", sessionID), data, s.SessionTTL)
+		pipe.SAdd(ctx, s.key("sessions"), sessionID)
+		for _, playerID := range session.Players() {
+			open := s.key("open", playerID)
+			pipe.SAdd(ctx, open, sessionID)
+			if s.SessionTTL > 0 {
+				pipe.Expire(ctx, open, s.SessionTTL)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// ListSessions returns every stored session ordered by ID, forgetting expired ones
+func (s *Store) ListSessions() ([]matchmaking.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	ids, err := s.client.SMembers(ctx, s.key("sessions")).Result()
+	if err != nil || len(ids) == 0 {
+		return []matchmaking.Session{}, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.key("session", id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]matchmaking.Session, 0, len(values))
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var session matchmaking.Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			return nil, fmt.Errorf("decoding session %s: %w", ids[i], err)
+		}
+		sessions = append(sessions, session)
+	}
+	if len(expired) > 0 {
+		s.client.SRem(ctx, s.key("sessions"), expired...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions, nil
+}
+
+// GetSession returns a stored session
+func (s *Store) GetSession(sessionID string) (matchmaking.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.key("session", sessionID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return matchmaking.Session{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	if err != nil {
+		return matchmaking.Session{}, err
+	}
+	var session matchmaking.Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return matchmaking.Session{}, fmt.Errorf("decoding session %s: %w", sessionID, err)
+	}
+	return session, nil
+}
+
+// EndSession removes a session and returns it
+func (s *Store) EndSession(sessionID string) (matchmaking.Session, error) {
+	session, err := s.GetSession(sessionID)
+	if err != nil {
+		return session, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	removed, err := s.client.Del(ctx, s.key("session", sessionID)).Result()
+	if err != nil {
+		return matchmaking.Session{}, err
+	}
+	s.client.SRem(ctx, s.key("sessions"), sessionID)
+	for _, playerID := range session.Players() {
+		s.client.SRem(ctx, s.key("open", playerID), sessionID)
+	}
+	// Another node ended it first
+	if removed == 0 {
+		return matchmaking.Session{}, fmt.Errorf("session %s not found", sessionID)
+	}
+	return session, nil
+}
+
+// SetSessionStatus moves a session to status and returns the status it left.
+// Sessions that are over are kept for HistoryTTL in the history of their
+// players, like finished ones.
+func (s *Store) SetSessionStatus(sessionID, status string, at time.Time) (string, error) {
+	return s.update(sessionID, matchmaking.Status(status), at, nil)
+}
+
+// FinishSession records the end and outcome of a session, keeping it for
+// HistoryTTL in the history of its players, a sorted set at
+// <prefix>:history:<player ID> scored by end time
+func (s *Store) FinishSession(sessionID string, outcome message.Outcome, endedAt time.Time) (string, error) {
+	return s.update(sessionID, matchmaking.StatusFinished, endedAt, &outcome)
+}
+
+// AbandonSession marks a session abandoned, recording outcome when it is
+// set, and keeps it in its players' history like a finished one
+func (s *Store) AbandonSession(sessionID string, outcome *message.Outcome, endedAt time.Time) (string, error) {
+	return s.update(sessionID, matchmaking.StatusAbandoned, endedAt, outcome)
+}
+
+// update moves a session to status, recording outcome when it is set
+func (s *Store) update(sessionID string, status matchmaking.Status, at time.Time, outcome *message.Outcome) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	key := s.key("session", sessionID)
+	var from matchmaking.Status
+	// Another node changing the session at the same time aborts the transaction
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("session %s not found", sessionID)
+		}
+		if err != nil {
+			return err
+		}
+		var session matchmaking.Session
+		if err := json.Unmarshal(data, &session); err != nil {
+			return fmt.Errorf("decoding session %s: %w", sessionID, err)
+		}
+		from = session.Status
+		if outcome != nil && session.Finished() {
+			return fmt.Errorf("%w: %s", db.ErrSessionFinished, sessionID)
+		}
+		if _, err := session.Transition(status, at); err != nil {
+			return err
+		}
+		if outcome != nil {
+			session.Outcome = outcome
+		}
+
+		if data, err = json.Marshal(session); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if !session.Finished() {
+				pipe.Set(ctx, key, data, redis.KeepTTL)
+				return nil
+			}
+			pipe.Set(ctx, key, data, max(s.HistoryTTL, 0))
+			for _, playerID := range session.Players() {
+				pipe.SRem(ctx, s.key("open", playerID), sessionID)
+				history := s.key("history", playerID)
+				pipe.ZAdd(ctx, history, redis.Z{Score: float64(at.UnixMilli()), Member: sessionID})
+				if s.HistoryTTL > 0 {
+					pipe.Expire(ctx, history, s.HistoryTTL)
+				}
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		if outcome != nil {
+			return string(from), fmt.Errorf("%w: %s", db.ErrSessionFinished, sessionID)
+		}
+		return string(from), fmt.Errorf("%w: %s changed concurrently", db.ErrIllegalTransition, sessionID)
+	}
+	return string(from), err
+}
+
+// PlayerSessions returns the last limit finished sessions of a player,
+// newest first, forgetting expired ones
+func (s *Store) PlayerSessions(playerID string, limit int) ([]matchmaking.Session, error) {
+	if limit <= 0 {
+		return []matchmaking.Session{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	history := s.key("history", playerID)
+	ids, err := s.client.ZRevRange(ctx, history, 0, int64(limit)-1).Result()
+	if err != nil || len(ids) == 0 {
+		return []matchmaking.Session{}, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.key("session", id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]matchmaking.Session, 0, len(values))
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var session matchmaking.Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			return nil, fmt.Errorf("decoding session %s: %w", ids[i], err)
+		}
+		sessions = append(sessions, session)
+	}
+	if len(expired) > 0 {
+		s.client.ZRem(ctx, history, expired...)
+	}
+	return sessions, nil
+}
+
+// OpenSessions returns the sessions of a player that aren't over, ordered by
+// ID, forgetting expired ones
+func (s *Store) OpenSessions(playerID string) ([]matchmaking.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	open := s.key("open", playerID)
+	ids, err := s.client.SMembers(ctx, open).Result()
+	if err != nil || len(ids) == 0 {
+		return []matchmaking.Session{}, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.key("session", id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]matchmaking.Session, 0, len(values))
+	var closed []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			closed = append(closed, ids[i])
+			continue
+		}
+		var session matchmaking.Session
+		if err := json.Unmarshal([]byte(data), &session); err != nil {
+			return nil, fmt.Errorf("decoding session %s: %w", ids[i], err)
+		}
+		if session.Status.Over() {
+			closed = append(closed, ids[i])
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if len(closed) > 0 {
+		s.client.SRem(ctx, open, closed...)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+	return sessions, nil
+}
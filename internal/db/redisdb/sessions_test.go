@@ -0,0 +1,179 @@
+package redisdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+func TestSessions(t *testing.T) {
+	store, server := newTestStore(t)
+	store.SessionTTL = time.Minute
+
+	if err := store.CreateSession("session2", matchmaking.ModeMatchmaking, []string{"carol", "dave"}, time.Now()); err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	if err := store.CreateSession("session1", matchmaking.ModeMatchmaking, []string{"alice", "bob"}, time.Now()); err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	if ttl := server.TTL("test:session:session1"); ttl != time.Minute {
+		t.Errorf("Expected the session to expire after a minute, got %v", ttl)
+	}
+
+	session, err := store.GetSession("session1")
+	if err != nil || session.Player1ID != "alice" || session.Player2ID != "bob" || session.Status != matchmaking.StatusProposed {
+		t.Errorf("Unexpected session %+v, %v", session, err)
+	}
+
+	// Moving an unfinished session keeps its expiry
+	server.FastForward(10 * time.Second)
+	if from, err := store.SetSessionStatus("session1", string(matchmaking.StatusActive), time.Now()); err != nil || from != string(matchmaking.StatusProposed) {
+		t.Errorf("Expected to start session1, got %q, %v", from, err)
+	}
+	if _, err := store.SetSessionStatus("session1", string(matchmaking.StatusProposed), time.Now()); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected ErrIllegalTransition, got %v", err)
+	}
+	if ttl := server.TTL("test:session:session1"); ttl != 50*time.Second {
+		t.Errorf("Expected the started session to keep its expiry, got %v", ttl)
+	}
+
+	sessions, err := store.ListSessions()
+	if err != nil || len(sessions) != 2 || sessions[0].SessionID != "session1" || sessions[1].SessionID != "session2" {
+		t.Errorf("Expected both sessions ordered by ID, got %+v, %v", sessions, err)
+	}
+
+	ended, err := store.EndSession("session1")
+	if err != nil || ended.SessionID != "session1" {
+		t.Errorf("Unexpected ended session %+v, %v", ended, err)
+	}
+	if _, err := store.EndSession("session1"); err == nil {
+		t.Error("Expected ending a session twice to fail")
+	}
+	if _, err := store.GetSession("session1"); err == nil {
+		t.Error("Expected the ended session to be gone")
+	}
+
+	// Expired sessions drop out of the list and the index
+	server.FastForward(2 * time.Minute)
+	sessions, err = store.ListSessions()
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("Expected no sessions after they expire, got %+v, %v", sessions, err)
+	}
+	if members, _ := server.SMembers("test:sessions"); len(members) != 0 {
+		t.Errorf("Expected expired sessions to leave the index, got %v", members)
+	}
+}
+
+func TestFinishSessionAndHistory(t *testing.T) {
+	store, server := newTestStore(t)
+	store.HistoryTTL = time.Hour
+
+	store.CreateSession("session1", matchmaking.ModeMatchmaking, []string{"alice", "bob"}, time.Now())
+	store.CreateSession("session2", matchmaking.ModeMatchmaking, []string{"carol", "alice"}, time.Now())
+	store.CreateSession("session3", matchmaking.ModeMatchmaking, []string{"alice", "dave"}, time.Now())
+
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for _, sessionID := range []string{"session1", "session2", "session3"} {
+		if _, err := store.SetSessionStatus(sessionID, string(matchmaking.StatusActive), start); err != nil {
+			t.Fatalf("Error starting %s: %v", sessionID, err)
+		}
+	}
+	if _, err := store.FinishSession("session1", message.Outcome{WinnerID: "alice", Resolution: message.ResolutionAgreed}, start); err != nil {
+		t.Fatalf("Error finishing session: %v", err)
+	}
+	store.FinishSession("session2", message.Outcome{Draw: true}, start.Add(time.Minute))
+	if _, err := store.FinishSession("session1", message.Outcome{WinnerID: "bob"}, start); !errors.Is(err, db.ErrSessionFinished) {
+		t.Errorf("Expected finishing twice to fail, got %v", err)
+	}
+	if _, err := store.FinishSession("missing", message.Outcome{Draw: true}, start); err == nil {
+		t.Error("Expected finishing an unknown session to fail")
+	}
+
+	session, err := store.GetSession("session1")
+	if err != nil || !session.Finished() || !session.EndedAt.Equal(start) || session.Outcome.WinnerID != "alice" {
+		t.Errorf("Expected session1 to be finished, got %+v, %v", session, err)
+	}
+	if ttl := server.TTL("test:session:session1"); ttl != time.Hour {
+		t.Errorf("Expected the finished session to be kept for the history TTL, got %v", ttl)
+	}
+	if _, err := store.SetSessionStatus("session1", string(matchmaking.StatusActive), start); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected restarting a finished session to fail, got %v", err)
+	}
+
+	history, err := store.PlayerSessions("alice", 10)
+	if err != nil || len(history) != 2 || history[0].SessionID != "session2" || history[1].SessionID != "session1" {
+		t.Errorf("Expected alice's finished sessions, newest first, got %+v, %v", history, err)
+	}
+	if history, _ := store.PlayerSessions("alice", 1); len(history) != 1 || history[0].SessionID != "session2" {
+		t.Errorf("Expected the limit to keep the newest session, got %+v", history)
+	}
+
+	// Sessions removed from the store drop out of the history
+	store.EndSession("session2")
+	if history, _ := store.PlayerSessions("carol", 10); len(history) != 0 {
+		t.Errorf("Expected the removed session to leave the history, got %+v", history)
+	}
+	if members, _ := server.ZMembers("test:history:carol"); len(members) != 0 {
+		t.Errorf("Expected the history index to be pruned, got %v", members)
+	}
+}
+
+func TestHistoryWithoutTTL(t *testing.T) {
+	store, server := newTestStore(t)
+	store.HistoryTTL = 0
+
+	store.CreateSession("session1", matchmaking.ModeMatchmaking, []string{"alice", "bob"}, time.Now())
+	store.SetSessionStatus("session1", string(matchmaking.StatusActive), time.Now())
+	if _, err := store.FinishSession("session1", message.Outcome{WinnerID: "alice"}, time.Now()); err != nil {
+		t.Fatalf("Error finishing session: %v", err)
+	}
+
+	// A zero TTL keeps the history forever, like the local store
+	server.FastForward(365 * 24 * time.Hour)
+	if history, err := store.PlayerSessions("alice", 10); err != nil || len(history) != 1 || history[0].SessionID != "session1" {
+		t.Errorf("Expected the session to stay in alice's history, got %+v, %v", history, err)
+	}
+	if ttl := server.TTL("test:history:bob"); ttl != 0 {
+		t.Errorf("Expected bob's history not to expire, got %v", ttl)
+	}
+}
+
+func TestAbandonSession(t *testing.T) {
+	store, server := newTestStore(t)
+	store.HistoryTTL = time.Hour
+
+	store.CreateSession("session1", matchmaking.ModeMatchmaking, []string{"alice", "bob"}, time.Now())
+	store.CreateSession("session2", matchmaking.ModeMatchmaking, []string{"alice", "carol"}, time.Now())
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	store.SetSessionStatus("session1", string(matchmaking.StatusActive), start)
+	if open, err := store.OpenSessions("alice"); err != nil || len(open) != 2 || open[0].Status != matchmaking.StatusActive || open[1].Status != matchmaking.StatusProposed {
+		t.Errorf("Expected both of alice's sessions open, got %+v, %v", open, err)
+	}
+
+	forfeit := &message.Outcome{WinnerID: "bob", Resolution: message.ResolutionForfeit}
+	if from, err := store.AbandonSession("session1", forfeit, start); err != nil || from != string(matchmaking.StatusActive) {
+		t.Fatalf("Expected to abandon the active session, got %q %v", from, err)
+	}
+	if _, err := store.AbandonSession("session1", forfeit, start); !errors.Is(err, db.ErrSessionFinished) {
+		t.Errorf("Expected abandoning twice to fail, got %v", err)
+	}
+	// Sessions that never started are abandoned without an outcome
+	if _, err := store.AbandonSession("session2", nil, start.Add(time.Minute)); err != nil {
+		t.Fatalf("Error abandoning proposed session: %v", err)
+	}
+
+	history, err := store.PlayerSessions("alice", 10)
+	if err != nil || len(history) != 2 || history[1].Status != matchmaking.StatusAbandoned || history[1].Outcome.WinnerID != "bob" || history[0].Outcome != nil {
+		t.Errorf("Expected both abandoned sessions in alice's history, got %+v, %v", history, err)
+	}
+	if open, _ := store.OpenSessions("alice"); len(open) != 0 {
+		t.Errorf("Expected alice to have no open session, got %+v", open)
+	}
+	if server.Exists("test:open:alice") {
+		t.Error("Expected the open sessions index to be emptied")
+	}
+}
@@ -0,0 +1,92 @@
+package redisdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Defaults for a new Store
+const (
+	DefaultSessionTTL = 24 * time.Hour
+	DefaultHistoryTTL = 30 * 24 * time.Hour
+	DefaultTimeout    = 2 * time.Second
+)
+
+// Store keeps shared state for a multi-node deployment in a server speaking
+// the Redis protocol: sessions, connection presence and leases. Every key is
+// prefixed so several deployments can share a server.
+type Store struct {
+	// SessionTTL is how long a session is kept after it is created
+	SessionTTL time.Duration
+	// HistoryTTL is how long a finished session is kept for match history,
+	// forever when zero
+	HistoryTTL time.Duration
+	// Timeout bounds the session methods, which take no context
+	Timeout time.Duration
+
+	client *redis.Client
+	prefix string
+}
+
+// NewStore creates a store using client, naming keys "<prefix>:..."
+func NewStore(client *redis.Client, prefix string) *Store {
+	return &Store{SessionTTL: DefaultSessionTTL, HistoryTTL: DefaultHistoryTTL, Timeout: DefaultTimeout, client: client, prefix: prefix}
+}
+
+func (s *Store) key(parts ...string) string {
+	key := s.prefix
+	for _, part := range parts {
+		key += ":" + part
+	}
+	return key
+}
+
+// renewLease extends the lease only if it still belongs to the caller
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLease deletes the lease only if it still belongs to the caller
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (s *Store) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	acquired, err := s.client.SetNX(ctx, s.key("lease", key), holder, ttl).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+	renewed, err := renewLease.Run(ctx, s.client, []string{s.key("lease", key)}, holder, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (s *Store) ReleaseLease(ctx context.Context, key, holder string) error {
+	return releaseLease.Run(ctx, s.client, []string{s.key("lease", key)}, holder).Err()
+}
+
+func (s *Store) LeaseHolder(ctx context.Context, key string) (string, error) {
+	holder, err := s.client.Get(ctx, s.key("lease", key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return holder, err
+}
+
+// Ping checks the server is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// Close closes the connection to the server
+func (s *Store) Close() error {
+	return s.client.Close()
+}
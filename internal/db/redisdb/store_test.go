@@ -0,0 +1,58 @@
+package redisdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	store := NewStore(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test")
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+func TestLeases(t *testing.T) {
+	ctx := context.Background()
+	store, server := newTestStore(t)
+
+	if ok, err := store.AcquireLease(ctx, "leader", "node1", time.Second); !ok || err != nil {
+		t.Fatalf("Expected node1 to acquire the free lease, got %v, %v", ok, err)
+	}
+	if ok, _ := store.AcquireLease(ctx, "leader", "node2", time.Second); ok {
+		t.Error("Expected node2 not to acquire a held lease")
+	}
+	if ok, err := store.AcquireLease(ctx, "leader", "node1", time.Second); !ok || err != nil {
+		t.Errorf("Expected node1 to renew its lease, got %v, %v", ok, err)
+	}
+	if holder, _ := store.LeaseHolder(ctx, "leader"); holder != "node1" {
+		t.Errorf("Expected node1 to hold the lease, got '%s'", holder)
+	}
+
+	// Releasing someone else's lease does nothing
+	if err := store.ReleaseLease(ctx, "leader", "node2"); err != nil {
+		t.Fatalf("Error releasing: %v", err)
+	}
+	if holder, _ := store.LeaseHolder(ctx, "leader"); holder != "node1" {
+		t.Errorf("Expected node1 to still hold the lease, got '%s'", holder)
+	}
+	store.ReleaseLease(ctx, "leader", "node1")
+	if holder, _ := store.LeaseHolder(ctx, "leader"); holder != "" {
+		t.Errorf("Expected the released lease to be free, got '%s'", holder)
+	}
+
+	// An expired lease is free for anyone
+	store.AcquireLease(ctx, "leader", "node2", time.Second)
+	server.FastForward(2 * time.Second)
+	if ok, _ := store.AcquireLease(ctx, "leader", "node1", time.Second); !ok {
+		t.Error("Expected node1 to acquire the expired lease")
+	}
+	if !server.Exists("test:lease:leader") {
+		t.Error("Expected the lease key to carry the prefix")
+	}
+}
@@ -0,0 +1,132 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/message"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS offline_messages (
+	id         TEXT PRIMARY KEY,
+	player_id  TEXT NOT NULL,
+	sender     TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	stored_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS offline_messages_player ON offline_messages (player_id, stored_at);
+CREATE TABLE IF NOT EXISTS offline_players (
+	player_id  TEXT PRIMARY KEY
+);
+`
+
+// MessageStore keeps offline messages in a SQLite database so they survive restarts
+type MessageStore struct {
+	db    *sql.DB
+	limit int
+}
+
+// NewMessageStore opens or creates the database at path, holding up to limit
+// messages per player, zero for no limit. The driver needs a cgo build.
+func NewMessageStore(path string, limit int) (*MessageStore, error) {
+	conn, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	// a single writer avoids SQLITE_BUSY between concurrent stores and acks
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(schema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+	return &MessageStore{db: conn, limit: limit}, nil
+}
+
+func (s *MessageStore) AddPlayer(playerID string) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO offline_players (player_id) VALUES (?)`, playerID)
+	return err
+}
+
+func (s *MessageStore) StoreMessage(playerID string, msg message.Message) (db.StoredMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return db.StoredMessage{}, err
+	}
+	defer tx.Rollback()
+
+	var known int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM offline_players WHERE player_id = ?`, playerID).Scan(&known); err != nil {
+		return db.StoredMessage{}, err
+	}
+	if known == 0 {
+		return db.StoredMessage{}, db.ErrUnknownPlayer
+	}
+	if s.limit > 0 {
+		var pending int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM offline_messages WHERE player_id = ?`, playerID).Scan(&pending); err != nil {
+			return db.StoredMessage{}, err
+		}
+		if pending >= s.limit {
+			return db.StoredMessage{}, db.ErrMailboxFull
+		}
+	}
+
+	stored := db.StoredMessage{ID: uuid.New().String(), PlayerID: playerID, Message: msg, StoredAt: time.Now().UTC()}
+	_, err = tx.Exec(`INSERT INTO offline_messages (id, player_id, sender, content, stored_at) VALUES (?, ?, ?, ?, ?)`,
+		stored.ID, playerID, msg.From, msg.Content, stored.StoredAt.UnixNano())
+	if err != nil {
+		return db.StoredMessage{}, err
+	}
+	return stored, tx.Commit()
+}
+
+func (s *MessageStore) PendingMessages(playerID string) ([]db.StoredMessage, error) {
+	rows, err := s.db.Query(`SELECT id, sender, content, stored_at FROM offline_messages WHERE player_id = ? ORDER BY stored_at, rowid`, playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make([]db.StoredMessage, 0)
+	for rows.Next() {
+		var storedAt int64
+		stored := db.StoredMessage{PlayerID: playerID, Message: message.Message{To: playerID}}
+		if err := rows.Scan(&stored.ID, &stored.Message.From, &stored.Message.Content, &storedAt); err != nil {
+			return nil, err
+		}
+		stored.StoredAt = time.Unix(0, storedAt).UTC()
+		pending = append(pending, stored)
+	}
+	return pending, rows.Err()
+}
+
+func (s *MessageStore) AckMessage(playerID, messageID string) error {
+	result, err := s.db.Exec(`DELETE FROM offline_messages WHERE id = ? AND player_id = ?`, messageID, playerID)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return db.ErrMessageNotFound
+	}
+	return nil
+}
+
+// Ping checks the database is reachable
+func (s *MessageStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database
+func (s *MessageStore) Close() error {
+	return s.db.Close()
+}
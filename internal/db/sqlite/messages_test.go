@@ -0,0 +1,63 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/message"
+)
+
+func TestMessageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offline.db")
+	store, err := NewMessageStore(path, 2)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+
+	if _, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "hello?"}); !errors.Is(err, db.ErrUnknownPlayer) {
+		t.Errorf("Expected ErrUnknownPlayer before alice is added, got %v", err)
+	}
+	store.AddPlayer("alice")
+
+	first, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "first"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "second"})
+
+	if _, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "third"}); !errors.Is(err, db.ErrMailboxFull) {
+		t.Errorf("Expected ErrMailboxFull, got %v", err)
+	}
+
+	pending, _ := store.PendingMessages("alice")
+	if len(pending) != 2 || pending[0].Message.Content != "first" || pending[1].Message.From != "bob" {
+		t.Fatalf("Expected both messages oldest first, got %v", pending)
+	}
+
+	if err := store.AckMessage("alice", first.ID); err != nil {
+		t.Errorf("Expected no error acknowledging, got %v", err)
+	}
+	if err := store.AckMessage("alice", first.ID); !errors.Is(err, db.ErrMessageNotFound) {
+		t.Errorf("Expected ErrMessageNotFound acknowledging twice, got %v", err)
+	}
+
+	// Pending messages survive reopening the database
+	store.Close()
+	store, err = NewMessageStore(path, 2)
+	if err != nil {
+		t.Fatalf("Error reopening store: %v", err)
+	}
+	defer store.Close()
+
+	pending, _ = store.PendingMessages("alice")
+	if len(pending) != 1 || pending[0].Message.Content != "second" || pending[0].Message.To != "alice" {
+		t.Errorf("Expected the second message to survive a restart, got %v", pending)
+	}
+	if _, err := store.StoreMessage("alice", message.Message{From: "bob", To: "alice", Content: "third"}); err != nil {
+		t.Errorf("Expected alice to stay known after a restart, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package delivery
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/metrics"
+
+	"github.com/google/uuid"
+)
+
+// Tracker resends messages until their recipient acknowledges them, giving
+// at-least-once delivery for server events a client must not miss, such as
+// session notifications. Recipients should expect duplicates and use the ID.
+type Tracker struct {
+	send        func(message.Message) error
+	interval    time.Duration
+	maxAttempts int
+	Metrics     *metrics.Metrics
+	Logger      *slog.Logger
+
+	mutex   sync.Mutex
+	pending map[string]*pending
+}
+
+// pending is a message waiting for its ack
+type pending struct {
+	msg      message.Message
+	attempts int
+	timer    *time.Timer
+}
+
+// NewTracker creates a tracker writing messages with send and resending them
+// every interval, up to maxAttempts sends in total
+func NewTracker(send func(message.Message) error, interval time.Duration, maxAttempts int) *Tracker {
+	return &Tracker{
+		send:        send,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		Logger:      slog.Default(),
+		pending:     make(map[string]*pending),
+	}
+}
+
+func (t *Tracker) logger() *slog.Logger {
+	return logging.OrDefault(t.Logger)
+}
+
+// Send gives msg an ID if it has none, sends it and keeps resending it until
+// it is acknowledged. A failed first send is retried like a missing ack.
+func (t *Tracker) Send(msg message.Message) error {
+	if msg.ID == "" {
+		msg.ID = uuid.New().String()
+	}
+
+	// Track before sending so an ack racing the send isn't missed
+	if t.maxAttempts > 1 && t.interval > 0 {
+		t.mutex.Lock()
+		t.pending[msg.ID] = &pending{
+			msg:      msg,
+			attempts: 1,
+			timer:    time.AfterFunc(t.interval, func() { t.retry(msg.ID) }),
+		}
+		t.mutex.Unlock()
+	}
+
+	return t.send(msg)
+}
+
+// Ack stops resending a message. It reports false when the message isn't
+// pending or wasn't addressed to any of the acknowledging recipient's IDs.
+func (t *Tracker) Ack(messageID string, recipientIDs ...string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	p, exists := t.pending[messageID]
+	if !exists || !addressedTo(p.msg, recipientIDs) {
+		return false
+	}
+	p.timer.Stop()
+	delete(t.pending, messageID)
+	return true
+}
+
+// Pending returns the number of messages waiting for an ack
+func (t *Tracker) Pending() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.pending)
+}
+
+// Stop cancels every pending retry
+func (t *Tracker) Stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for id, p := range t.pending {
+		p.timer.Stop()
+		delete(t.pending, id)
+	}
+}
+
+func (t *Tracker) retry(messageID string) {
+	t.mutex.Lock()
+	p, exists := t.pending[messageID]
+	if !exists {
+		t.mutex.Unlock()
+		return
+	}
+	if p.attempts >= t.maxAttempts {
+		delete(t.pending, messageID)
+		t.mutex.Unlock()
+		t.Metrics.DeliveryExpired()
+		t.logger().Warn("message never acknowledged",
+			slog.String("message_id", messageID),
+			slog.String(logging.KeyRecipient, p.msg.To),
+			slog.Int("attempts", p.attempts))
+		return
+	}
+	p.attempts++
+	p.timer = time.AfterFunc(t.interval, func() { t.retry(messageID) })
+	msg := p.msg
+	t.mutex.Unlock()
+
+	t.Metrics.DeliveryRetried()
+	if err := t.send(msg); err != nil {
+		t.logger().Debug("error resending message",
+			slog.String("message_id", messageID),
+			slog.String(logging.KeyRecipient, msg.To),
+			logging.Err(err))
+	}
+}
+
+func addressedTo(msg message.Message, recipientIDs []string) bool {
+	for _, id := range recipientIDs {
+		if id == msg.To {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package delivery
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+// recorder collects the messages a tracker sends
+type recorder struct {
+	mutex sync.Mutex
+	sent  []message.Message
+	err   error
+}
+
+func (r *recorder) send(msg message.Message) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sent = append(r.sent, msg)
+	return r.err
+}
+
+func (r *recorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.sent)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTrackerResendsUntilAcknowledged(t *testing.T) {
+	r := &recorder{}
+	tracker := NewTracker(r.send, 10*time.Millisecond, 10)
+	defer tracker.Stop()
+
+	if err := tracker.Send(message.Message{To: "alice", Content: "session"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	waitFor(t, func() bool { return r.count() >= 3 })
+
+	r.mutex.Lock()
+	id := r.sent[0].ID
+	r.mutex.Unlock()
+	if id == "" {
+		t.Fatal("Expected the tracker to assign an ID")
+	}
+	if tracker.Ack(id, "bob") {
+		t.Error("Expected an ack from another recipient to be ignored")
+	}
+	if !tracker.Ack(id, "connection1", "alice") {
+		t.Error("Expected the recipient's ack to be accepted")
+	}
+	if tracker.Pending() != 0 {
+		t.Errorf("Expected nothing pending, got %d", tracker.Pending())
+	}
+
+	sent := r.count()
+	time.Sleep(50 * time.Millisecond)
+	if r.count() != sent {
+		t.Errorf("Expected no resends after the ack, got %d more", r.count()-sent)
+	}
+}
+
+func TestTrackerGivesUpAfterMaxAttempts(t *testing.T) {
+	r := &recorder{err: errors.New("offline")}
+	tracker := NewTracker(r.send, 5*time.Millisecond, 3)
+	defer tracker.Stop()
+
+	tracker.Send(message.Message{ID: "m1", To: "alice"})
+	waitFor(t, func() bool { return tracker.Pending() == 0 })
+
+	if r.count() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", r.count())
+	}
+	if tracker.Ack("m1", "alice") {
+		t.Error("Expected an expired message to be gone")
+	}
+}
+
+func TestTrackerWithoutRetries(t *testing.T) {
+	r := &recorder{}
+	tracker := NewTracker(r.send, time.Millisecond, 1)
+
+	tracker.Send(message.Message{To: "alice"})
+	if tracker.Pending() != 0 {
+		t.Errorf("Expected nothing tracked with a single attempt, got %d", tracker.Pending())
+	}
+}
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, handler http.Handler) (int, Report) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	var report Report
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatalf("Error decoding report: %v", err)
+	}
+	return recorder.Code, report
+}
+
+func TestLivenessHandler(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.AddReadinessCheck("broken", func(ctx context.Context) error {
+		return errors.New("broken")
+	})
+
+	// Liveness ignores readiness checks
+	code, report := serve(t, checker.LivenessHandler())
+	if code != http.StatusOK || report.Status != StatusOK {
+		t.Errorf("Expected 200 ok, got %d %s", code, report.Status)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	checker := NewChecker(time.Second)
+	healthy := true
+	checker.AddReadinessCheck("matchmaking", func(ctx context.Context) error {
+		return nil
+	})
+	checker.AddReadinessCheck("session_db", func(ctx context.Context) error {
+		if !healthy {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	code, report := serve(t, checker.ReadinessHandler())
+	if code != http.StatusOK || report.Status != StatusOK {
+		t.Errorf("Expected 200 ok, got %d %s", code, report.Status)
+	}
+	if len(report.Checks) != 3 {
+		t.Errorf("Expected 3 checks, got %v", report.Checks)
+	}
+
+	// A failing check makes the service unready
+	healthy = false
+	code, report = serve(t, checker.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || report.Status != StatusUnavailable {
+		t.Errorf("Expected 503 unavailable, got %d %s", code, report.Status)
+	}
+	if report.Checks["session_db"].Error != "connection refused" {
+		t.Errorf("Expected session_db error, got %v", report.Checks["session_db"])
+	}
+	if report.Checks["matchmaking"].Status != StatusOK {
+		t.Errorf("Expected matchmaking to stay ok, got %v", report.Checks["matchmaking"])
+	}
+
+	// Draining makes the service unready even when all checks pass
+	healthy = true
+	checker.SetDraining(true)
+	code, report = serve(t, checker.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || report.Checks["draining"].Status != StatusUnavailable {
+		t.Errorf("Expected 503 while draining, got %d %v", code, report.Checks["draining"])
+	}
+}
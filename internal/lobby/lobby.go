@@ -0,0 +1,290 @@
+// Package lobby runs private lobbies that friends join with a short code.
+//
+// The player who creates a lobby hosts it: only the host kicks members,
+// resizes or locks the lobby, and starts it as a session. A player is in
+// at most one lobby. When the host leaves, the member who joined next takes
+// over, and the lobby closes when its last member leaves or it starts.
+package lobby
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+// DefaultMaxPlayers sizes lobbies created without a size
+const DefaultMaxPlayers = 2
+
+// codeAlphabet leaves out characters that are easily confused when read aloud
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	// ErrLobbyNotFound is returned for codes that match no lobby
+	ErrLobbyNotFound = errors.New("lobby not found")
+	// ErrNotInLobby is returned when a player acts on a lobby without being in one
+	ErrNotInLobby = errors.New("not in a lobby")
+	// ErrAlreadyInLobby is returned when a player in a lobby creates or joins another
+	ErrAlreadyInLobby = errors.New("already in a lobby")
+	// ErrNotHost is returned when a member who isn't the host runs the lobby
+	ErrNotHost = errors.New("only the host can do that")
+	// ErrLobbyFull is returned when joining a lobby with no free slot
+	ErrLobbyFull = errors.New("lobby is full")
+	// ErrLobbyLocked is returned when joining a locked lobby
+	ErrLobbyLocked = errors.New("lobby is locked")
+	// ErrInvalidMaxPlayers is returned for sizes below the members or above the limit
+	ErrInvalidMaxPlayers = errors.New("invalid lobby size")
+	// ErrNotEnoughPlayers is returned when starting a lobby with too few members
+	ErrNotEnoughPlayers = errors.New("not enough players to start")
+)
+
+// lobby holds the members of one lobby in join order
+type lobby struct {
+	code       string
+	hostID     string
+	maxPlayers int
+	locked     bool
+	members    []message.LobbyMember
+}
+
+// Manager tracks the lobbies of this node
+type Manager struct {
+	mutex      sync.Mutex
+	lobbies    map[string]*lobby // code -> lobby
+	players    map[string]string // player ID -> code of its lobby
+	maxPlayers int
+}
+
+// NewManager creates a lobby manager whose lobbies hold up to maxPlayers
+func NewManager(maxPlayers int) *Manager {
+	return &Manager{
+		lobbies:    make(map[string]*lobby),
+		players:    make(map[string]string),
+		maxPlayers: maxPlayers,
+	}
+}
+
+// Create opens a lobby of maxPlayers hosted by host, DefaultMaxPlayers when zero
+func (m *Manager) Create(host message.LobbyMember, maxPlayers int) (message.Lobby, error) {
+	if maxPlayers == 0 {
+		maxPlayers = DefaultMaxPlayers
+	}
+	if maxPlayers < message.MinLobbyPlayers || maxPlayers > m.maxPlayers {
+		return message.Lobby{}, ErrInvalidMaxPlayers
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.players[host.PlayerID]; exists {
+		return message.Lobby{}, ErrAlreadyInLobby
+	}
+	code, err := m.newCode()
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	l := &lobby{code: code, hostID: host.PlayerID, maxPlayers: maxPlayers, members: []message.LobbyMember{host}}
+	m.lobbies[code] = l
+	m.players[host.PlayerID] = code
+	return l.view(), nil
+}
+
+// Join adds member to the lobby with code, which is not case sensitive.
+// Joining the lobby the player is already in returns it unchanged.
+func (m *Manager) Join(code string, member message.LobbyMember) (message.Lobby, error) {
+	code = strings.ToUpper(code)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, exists := m.lobbies[code]
+	if !exists {
+		return message.Lobby{}, ErrLobbyNotFound
+	}
+	if current, in := m.players[member.PlayerID]; in {
+		if current == code {
+			return l.view(), nil
+		}
+		return message.Lobby{}, ErrAlreadyInLobby
+	}
+	if l.locked {
+		return message.Lobby{}, ErrLobbyLocked
+	}
+	if len(l.members) >= l.maxPlayers {
+		return message.Lobby{}, ErrLobbyFull
+	}
+	l.members = append(l.members, member)
+	m.players[member.PlayerID] = code
+	return l.view(), nil
+}
+
+// Leave takes a player out of its lobby and returns the lobby as it is
+// now, without members when it closed
+func (m *Manager) Leave(playerID string) (message.Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, err := m.lobbyOf(playerID)
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	m.remove(l, playerID)
+	return l.view(), nil
+}
+
+// Kick makes the host remove a member from its lobby and returns the lobby as it is now
+func (m *Manager) Kick(hostID, playerID string) (message.Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, err := m.hostedBy(hostID)
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	if playerID == hostID || m.players[playerID] != l.code {
+		return message.Lobby{}, ErrNotInLobby
+	}
+	m.remove(l, playerID)
+	return l.view(), nil
+}
+
+// SetMaxPlayers makes the host resize its lobby, to no fewer than its
+// members, and returns the lobby as it is now
+func (m *Manager) SetMaxPlayers(hostID string, maxPlayers int) (message.Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, err := m.hostedBy(hostID)
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	if maxPlayers < message.MinLobbyPlayers || maxPlayers < len(l.members) || maxPlayers > m.maxPlayers {
+		return message.Lobby{}, ErrInvalidMaxPlayers
+	}
+	l.maxPlayers = maxPlayers
+	return l.view(), nil
+}
+
+// SetLocked makes the host lock or unlock its lobby to new members and
+// returns the lobby as it is now
+func (m *Manager) SetLocked(hostID string, locked bool) (message.Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, err := m.hostedBy(hostID)
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	l.locked = locked
+	return l.view(), nil
+}
+
+// Start makes the host start its lobby. create is called with the lobby,
+// under the manager's lock so that nobody joins or leaves meanwhile, and
+// creates the session. The lobby closes once create succeeds.
+func (m *Manager) Start(hostID string, create func(message.Lobby) error) (message.Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, err := m.hostedBy(hostID)
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	if len(l.members) < message.MinLobbyPlayers {
+		return message.Lobby{}, ErrNotEnoughPlayers
+	}
+	started := l.view()
+	if err := create(started); err != nil {
+		return message.Lobby{}, err
+	}
+	for _, member := range l.members {
+		delete(m.players, member.PlayerID)
+	}
+	delete(m.lobbies, l.code)
+	return started, nil
+}
+
+// Get returns the lobby a player is in
+func (m *Manager) Get(playerID string) (message.Lobby, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	l, err := m.lobbyOf(playerID)
+	if err != nil {
+		return message.Lobby{}, err
+	}
+	return l.view(), nil
+}
+
+// lobbyOf returns the lobby a player is in; the caller holds the mutex
+func (m *Manager) lobbyOf(playerID string) (*lobby, error) {
+	code, exists := m.players[playerID]
+	if !exists {
+		return nil, ErrNotInLobby
+	}
+	return m.lobbies[code], nil
+}
+
+// hostedBy returns the lobby a player hosts; the caller holds the mutex
+func (m *Manager) hostedBy(playerID string) (*lobby, error) {
+	l, err := m.lobbyOf(playerID)
+	if err != nil {
+		return nil, err
+	}
+	if l.hostID != playerID {
+		return nil, ErrNotHost
+	}
+	return l, nil
+}
+
+// remove takes a member out of a lobby, handing the lobby to the next
+// member when the host leaves and closing it when nobody is left; the
+// caller holds the mutex
+func (m *Manager) remove(l *lobby, playerID string) {
+	delete(m.players, playerID)
+	for i, member := range l.members {
+		if member.PlayerID == playerID {
+			l.members = append(l.members[:i], l.members[i+1:]...)
+			break
+		}
+	}
+	if len(l.members) == 0 {
+		delete(m.lobbies, l.code)
+		return
+	}
+	if l.hostID == playerID {
+		l.hostID = l.members[0].PlayerID
+	}
+}
+
+// newCode returns a code no open lobby uses; the caller holds the mutex
+func (m *Manager) newCode() (string, error) {
+	limit := big.NewInt(int64(len(codeAlphabet)))
+	for {
+		code := make([]byte, message.LobbyCodeLength)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, limit)
+			if err != nil {
+				return "", err
+			}
+			code[i] = codeAlphabet[n.Int64()]
+		}
+		if _, taken := m.lobbies[string(code)]; !taken {
+			return string(code), nil
+		}
+	}
+}
+
+// view returns the state of a lobby
+func (l *lobby) view() message.Lobby {
+	members := make([]message.LobbyMember, len(l.members))
+	copy(members, l.members)
+	return message.Lobby{
+		Code:       l.code,
+		HostID:     l.hostID,
+		MaxPlayers: l.maxPlayers,
+		Locked:     l.locked,
+		Members:    members,
+	}
+}
@@ -0,0 +1,124 @@
+package lobby
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+func member(id string) message.LobbyMember {
+	return message.LobbyMember{PlayerID: id}
+}
+
+func TestCreateAndJoin(t *testing.T) {
+	m := NewManager(4)
+
+	if _, err := m.Create(member("alice"), 5); !errors.Is(err, ErrInvalidMaxPlayers) {
+		t.Errorf("Expected ErrInvalidMaxPlayers above the limit, got %v", err)
+	}
+	l, err := m.Create(member("alice"), 0)
+	if err != nil || len(l.Code) != message.LobbyCodeLength || l.HostID != "alice" || l.MaxPlayers != DefaultMaxPlayers {
+		t.Fatalf("Unexpected lobby %+v %v", l, err)
+	}
+	if _, err := m.Create(member("alice"), 0); !errors.Is(err, ErrAlreadyInLobby) {
+		t.Errorf("Expected ErrAlreadyInLobby creating a second lobby, got %v", err)
+	}
+
+	if _, err := m.Join("ZZZZZZ", member("bob")); !errors.Is(err, ErrLobbyNotFound) {
+		t.Errorf("Expected ErrLobbyNotFound, got %v", err)
+	}
+	// Codes aren't case sensitive
+	l, err = m.Join(strings.ToLower(l.Code), member("bob"))
+	if err != nil || len(l.Members) != 2 || l.Members[1].PlayerID != "bob" {
+		t.Fatalf("Expected bob to join, got %+v %v", l, err)
+	}
+	if again, err := m.Join(l.Code, member("bob")); err != nil || len(again.Members) != 2 {
+		t.Errorf("Expected joining twice to change nothing, got %+v %v", again, err)
+	}
+	if _, err := m.Join(l.Code, member("carol")); !errors.Is(err, ErrLobbyFull) {
+		t.Errorf("Expected ErrLobbyFull, got %v", err)
+	}
+}
+
+func TestHostControls(t *testing.T) {
+	m := NewManager(4)
+	l, _ := m.Create(member("alice"), 3)
+	m.Join(l.Code, member("bob"))
+
+	if _, err := m.SetLocked("bob", true); !errors.Is(err, ErrNotHost) {
+		t.Errorf("Expected ErrNotHost, got %v", err)
+	}
+	if _, err := m.SetMaxPlayers("alice", 5); !errors.Is(err, ErrInvalidMaxPlayers) {
+		t.Errorf("Expected ErrInvalidMaxPlayers above the limit, got %v", err)
+	}
+	if l, err := m.SetMaxPlayers("alice", 4); err != nil || l.MaxPlayers != 4 {
+		t.Errorf("Expected the lobby to hold 4, got %+v %v", l, err)
+	}
+
+	if l, err := m.SetLocked("alice", true); err != nil || !l.Locked {
+		t.Errorf("Expected the lobby to be locked, got %+v %v", l, err)
+	}
+	if _, err := m.Join(l.Code, member("carol")); !errors.Is(err, ErrLobbyLocked) {
+		t.Errorf("Expected ErrLobbyLocked, got %v", err)
+	}
+	m.SetLocked("alice", false)
+	m.Join(l.Code, member("carol"))
+
+	if _, err := m.Kick("alice", "dave"); !errors.Is(err, ErrNotInLobby) {
+		t.Errorf("Expected ErrNotInLobby kicking a stranger, got %v", err)
+	}
+	l, err := m.Kick("alice", "bob")
+	if err != nil || len(l.Members) != 2 {
+		t.Fatalf("Expected bob to be kicked, got %+v %v", l, err)
+	}
+	if _, err := m.Get("bob"); !errors.Is(err, ErrNotInLobby) {
+		t.Errorf("Expected bob out of the lobby, got %v", err)
+	}
+
+	// The host leaving hands the lobby over; the last member leaving closes it
+	if l, _ := m.Leave("alice"); l.HostID != "carol" {
+		t.Errorf("Expected carol to host, got %+v", l)
+	}
+	if l, _ := m.Leave("carol"); len(l.Members) != 0 {
+		t.Errorf("Expected the lobby to close, got %+v", l)
+	}
+	if _, err := m.Join(l.Code, member("bob")); !errors.Is(err, ErrLobbyNotFound) {
+		t.Errorf("Expected the closed lobby to be gone, got %v", err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	m := NewManager(4)
+	l, _ := m.Create(member("alice"), 2)
+
+	create := func(message.Lobby) error { return nil }
+	if _, err := m.Start("alice", create); !errors.Is(err, ErrNotEnoughPlayers) {
+		t.Errorf("Expected ErrNotEnoughPlayers, got %v", err)
+	}
+	m.Join(l.Code, member("bob"))
+	if _, err := m.Start("bob", create); !errors.Is(err, ErrNotHost) {
+		t.Errorf("Expected ErrNotHost, got %v", err)
+	}
+
+	// A failed start keeps the lobby open
+	failure := errors.New("store down")
+	if _, err := m.Start("alice", func(message.Lobby) error { return failure }); !errors.Is(err, failure) {
+		t.Errorf("Expected the creation error, got %v", err)
+	}
+	if _, err := m.Get("bob"); err != nil {
+		t.Errorf("Expected bob still in the lobby, got %v", err)
+	}
+
+	started, err := m.Start("alice", create)
+	if err != nil || len(started.Members) != 2 || started.Members[0].PlayerID != "alice" {
+		t.Fatalf("Unexpected started lobby %+v %v", started, err)
+	}
+	if _, err := m.Get("alice"); !errors.Is(err, ErrNotInLobby) {
+		t.Errorf("Expected the started lobby to close, got %v", err)
+	}
+	if _, err := m.Create(member("bob"), 0); err != nil {
+		t.Errorf("Expected bob free to create a lobby, got %v", err)
+	}
+}
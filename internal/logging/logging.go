@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Field names shared by every log record
+const (
+	KeyClientID    = "client_id"
+	KeyPlayerID    = "player_id"
+	KeySessionID   = "session_id"
+	KeyRoom        = "room"
+	KeyLobby       = "lobby"
+	KeyIP          = "ip"
+	KeyMessageType = "message_type"
+	KeyRecipient   = "recipient"
+	KeyContent     = "content"
+	KeyError       = "error"
+)
+
+// New creates a logger writing to w. Level is one of debug, info, warn or
+// error; format is text or json.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: slogLevel}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// Err returns the error attribute used across the service
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// OrDefault returns logger, or the process default logger when it is nil
+func OrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
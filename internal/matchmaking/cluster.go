@@ -0,0 +1,352 @@
+package matchmaking
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simple-multiplayer-service/internal/backplane"
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+)
+
+// LeaderLeaseKey names the lease held by the node running matchmaking
+const LeaderLeaseKey = "matchmaking-leader"
+
+// publishTimeout bounds how long a request waits on the backplane
+const publishTimeout = 2 * time.Second
+
+// Cluster runs matchmaking across the nodes of a deployment. Every node
+// takes requests from its own clients, but only the node holding the leader
+// lease matches them; the others forward their requests to it over the
+// backplane. Each node remembers the requests it forwarded until they are
+// matched and sends them again when the leader changes, so the queue
+// survives the leader going away.
+type Cluster struct {
+	NodeID string
+	// Intake receives this node's requests and disconnects and delivers the
+	// notifications for sessions this node owns. It is never started.
+	Intake *Service
+	// Engine matches players while this node is the leader
+	Engine    *Service
+	Backplane backplane.Backplane
+	Leases    db.Leases
+	LeaseTTL  time.Duration
+	Logger    *slog.Logger
+	running   atomic.Bool
+
+	mutex       sync.Mutex
+	leader      string                                // leaseholder when last checked
+	outstanding map[string]message.MatchmakingRequest // player ID -> request from this node waiting for a match
+	origins     map[string]string                     // player ID -> node the request came from, while leading
+	stopLeading func()                                // stops the engine, nil unless leading
+}
+
+// NewCluster creates the matchmaking of node nodeID. Requests arrive on
+// intake's queue; the engine matching them shares intake's session limit,
+// session DB and lifecycle, metrics and logger.
+func NewCluster(nodeID string, intake *Service, bp backplane.Backplane, leases db.Leases, leaseTTL time.Duration) *Cluster {
+	engine := NewMatchmakingService(intake.SessionLimit, intake.SessionDB, notification.NewNotificationService())
+	engine.Sessions = intake.Sessions
+	engine.Metrics = intake.Metrics
+	engine.Logger = intake.Logger
+	return &Cluster{
+		NodeID:      nodeID,
+		Intake:      intake,
+		Engine:      engine,
+		Backplane:   bp,
+		Leases:      leases,
+		LeaseTTL:    leaseTTL,
+		Logger:      intake.Logger,
+		outstanding: make(map[string]message.MatchmakingRequest),
+	}
+}
+
+func (c *Cluster) logger() *slog.Logger {
+	return logging.OrDefault(c.Logger)
+}
+
+// Running reports whether the node is taking matchmaking requests
+func (c *Cluster) Running() bool {
+	return c.running.Load()
+}
+
+// Leader returns the node matching players, empty while none is elected
+func (c *Cluster) Leader() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.leader
+}
+
+// Leading reports whether this node is matching players
+func (c *Cluster) Leading() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stopLeading != nil
+}
+
+// Run takes part in leader election and routes this node's requests until
+// ctx is done, then gives up the lease so another node can take over
+func (c *Cluster) Run(ctx context.Context) error {
+	if err := c.Backplane.Subscribe(ctx, c.NodeID, c.handleEnvelope); err != nil {
+		return err
+	}
+	c.running.Store(true)
+	defer c.running.Store(false)
+
+	c.elect(ctx)
+	ticker := time.NewTicker(c.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.resign()
+			return nil
+		case <-ticker.C:
+			c.elect(ctx)
+		case mmRequest := <-c.Intake.SessionQueue:
+			c.mutex.Lock()
+			c.outstanding[mmRequest.PlayerID] = mmRequest
+			leader := c.leader
+			c.mutex.Unlock()
+			c.forward(leader, mmRequest)
+		case playerID := <-c.Intake.ClientDisconnects:
+			c.mutex.Lock()
+			delete(c.outstanding, playerID)
+			leader := c.leader
+			c.mutex.Unlock()
+			c.leave(leader, playerID)
+		}
+	}
+}
+
+// elect takes or renews the lease, starting or stopping the engine to match,
+// and resends this node's requests when the leader changed
+func (c *Cluster) elect(ctx context.Context) {
+	leaseCtx, cancel := context.WithTimeout(ctx, c.LeaseTTL/3)
+	defer cancel()
+
+	leading, err := c.Leases.AcquireLease(leaseCtx, LeaderLeaseKey, c.NodeID, c.LeaseTTL)
+	if err != nil {
+		// Without a renewed lease another node may take over, so stop matching
+		c.logger().Warn("error acquiring matchmaking lease", logging.Err(err))
+		c.demote()
+		return
+	}
+	leader := c.NodeID
+	if leading {
+		c.lead(ctx)
+	} else {
+		c.demote()
+		leader, err = c.Leases.LeaseHolder(leaseCtx, LeaderLeaseKey)
+		if err != nil {
+			c.logger().Warn("error looking up matchmaking leader", logging.Err(err))
+			return
+		}
+	}
+
+	c.mutex.Lock()
+	changed := leader != c.leader
+	c.leader = leader
+	outstanding := make([]message.MatchmakingRequest, 0, len(c.outstanding))
+	for _, mmRequest := range c.outstanding {
+		outstanding = append(outstanding, mmRequest)
+	}
+	c.mutex.Unlock()
+	if !changed || leader == "" {
+		return
+	}
+
+	c.logger().Info("matchmaking leader changed", slog.String("leader", leader), slog.Int("resubmitted", len(outstanding)))
+	for _, mmRequest := range outstanding {
+		c.forward(leader, mmRequest)
+	}
+}
+
+// lead starts the engine unless it is already running
+func (c *Cluster) lead(ctx context.Context) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopLeading != nil {
+		return
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.Engine.Run(leaderCtx)
+	}()
+	go func() {
+		defer wg.Done()
+		c.announceSessions(leaderCtx)
+	}()
+	c.origins = make(map[string]string)
+	c.stopLeading = func() {
+		cancel()
+		wg.Wait()
+	}
+	c.logger().Info("leading matchmaking")
+}
+
+// demote stops the engine and forgets its queue, which the other nodes resend to the next leader
+func (c *Cluster) demote() {
+	c.mutex.Lock()
+	stop := c.stopLeading
+	c.stopLeading = nil
+	c.origins = nil
+	c.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+
+	stop()
+	c.Engine.Drain()
+	c.logger().Info("stopped leading matchmaking")
+}
+
+// resign stops leading and releases the lease so another node can take over at once
+func (c *Cluster) resign() {
+	if !c.Leading() {
+		return
+	}
+	c.demote()
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := c.Leases.ReleaseLease(ctx, LeaderLeaseKey, c.NodeID); err != nil {
+		c.logger().Warn("error releasing matchmaking lease", logging.Err(err))
+	}
+}
+
+// forward passes a request to the leader. Without a leader it waits in
+// outstanding until one is elected.
+func (c *Cluster) forward(leader string, mmRequest message.MatchmakingRequest) {
+	switch leader {
+	case "":
+	case c.NodeID:
+		c.enqueue(c.NodeID, mmRequest)
+	default:
+		c.publishToNode(leader, backplane.Envelope{Kind: backplane.KindMatchmaking, Matchmaking: &mmRequest})
+	}
+}
+
+// leave takes a disconnected player out of the leader's queue
+func (c *Cluster) leave(leader, playerID string) {
+	switch leader {
+	case "":
+	case c.NodeID:
+		c.dequeue(playerID)
+	default:
+		c.publishToNode(leader, backplane.Envelope{Kind: backplane.KindMatchmakingLeave, PlayerID: playerID})
+	}
+}
+
+// enqueue queues a request from node origin for the engine, if this node leads
+func (c *Cluster) enqueue(origin string, mmRequest message.MatchmakingRequest) {
+	c.mutex.Lock()
+	if c.stopLeading == nil {
+		c.mutex.Unlock()
+		c.logger().Debug("dropping matchmaking request, not the leader", slog.String(logging.KeyPlayerID, mmRequest.PlayerID), slog.String("origin", origin))
+		return
+	}
+	c.origins[mmRequest.PlayerID] = origin
+	c.mutex.Unlock()
+
+	select {
+	case c.Engine.SessionQueue <- mmRequest:
+	default:
+		c.logger().Warn("matchmaking queue is full, dropping request", slog.String(logging.KeyPlayerID, mmRequest.PlayerID), slog.String("origin", origin))
+	}
+}
+
+// dequeue takes a player out of the engine's queue, if this node leads
+func (c *Cluster) dequeue(playerID string) {
+	c.mutex.Lock()
+	leading := c.stopLeading != nil
+	delete(c.origins, playerID)
+	c.mutex.Unlock()
+	if !leading {
+		return
+	}
+
+	select {
+	case c.Engine.ClientDisconnects <- playerID:
+	default:
+		c.logger().Warn("matchmaking disconnect queue is full", slog.String(logging.KeyPlayerID, playerID))
+	}
+}
+
+// announceSessions tells every node about the engine's matches until ctx is
+// done. The node player 1 asked from delivers the notification.
+func (c *Cluster) announceSessions(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case session := <-c.Engine.NotificationService.Channel:
+			c.mutex.Lock()
+			owner := c.origins[session.Player1ID]
+			if owner == "" {
+				owner = c.origins[session.Player2ID]
+			}
+			delete(c.origins, session.Player1ID)
+			delete(c.origins, session.Player2ID)
+			c.mutex.Unlock()
+			if owner == "" {
+				owner = c.NodeID
+			}
+
+			env := backplane.Envelope{Kind: backplane.KindSessionCreated, Node: owner, Session: &session}
+			env.Origin = c.NodeID
+			publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+			err := c.Backplane.Publish(publishCtx, env)
+			cancel()
+			if err != nil {
+				c.logger().Error("error announcing session", slog.String(logging.KeySessionID, session.SessionID), logging.Err(err))
+			}
+		}
+	}
+}
+
+func (c *Cluster) handleEnvelope(env backplane.Envelope) {
+	switch env.Kind {
+	case backplane.KindMatchmaking:
+		if env.Matchmaking != nil {
+			c.enqueue(env.Origin, *env.Matchmaking)
+		}
+	case backplane.KindMatchmakingLeave:
+		c.dequeue(env.PlayerID)
+	case backplane.KindSessionCreated:
+		if env.Session == nil {
+			return
+		}
+		session := *env.Session
+		c.mutex.Lock()
+		delete(c.outstanding, session.Player1ID)
+		delete(c.outstanding, session.Player2ID)
+		c.mutex.Unlock()
+		if env.Node != c.NodeID {
+			return
+		}
+		// The creation time doesn't cross the backplane
+		if session.CreatedAt.IsZero() {
+			session.CreatedAt = time.Now()
+		}
+		c.Intake.NotificationService.Channel <- session
+	}
+}
+
+// publishToNode sends env to a single node, stamped with this node's ID
+func (c *Cluster) publishToNode(nodeID string, env backplane.Envelope) {
+	env.Origin = c.NodeID
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := c.Backplane.PublishToNode(ctx, nodeID, env); err != nil {
+		c.logger().Warn("error sending to matchmaking leader", slog.String("leader", nodeID), slog.String("kind", env.Kind), logging.Err(err))
+	}
+}
@@ -0,0 +1,178 @@
+package matchmaking
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/backplane"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+)
+
+// testLeases is a db.Leases whose leases never expire on their own
+type testLeases struct {
+	mutex   sync.Mutex
+	holders map[string]string
+}
+
+func (l *testLeases) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if current := l.holders[key]; current != "" && current != holder {
+		return false, nil
+	}
+	l.holders[key] = holder
+	return true, nil
+}
+
+func (l *testLeases) ReleaseLease(ctx context.Context, key, holder string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.holders[key] == holder {
+		delete(l.holders, key)
+	}
+	return nil
+}
+
+func (l *testLeases) LeaseHolder(ctx context.Context, key string) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.holders[key], nil
+}
+
+// startClusterNode runs the matchmaking of one node until the returned cancel is called
+func startClusterNode(t *testing.T, bp backplane.Backplane, leases *testLeases, nodeID string) (*Cluster, context.CancelFunc) {
+	t.Helper()
+	intake := NewMatchmakingService(10, &MockSessionDB{}, notification.NewNotificationService())
+	cluster := NewCluster(nodeID, intake, bp, leases, 30*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := cluster.Run(ctx); err != nil {
+			t.Errorf("Error running %s: %v", nodeID, err)
+		}
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+
+	waitFor(t, "node to start", cluster.Running)
+	return cluster, stop
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receiveSession returns the first session notification delivered by any of the nodes
+func receiveSession(t *testing.T, nodes ...*Cluster) (notification.SessionNotification, *Cluster) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		for _, node := range nodes {
+			select {
+			case session := <-node.Intake.NotificationService.Channel:
+				return session, node
+			default:
+			}
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected a session notification")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestClusterMatchesAcrossNodes(t *testing.T) {
+	bp := backplane.NewMemory()
+	leases := &testLeases{holders: make(map[string]string)}
+
+	node1, _ := startClusterNode(t, bp, leases, "node1")
+	waitFor(t, "node1 to lead", node1.Leading)
+	node2, _ := startClusterNode(t, bp, leases, "node2")
+	waitFor(t, "node2 to find the leader", func() bool { return node2.Leader() == "node1" })
+	if node2.Leading() {
+		t.Fatal("Expected only node1 to lead")
+	}
+
+	// node2 forwards its player to the leader, which matches them with node1's player
+	node2.Intake.SessionQueue <- message.MatchmakingRequest{PlayerID: "player2", ConnectionID: "conn2"}
+	waitFor(t, "player2 to wait on the leader", func() bool { return len(node1.Engine.Waiting()) == 1 })
+	node1.Intake.SessionQueue <- message.MatchmakingRequest{PlayerID: "player1", ConnectionID: "conn1"}
+
+	// The match is delivered by the node of player 1, the player completing it
+	session, owner := receiveSession(t, node1, node2)
+	if session.Player1ID != "player1" || session.Player2ID != "player2" {
+		t.Errorf("Unexpected session %+v", session)
+	}
+	if owner != node1 {
+		t.Errorf("Expected node1 to deliver the notification, got %s", owner.NodeID)
+	}
+	if session.CreatedAt.IsZero() {
+		t.Error("Expected the delivered notification to have a creation time")
+	}
+	waitFor(t, "node2 to forget the matched request", func() bool {
+		node2.mutex.Lock()
+		defer node2.mutex.Unlock()
+		return len(node2.outstanding) == 0
+	})
+}
+
+func TestClusterFailoverRestoresQueue(t *testing.T) {
+	bp := backplane.NewMemory()
+	leases := &testLeases{holders: make(map[string]string)}
+
+	node1, stopNode1 := startClusterNode(t, bp, leases, "node1")
+	waitFor(t, "node1 to lead", node1.Leading)
+	node2, _ := startClusterNode(t, bp, leases, "node2")
+	waitFor(t, "node2 to find the leader", func() bool { return node2.Leader() == "node1" })
+
+	node2.Intake.SessionQueue <- message.MatchmakingRequest{PlayerID: "player2", ConnectionID: "conn2"}
+	waitFor(t, "player2 to wait on the leader", func() bool { return len(node1.Engine.Waiting()) == 1 })
+
+	// The leader goes away with player2 in its queue
+	stopNode1()
+	if waiting := node1.Engine.Waiting(); len(waiting) != 0 {
+		t.Errorf("Expected the old leader to drop its queue, got %v", waiting)
+	}
+
+	// node2 takes over and queues player2 again
+	waitFor(t, "node2 to lead", node2.Leading)
+	waitFor(t, "player2 to wait on the new leader", func() bool { return len(node2.Engine.Waiting()) == 1 })
+
+	node2.Intake.SessionQueue <- message.MatchmakingRequest{PlayerID: "player3", ConnectionID: "conn3"}
+	session, _ := receiveSession(t, node2)
+	if session.Player1ID != "player3" || session.Player2ID != "player2" {
+		t.Errorf("Unexpected session %+v", session)
+	}
+}
+
+func TestClusterForwardsDisconnects(t *testing.T) {
+	bp := backplane.NewMemory()
+	leases := &testLeases{holders: make(map[string]string)}
+
+	node1, _ := startClusterNode(t, bp, leases, "node1")
+	waitFor(t, "node1 to lead", node1.Leading)
+	node2, _ := startClusterNode(t, bp, leases, "node2")
+	waitFor(t, "node2 to find the leader", func() bool { return node2.Leader() == "node1" })
+
+	node2.Intake.SessionQueue <- message.MatchmakingRequest{PlayerID: "player2", ConnectionID: "conn2"}
+	waitFor(t, "player2 to wait on the leader", func() bool { return len(node1.Engine.Waiting()) == 1 })
+
+	node2.Intake.ClientDisconnects <- "player2"
+	waitFor(t, "player2 to leave the leader's queue", func() bool { return len(node1.Engine.Waiting()) == 0 })
+}
@@ -0,0 +1,123 @@
+package matchmaking
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/metrics"
+)
+
+// SessionStore keeps sessions and moves them between statuses
+type SessionStore interface {
+	db.Session
+	GetSession(sessionID string) (Session, error)
+}
+
+// SessionEvent is emitted whenever a session changes status. From is empty
+// when the session was created.
+type SessionEvent struct {
+	Session Session
+	From    Status
+	To      Status
+	At      time.Time
+}
+
+// Lifecycle creates sessions and moves them between statuses in the store,
+// emitting a SessionEvent to its subscribers for every transition
+type Lifecycle struct {
+	Store   SessionStore
+	Metrics *metrics.Metrics
+	Logger  *slog.Logger
+
+	mutex     sync.RWMutex
+	listeners []func(SessionEvent)
+}
+
+// NewLifecycle creates a lifecycle keeping sessions in store
+func NewLifecycle(store SessionStore) *Lifecycle {
+	return &Lifecycle{Store: store, Logger: slog.Default()}
+}
+
+func (l *Lifecycle) logger() *slog.Logger {
+	return logging.OrDefault(l.Logger)
+}
+
+// Subscribe calls listener with every later transition. Listeners run on
+// the goroutine making the transition and must not block.
+func (l *Lifecycle) Subscribe(listener func(SessionEvent)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+// Create stores a proposed session of mode with playerIDs in slot order
+func (l *Lifecycle) Create(sessionID, mode string, playerIDs []string) (Session, error) {
+	createdAt := time.Now().UTC()
+	if err := l.Store.CreateSession(sessionID, mode, playerIDs, createdAt); err != nil {
+		return Session{}, err
+	}
+	session := NewSession(sessionID, mode, playerIDs, createdAt)
+	l.emit(SessionEvent{Session: session, To: StatusProposed, At: createdAt})
+	return session, nil
+}
+
+// Transition moves a session to status to and returns it as it is now
+func (l *Lifecycle) Transition(sessionID string, to Status) (Session, error) {
+	at := time.Now().UTC()
+	from, err := l.Store.SetSessionStatus(sessionID, string(to), at)
+	if err != nil {
+		return Session{}, err
+	}
+	return l.transitioned(sessionID, Status(from), to, at)
+}
+
+// Finish records the outcome of a session and returns it as it is now
+func (l *Lifecycle) Finish(sessionID string, outcome message.Outcome) (Session, error) {
+	at := time.Now().UTC()
+	from, err := l.Store.FinishSession(sessionID, outcome, at)
+	if err != nil {
+		return Session{}, err
+	}
+	return l.transitioned(sessionID, Status(from), StatusFinished, at)
+}
+
+// Abandon marks a session abandoned, with outcome when a player forfeited,
+// and returns it as it is now
+func (l *Lifecycle) Abandon(sessionID string, outcome *message.Outcome) (Session, error) {
+	at := time.Now().UTC()
+	from, err := l.Store.AbandonSession(sessionID, outcome, at)
+	if err != nil {
+		return Session{}, err
+	}
+	return l.transitioned(sessionID, Status(from), StatusAbandoned, at)
+}
+
+// transitioned reads back a session that moved and emits its event
+func (l *Lifecycle) transitioned(sessionID string, from, to Status, at time.Time) (Session, error) {
+	session, err := l.Store.GetSession(sessionID)
+	if err != nil {
+		return Session{}, fmt.Errorf("reading session %s after moving it to %s: %w", sessionID, to, err)
+	}
+	l.emit(SessionEvent{Session: session, From: from, To: to, At: at})
+	return session, nil
+}
+
+func (l *Lifecycle) emit(event SessionEvent) {
+	l.Metrics.SessionTransition(string(event.From), string(event.To))
+	l.logger().Info("session status changed",
+		slog.String(logging.KeySessionID, event.Session.SessionID),
+		slog.String("from", string(event.From)),
+		slog.String("to", string(event.To)))
+
+	l.mutex.RLock()
+	listeners := l.listeners
+	l.mutex.RUnlock()
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
@@ -0,0 +1,99 @@
+package matchmaking
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/message"
+)
+
+func TestStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		legal    bool
+	}{
+		{StatusProposed, StatusActive, true},
+		{StatusProposed, StatusAbandoned, true},
+		{StatusProposed, StatusFinished, false},
+		{StatusProposed, StatusPaused, false},
+		{StatusActive, StatusPaused, true},
+		{StatusActive, StatusFinished, true},
+		{StatusActive, StatusAbandoned, true},
+		{StatusActive, StatusProposed, false},
+		{StatusPaused, StatusActive, true},
+		{StatusPaused, StatusFinished, true},
+		{StatusPaused, StatusAbandoned, true},
+		{StatusFinished, StatusActive, false},
+		{StatusAbandoned, StatusFinished, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanBecome(tt.to); got != tt.legal {
+			t.Errorf("Expected %s -> %s legal=%v, got %v", tt.from, tt.to, tt.legal, got)
+		}
+	}
+}
+
+func TestSessionTransition(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	session := NewSession("session1", ModeMatchmaking, []string{"alice", "bob", "carol"}, createdAt)
+	if session.Status != StatusProposed || session.Player1ID != "alice" || session.Player2ID != "bob" || !session.HasPlayer("carol") {
+		t.Fatalf("Unexpected new session %+v", session)
+	}
+
+	startedAt := createdAt.Add(time.Second)
+	if from, err := session.Transition(StatusActive, startedAt); err != nil || from != StatusProposed {
+		t.Fatalf("Expected to start the session, got %s %v", from, err)
+	}
+	session.Transition(StatusPaused, startedAt.Add(time.Second))
+	session.Transition(StatusActive, startedAt.Add(2*time.Second))
+	if !session.StartedAt.Equal(startedAt) {
+		t.Errorf("Expected resuming to keep the start time, got %v", session.StartedAt)
+	}
+	if session.Finished() {
+		t.Error("Expected an active session not to be over")
+	}
+
+	endedAt := startedAt.Add(time.Minute)
+	session.Transition(StatusAbandoned, endedAt)
+	if !session.Finished() || !session.EndedAt.Equal(endedAt) {
+		t.Errorf("Expected the session to be over at %v, got %v", endedAt, session.EndedAt)
+	}
+	if _, err := session.Transition(StatusActive, endedAt); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected ErrIllegalTransition, got %v", err)
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	store := &MockSessionDB{}
+	lifecycle := NewLifecycle(store)
+	var events []SessionEvent
+	lifecycle.Subscribe(func(event SessionEvent) { events = append(events, event) })
+
+	if _, err := lifecycle.Create("session1", ModeMatchmaking, []string{"alice", "bob"}); err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	if _, err := lifecycle.Transition("session1", StatusPaused); !errors.Is(err, db.ErrIllegalTransition) {
+		t.Errorf("Expected pausing a proposed session to be refused, got %v", err)
+	}
+	lifecycle.Transition("session1", StatusActive)
+	session, err := lifecycle.Finish("session1", message.Outcome{WinnerID: "bob"})
+	if err != nil || session.Status != StatusFinished || session.Outcome.WinnerID != "bob" {
+		t.Fatalf("Unexpected finished session %+v %v", session, err)
+	}
+
+	want := []struct{ from, to Status }{
+		{"", StatusProposed},
+		{StatusProposed, StatusActive},
+		{StatusActive, StatusFinished},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		if events[i].From != w.from || events[i].To != w.to || events[i].Session.SessionID != "session1" || events[i].At.IsZero() {
+			t.Errorf("Expected event %d to move %q to %q, got %+v", i, w.from, w.to, events[i])
+		}
+	}
+}
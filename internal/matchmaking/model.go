@@ -1,7 +1,136 @@
 package matchmaking
 
+import (
+	"fmt"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/message"
+)
+
+// Status is where a session is in its lifecycle
+type Status string
+
+const (
+	// StatusProposed sessions have matched players who haven't been told yet
+	StatusProposed Status = "proposed"
+	// StatusActive sessions are being played
+	StatusActive Status = "active"
+	// StatusPaused sessions are on hold and can be resumed
+	StatusPaused Status = "paused"
+	// StatusFinished sessions ended with an outcome
+	StatusFinished Status = "finished"
+	// StatusAbandoned sessions ended without being played out
+	StatusAbandoned Status = "abandoned"
+)
+
+// Session modes
+const (
+	// ModeMatchmaking sessions were made by the matchmaking queue
+	ModeMatchmaking = "matchmaking"
+	// ModeLobby sessions were started by the host of a private lobby
+	ModeLobby = "lobby"
+)
+
+// transitions lists the statuses a session can move to from each status.
+// Finished and abandoned sessions are over and can't move again.
+var transitions = map[Status][]Status{
+	StatusProposed: {StatusActive, StatusAbandoned},
+	StatusActive:   {StatusPaused, StatusFinished, StatusAbandoned},
+	StatusPaused:   {StatusActive, StatusFinished, StatusAbandoned},
+}
+
+// CanBecome reports whether a session in status s may move to status to
+func (s Status) CanBecome(to Status) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Over reports whether s is a final status
+func (s Status) Over() bool {
+	return s == StatusFinished || s == StatusAbandoned
+}
+
 type Session struct {
-	SessionID           string `json:"sessionId"`
-	Player1ConnectionID string `json:"player1ConnectionId"`
-	Player2ConnectionID string `json:"player2ConnectionId"`
+	SessionID string `json:"sessionId"`
+	Mode      string `json:"mode,omitempty"`
+	Status    Status `json:"status,omitempty"`
+	Player1ID string `json:"player1Id"`
+	Player2ID string `json:"player2Id"`
+	// Slots are the player IDs in slot order; Player1ID and Player2ID hold the first two
+	Slots     []string   `json:"slots,omitempty"`
+	CreatedAt time.Time  `json:"createdAt,omitempty"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	// EndedAt is set once the session is over, Outcome once it finished
+	EndedAt *time.Time       `json:"endedAt,omitempty"`
+	Outcome *message.Outcome `json:"outcome,omitempty"`
+}
+
+// NewSession creates a proposed session of mode with playerIDs in slot order
+func NewSession(sessionID, mode string, playerIDs []string, createdAt time.Time) Session {
+	session := Session{
+		SessionID: sessionID,
+		Mode:      mode,
+		Status:    StatusProposed,
+		Slots:     append([]string(nil), playerIDs...),
+		CreatedAt: createdAt,
+	}
+	if len(playerIDs) > 0 {
+		session.Player1ID = playerIDs[0]
+	}
+	if len(playerIDs) > 1 {
+		session.Player2ID = playerIDs[1]
+	}
+	return session
+}
+
+// Transition moves the session to status to at the given time, stamping when
+// it started and ended, and returns the status it left
+func (s *Session) Transition(to Status, at time.Time) (Status, error) {
+	from := s.Status
+	if !from.CanBecome(to) {
+		return from, fmt.Errorf("%w: %s from %s to %s", db.ErrIllegalTransition, s.SessionID, from, to)
+	}
+	s.Status = to
+	if to == StatusActive && s.StartedAt == nil {
+		s.StartedAt = &at
+	}
+	if to.Over() {
+		s.EndedAt = &at
+	}
+	return from, nil
+}
+
+// Finished reports whether the session is over, finished or abandoned
+func (s Session) Finished() bool {
+	return s.EndedAt != nil
+}
+
+// Players returns the player IDs of the session in slot order
+func (s Session) Players() []string {
+	if len(s.Slots) > 0 {
+		return s.Slots
+	}
+	return []string{s.Player1ID, s.Player2ID}
+}
+
+// HasPlayer reports whether playerID plays in the session
+func (s Session) HasPlayer(playerID string) bool {
+	for _, id := range s.Players() {
+		if id == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitingPlayer is a player waiting in the matchmaking queue for an opponent
+type WaitingPlayer struct {
+	PlayerID     string    `json:"playerId"`
+	ConnectionID string    `json:"connectionId"`
+	Since        time.Time `json:"since"`
 }
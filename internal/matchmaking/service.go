@@ -1,10 +1,15 @@
 package matchmaking
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
 	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/metrics"
 	"simple-multiplayer-service/internal/notification"
 
 	"github.com/google/uuid"
@@ -15,52 +20,139 @@ type Service struct {
 	SessionLimit        int
 	SessionQueue        chan message.MatchmakingRequest
 	ClientDisconnects   chan string
-	SessionDB           db.Session
+	SessionDB           SessionStore
+	Sessions            *Lifecycle
 	NotificationService *notification.Service
+	Metrics             *metrics.Metrics
+	Logger              *slog.Logger
+	running             atomic.Bool
+
+	// the player waiting for an opponent, shared with Waiting and Drain
+	mutex   sync.Mutex
+	waiting *WaitingPlayer
 }
 
-func NewMatchmakingService(sessionLimit int, sessionDB db.Session, notificationService *notification.Service) *Service {
+func NewMatchmakingService(sessionLimit int, sessionDB SessionStore, notificationService *notification.Service) *Service {
 	sessionQueue := make(chan message.MatchmakingRequest, 100)
 	clientDisconnects := make(chan string, 100)
-	return &Service{SessionLimit: sessionLimit, SessionQueue: sessionQueue, ClientDisconnects: clientDisconnects, SessionDB: sessionDB, NotificationService: notificationService}
+	return &Service{SessionLimit: sessionLimit, SessionQueue: sessionQueue, ClientDisconnects: clientDisconnects, SessionDB: sessionDB, Sessions: NewLifecycle(sessionDB), NotificationService: notificationService, Logger: slog.Default()}
+}
+
+func (matchmakingService *Service) logger() *slog.Logger {
+	return logging.OrDefault(matchmakingService.Logger)
+}
+
+// Running reports whether the matchmaking loop is running
+func (matchmakingService *Service) Running() bool {
+	return matchmakingService.running.Load()
 }
 
 func (matchmakingService *Service) Start() {
-	lookingForOpponent := ""
+	matchmakingService.Run(context.Background())
+}
+
+// Run matches players until ctx is done. The waiting player is kept, so a
+// later Run carries on with the same queue.
+func (matchmakingService *Service) Run(ctx context.Context) {
+	matchmakingService.running.Store(true)
+	defer matchmakingService.running.Store(false)
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case mmRequest := <-matchmakingService.SessionQueue:
+			matchmakingService.mutex.Lock()
 			// if there is no opponent yet, put it into waiting for opponent variable
-			if lookingForOpponent == "" {
-				lookingForOpponent = mmRequest.ConnectionID
+			if matchmakingService.waiting == nil {
+				matchmakingService.waiting = &WaitingPlayer{
+					PlayerID:     mmRequest.PlayerID,
+					ConnectionID: mmRequest.ConnectionID,
+					Since:        time.Now(),
+				}
+				matchmakingService.mutex.Unlock()
+				matchmakingService.Metrics.MatchmakingRequest(1)
 				continue
 			}
 
-			// if there is already player looking for opponent, match it
-			newSession := Session{
-				SessionID:           uuid.New().String(),
-				Player1ConnectionID: mmRequest.ConnectionID,
-				Player2ConnectionID: lookingForOpponent,
+			// a player queueing again from another connection can't be their own opponent
+			if matchmakingService.waiting.PlayerID == mmRequest.PlayerID {
+				matchmakingService.mutex.Unlock()
+				matchmakingService.Metrics.MatchmakingRequest(1)
+				continue
 			}
+			lookingForOpponent := matchmakingService.waiting.PlayerID
+			waitingSince := matchmakingService.waiting.Since
+			matchmakingService.mutex.Unlock()
+			matchmakingService.Metrics.MatchmakingRequest(2)
 
-			err := matchmakingService.SessionDB.CreateSession(newSession.SessionID, newSession.Player1ConnectionID, newSession.Player2ConnectionID)
+			// if there is already player looking for opponent, match it
+			sessionID := uuid.New().String()
+			newSession, err := matchmakingService.Sessions.Create(sessionID, ModeMatchmaking, []string{mmRequest.PlayerID, lookingForOpponent})
 			if err != nil {
-				log.Println(err)
+				matchmakingService.logger().Error("error creating session", slog.String(logging.KeySessionID, sessionID), logging.Err(err))
+				matchmakingService.Metrics.MatchFailed()
 				continue
 			}
+			matchmakingService.logger().Info("session created",
+				slog.String(logging.KeySessionID, newSession.SessionID),
+				slog.String("player1_id", newSession.Player1ID),
+				slog.String("player2_id", newSession.Player2ID))
 
 			matchmakingService.SessionNumber++
+			matchmakingService.Metrics.MatchCreated(matchmakingService.SessionNumber, waitingSince)
 			newSessionNotification := notification.SessionNotification{
-				SessionID:           newSession.SessionID,
-				Player1ConnectionID: mmRequest.ConnectionID,
-				Player2ConnectionID: lookingForOpponent,
+				SessionID: newSession.SessionID,
+				Player1ID: newSession.Player1ID,
+				Player2ID: newSession.Player2ID,
+				CreatedAt: newSession.CreatedAt,
 			}
 			matchmakingService.NotificationService.Channel <- newSessionNotification
-			lookingForOpponent = ""
-		case clientID := <-matchmakingService.ClientDisconnects:
-			if lookingForOpponent == clientID {
-				lookingForOpponent = ""
-			}
+			matchmakingService.clearWaiting(lookingForOpponent)
+		case playerID := <-matchmakingService.ClientDisconnects:
+			matchmakingService.clearWaiting(playerID)
 		}
 	}
 }
+
+// Waiting returns the players waiting for an opponent
+func (matchmakingService *Service) Waiting() []WaitingPlayer {
+	matchmakingService.mutex.Lock()
+	defer matchmakingService.mutex.Unlock()
+	if matchmakingService.waiting == nil {
+		return []WaitingPlayer{}
+	}
+	return []WaitingPlayer{*matchmakingService.waiting}
+}
+
+// Drain removes the waiting player and every request still in SessionQueue,
+// returning the player IDs that were removed
+func (matchmakingService *Service) Drain() []string {
+	matchmakingService.mutex.Lock()
+	defer matchmakingService.mutex.Unlock()
+
+	drained := make([]string, 0)
+	if matchmakingService.waiting != nil {
+		drained = append(drained, matchmakingService.waiting.PlayerID)
+		matchmakingService.waiting = nil
+	}
+	for {
+		select {
+		case mmRequest := <-matchmakingService.SessionQueue:
+			drained = append(drained, mmRequest.PlayerID)
+		default:
+			matchmakingService.Metrics.MatchmakingWaiting(0)
+			return drained
+		}
+	}
+}
+
+// clearWaiting stops playerID from waiting for an opponent
+func (matchmakingService *Service) clearWaiting(playerID string) {
+	matchmakingService.mutex.Lock()
+	defer matchmakingService.mutex.Unlock()
+	if matchmakingService.waiting != nil && matchmakingService.waiting.PlayerID == playerID {
+		matchmakingService.waiting = nil
+		matchmakingService.Metrics.MatchmakingWaiting(0)
+	}
+}
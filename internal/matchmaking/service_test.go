@@ -1,6 +1,8 @@
 package matchmaking
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -8,22 +10,58 @@ import (
 	"simple-multiplayer-service/internal/notification"
 )
 
-// MockSessionDB is a mock implementation of the db.Session interface
+// MockSessionDB is a mock implementation of the SessionStore interface
+// keeping the last session created
 type MockSessionDB struct {
 	CreateSessionCalled bool
 	SessionID           string
+	Mode                string
 	Player1ID           string
 	Player2ID           string
+	Session             Session
 }
 
-func (m *MockSessionDB) CreateSession(sessionID, player1ConnectionID, player2ConnectionID string) error {
+func (m *MockSessionDB) CreateSession(sessionID, mode string, playerIDs []string, createdAt time.Time) error {
 	m.CreateSessionCalled = true
+	m.Session = NewSession(sessionID, mode, playerIDs, createdAt)
 	m.SessionID = sessionID
-	m.Player1ID = player1ConnectionID
-	m.Player2ID = player2ConnectionID
+	m.Mode = mode
+	m.Player1ID = m.Session.Player1ID
+	m.Player2ID = m.Session.Player2ID
 	return nil
 }
 
+func (m *MockSessionDB) GetSession(sessionID string) (Session, error) {
+	if !m.CreateSessionCalled || m.SessionID != sessionID {
+		return Session{}, errors.New("session not found")
+	}
+	return m.Session, nil
+}
+
+func (m *MockSessionDB) SetSessionStatus(sessionID, status string, at time.Time) (string, error) {
+	if _, err := m.GetSession(sessionID); err != nil {
+		return "", err
+	}
+	from, err := m.Session.Transition(Status(status), at)
+	return string(from), err
+}
+
+func (m *MockSessionDB) FinishSession(sessionID string, outcome message.Outcome, endedAt time.Time) (string, error) {
+	from, err := m.SetSessionStatus(sessionID, string(StatusFinished), endedAt)
+	if err == nil {
+		m.Session.Outcome = &outcome
+	}
+	return from, err
+}
+
+func (m *MockSessionDB) AbandonSession(sessionID string, outcome *message.Outcome, endedAt time.Time) (string, error) {
+	from, err := m.SetSessionStatus(sessionID, string(StatusAbandoned), endedAt)
+	if err == nil {
+		m.Session.Outcome = outcome
+	}
+	return from, err
+}
+
 func TestNewMatchmakingService(t *testing.T) {
 	// Setup
 	sessionLimit := 10
@@ -64,12 +102,12 @@ func TestStart(t *testing.T) {
 
 	// Send two matchmaking requests to create a session
 	mmr1 := message.MatchmakingRequest{
-		ConnectionID: "client1",
-		Type:         message.MatchmakingRequestType,
+		PlayerID: "client1",
+		Type:     message.MatchmakingRequestType,
 	}
 	mmr2 := message.MatchmakingRequest{
-		ConnectionID: "client2",
-		Type:         message.MatchmakingRequestType,
+		PlayerID: "client2",
+		Type:     message.MatchmakingRequestType,
 	}
 
 	// Send the first request
@@ -94,15 +132,18 @@ func TestStart(t *testing.T) {
 	if sessionDB.Player2ID != "client1" {
 		t.Errorf("Expected Player2ID to be 'client1', got '%s'", sessionDB.Player2ID)
 	}
+	if sessionDB.Mode != ModeMatchmaking {
+		t.Errorf("Expected mode '%s', got '%s'", ModeMatchmaking, sessionDB.Mode)
+	}
 
 	// Verify notification was sent
 	select {
 	case notif := <-notificationService.Channel:
-		if notif.Player1ConnectionID != "client2" {
-			t.Errorf("Expected Player1ConnectionID to be 'client2', got '%s'", notif.Player1ConnectionID)
+		if notif.Player1ID != "client2" {
+			t.Errorf("Expected Player1ID to be 'client2', got '%s'", notif.Player1ID)
 		}
-		if notif.Player2ConnectionID != "client1" {
-			t.Errorf("Expected Player2ConnectionID to be 'client1', got '%s'", notif.Player2ConnectionID)
+		if notif.Player2ID != "client1" {
+			t.Errorf("Expected Player2ID to be 'client1', got '%s'", notif.Player2ID)
 		}
 	default:
 		t.Error("Expected notification to be sent")
@@ -117,8 +158,8 @@ func TestMatchmakingAfterDisconnect(t *testing.T) {
 	go service.Start()
 
 	// --- First matchmaking session ---
-	mmr1 := message.MatchmakingRequest{ConnectionID: "client1", Type: message.MatchmakingRequestType}
-	mmr2 := message.MatchmakingRequest{ConnectionID: "client2", Type: message.MatchmakingRequestType}
+	mmr1 := message.MatchmakingRequest{PlayerID: "client1", Type: message.MatchmakingRequestType}
+	mmr2 := message.MatchmakingRequest{PlayerID: "client2", Type: message.MatchmakingRequestType}
 
 	service.SessionQueue <- mmr1
 	time.Sleep(50 * time.Millisecond)
@@ -140,8 +181,8 @@ func TestMatchmakingAfterDisconnect(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	// --- Second matchmaking session ---
-	mmr3 := message.MatchmakingRequest{ConnectionID: "client3", Type: message.MatchmakingRequestType}
-	mmr4 := message.MatchmakingRequest{ConnectionID: "client4", Type: message.MatchmakingRequestType}
+	mmr3 := message.MatchmakingRequest{PlayerID: "client3", Type: message.MatchmakingRequestType}
+	mmr4 := message.MatchmakingRequest{PlayerID: "client4", Type: message.MatchmakingRequestType}
 
 	service.SessionQueue <- mmr3
 	time.Sleep(50 * time.Millisecond)
@@ -158,13 +199,77 @@ func TestMatchmakingAfterDisconnect(t *testing.T) {
 	// Verify notification was sent for the second session
 	select {
 	case notif := <-notificationService.Channel:
-		if notif.Player1ConnectionID != "client4" {
-			t.Errorf("Expected Player1ConnectionID to be 'client4', got '%s'", notif.Player1ConnectionID)
+		if notif.Player1ID != "client4" {
+			t.Errorf("Expected Player1ID to be 'client4', got '%s'", notif.Player1ID)
 		}
-		if notif.Player2ConnectionID != "client3" {
-			t.Errorf("Expected Player2ConnectionID to be 'client3', got '%s'", notif.Player2ConnectionID)
+		if notif.Player2ID != "client3" {
+			t.Errorf("Expected Player2ID to be 'client3', got '%s'", notif.Player2ID)
 		}
 	default:
 		t.Error("Expected notification to be sent for the second session")
 	}
 }
+
+func TestStartIgnoresSamePlayerTwice(t *testing.T) {
+	// Setup
+	sessionDB := &MockSessionDB{}
+	notificationService := notification.NewNotificationService()
+	service := NewMatchmakingService(10, sessionDB, notificationService)
+	go service.Start()
+
+	// The same player queueing from two connections must not be matched with themselves
+	service.SessionQueue <- message.MatchmakingRequest{PlayerID: "player1", ConnectionID: "tab1", Type: message.MatchmakingRequestType}
+	service.SessionQueue <- message.MatchmakingRequest{PlayerID: "player1", ConnectionID: "tab2", Type: message.MatchmakingRequestType}
+	time.Sleep(50 * time.Millisecond)
+
+	if sessionDB.CreateSessionCalled {
+		t.Fatal("Expected no session for a player matched with themselves")
+	}
+
+	service.SessionQueue <- message.MatchmakingRequest{PlayerID: "player2", ConnectionID: "tab3", Type: message.MatchmakingRequestType}
+	time.Sleep(50 * time.Millisecond)
+
+	if !sessionDB.CreateSessionCalled {
+		t.Fatal("Expected CreateSession to be called")
+	}
+	if sessionDB.Player1ID != "player2" || sessionDB.Player2ID != "player1" {
+		t.Errorf("Expected players 'player2' and 'player1', got '%s' and '%s'", sessionDB.Player1ID, sessionDB.Player2ID)
+	}
+}
+
+func TestRunning(t *testing.T) {
+	service := NewMatchmakingService(10, &MockSessionDB{}, notification.NewNotificationService())
+	if service.Running() {
+		t.Error("Expected service not to be running before Start")
+	}
+
+	go service.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	if !service.Running() {
+		t.Error("Expected service to be running after Start")
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	notificationService := notification.NewNotificationService()
+	matchmakingService := NewMatchmakingService(10, &MockSessionDB{}, notificationService)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		matchmakingService.Run(ctx)
+		close(stopped)
+	}()
+
+	matchmakingService.SessionQueue <- message.MatchmakingRequest{PlayerID: "player1"}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Run to return when the context is cancelled")
+	}
+	if matchmakingService.Running() {
+		t.Error("Expected Running to be false after Run returns")
+	}
+}
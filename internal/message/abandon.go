@@ -0,0 +1,33 @@
+package message
+
+import "time"
+
+// Types of events sent by the server when a player leaves a session
+const (
+	PlayerDisconnectedType = "playerDisconnected"
+	PlayerReconnectedType  = "playerReconnected"
+)
+
+// Error codes sent to players who abandoned sessions
+const (
+	// ErrorCodeLeaverPenalty refuses a matchmaking request from a player who recently abandoned a session
+	ErrorCodeLeaverPenalty = "leaver_penalty"
+)
+
+// PlayerDisconnected tells the other members of a session that a player
+// lost its last connection. The session is abandoned unless the player is
+// back by ReconnectBy.
+type PlayerDisconnected struct {
+	Type        string    `json:"type"`
+	SessionID   string    `json:"session_id"`
+	PlayerID    string    `json:"player_id"`
+	ReconnectBy time.Time `json:"reconnect_by"`
+}
+
+// PlayerReconnected tells the other members of a session that a
+// disconnected player is back
+type PlayerReconnected struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	PlayerID  string `json:"player_id"`
+}
@@ -0,0 +1,145 @@
+package message
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of lobby requests sent by clients. Every request but createLobby and
+// joinLobby acts on the lobby the player is in.
+const (
+	CreateLobbyType        = "createLobby"
+	JoinLobbyType          = "joinLobby"
+	LeaveLobbyType         = "leaveLobby"
+	KickFromLobbyType      = "kickFromLobby"
+	SetLobbyMaxPlayersType = "setLobbyMaxPlayers"
+	LockLobbyType          = "lockLobby"
+	UnlockLobbyType        = "unlockLobby"
+	StartLobbyType         = "startLobby"
+	// LobbyMessageType is both the client request and the message relayed to members
+	LobbyMessageType = "lobbyMessage"
+)
+
+// Types of lobby events sent by the server
+const (
+	LobbyUpdatedType = "lobbyUpdated"
+	LobbyLeftType    = "lobbyLeft"
+	LobbyStartedType = "lobbyStarted"
+)
+
+// Error codes sent in response to lobby requests
+const (
+	ErrorCodeLobbyRefused = "lobby_refused"
+)
+
+// Reasons a player is no longer in a lobby
+const (
+	LobbyLeftReasonLeft   = "left"
+	LobbyLeftReasonKicked = "kicked"
+)
+
+// LobbyCodeLength is the length of lobby join codes
+const LobbyCodeLength = 6
+
+// MinLobbyPlayers is the fewest players a lobby starts with
+const MinLobbyPlayers = 2
+
+// LobbyRequest is sent by a client to create, join, leave, run or chat in a lobby
+type LobbyRequest struct {
+	Type string `json:"type"`
+	// Code is the lobby joined with joinLobby
+	Code string `json:"code,omitempty"`
+	// PlayerID is the member removed with kickFromLobby
+	PlayerID string `json:"player_id,omitempty"`
+	// MaxPlayers sizes the lobby with createLobby, where zero keeps the
+	// default, and setLobbyMaxPlayers
+	MaxPlayers int `json:"max_players,omitempty"`
+	// Text is the message posted with lobbyMessage
+	Text string `json:"text,omitempty"`
+}
+
+// LobbyMember is a player in a lobby
+type LobbyMember struct {
+	PlayerID    string `json:"player_id"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// Lobby is the state of a private lobby. Members are in join order, which
+// is the slot order of the session it starts.
+type Lobby struct {
+	Code       string        `json:"code"`
+	HostID     string        `json:"host_id"`
+	MaxPlayers int           `json:"max_players"`
+	Locked     bool          `json:"locked"`
+	Members    []LobbyMember `json:"members"`
+}
+
+// LobbyUpdated sends the members of a lobby its state whenever it changes
+type LobbyUpdated struct {
+	Type  string `json:"type"`
+	Lobby Lobby  `json:"lobby"`
+}
+
+// LobbyLeft tells a player it left or was kicked from a lobby
+type LobbyLeft struct {
+	Type   string `json:"type"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// LobbyStarted tells the members of a lobby the session it started; the
+// session notification follows
+type LobbyStarted struct {
+	Type      string `json:"type"`
+	Code      string `json:"code"`
+	SessionID string `json:"session_id"`
+}
+
+// LobbyMessage is a chat message relayed to every member of a lobby
+type LobbyMessage struct {
+	Type   string      `json:"type"`
+	Code   string      `json:"code"`
+	From   LobbyMember `json:"from"`
+	Text   string      `json:"text"`
+	SentAt time.Time   `json:"sent_at"`
+}
+
+// IsLobbyRequest reports whether a content type is handled as a LobbyRequest
+func IsLobbyRequest(contentType string) bool {
+	switch contentType {
+	case CreateLobbyType, JoinLobbyType, LeaveLobbyType, KickFromLobbyType, SetLobbyMaxPlayersType,
+		LockLobbyType, UnlockLobbyType, StartLobbyType, LobbyMessageType:
+		return true
+	}
+	return false
+}
+
+func validateLobbyRequest(content []byte) error {
+	var request LobbyRequest
+	if err := decodeStrict(content, &request); err != nil {
+		return err
+	}
+	switch request.Type {
+	case CreateLobbyType:
+		if request.MaxPlayers != 0 && request.MaxPlayers < MinLobbyPlayers {
+			return fmt.Errorf("%w: a lobby holds at least %d players", ErrInvalidPayload, MinLobbyPlayers)
+		}
+	case JoinLobbyType:
+		if len(request.Code) != LobbyCodeLength {
+			return fmt.Errorf("%w: lobby code must be %d characters", ErrInvalidPayload, LobbyCodeLength)
+		}
+	case KickFromLobbyType:
+		if request.PlayerID == "" {
+			return fmt.Errorf("%w: kick names no player", ErrInvalidPayload)
+		}
+	case SetLobbyMaxPlayersType:
+		if request.MaxPlayers < MinLobbyPlayers {
+			return fmt.Errorf("%w: a lobby holds at least %d players", ErrInvalidPayload, MinLobbyPlayers)
+		}
+	case LobbyMessageType:
+		if request.Text == "" {
+			return fmt.Errorf("%w: lobby message has no text", ErrInvalidPayload)
+		}
+	}
+	return nil
+}
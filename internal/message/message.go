@@ -1,15 +1,135 @@
 package message
 
+import (
+	"encoding/json"
+	"time"
+)
+
 const MatchmakingRequestType = "matchmakingRequest"
 
+// AckMessageType acknowledges a server message carrying an ID, such as an
+// offline message or a session notification, so it isn't delivered again
+const AckMessageType = "ackMessage"
+
+// Types of content sent by the server
+const (
+	ErrorType                = "error"
+	AnnouncementType         = "announcement"
+	SessionEndedType         = "sessionEnded"
+	SessionStatusType        = "sessionStatus"
+	MatchmakingCancelledType = "matchmakingCancelled"
+	OfflineMessageType       = "offlineMessage"
+	ReceiptType              = "receipt"
+)
+
+// Receipt statuses
+const (
+	// ReceiptDelivered means the message was written to at least one recipient connection
+	ReceiptDelivered = "delivered"
+	// ReceiptStored means the recipient is offline and will get the message on connect
+	ReceiptStored = "stored"
+	// ReceiptFailed means the message could not be delivered
+	ReceiptFailed = "failed"
+)
+
+// MaxMessageIDLength is the longest accepted client message ID in bytes
+const MaxMessageIDLength = 64
+
+// ServerID is the sender of messages originating from the server
+const ServerID = "server"
+
+// Error codes sent in error frames
+const (
+	ErrorCodeRateLimited = "rate_limited"
+)
+
 // Message represents a message sent between clients
 type Message struct {
+	// ID is optional; when a client sets it the server answers with a Receipt
+	ID      string `json:"id,omitempty"`
 	From    string `json:"from"`
 	To      string `json:"to"`
 	Content string `json:"content"`
 }
 
 type MatchmakingRequest struct {
+	PlayerID     string `json:"player_id"`
 	ConnectionID string `json:"connection_id"`
 	Type         string `json:"type"`
 }
+
+// Error is sent by the server when it refuses a client's message
+type Error struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Announcement is a notice from the operators, e.g. planned maintenance
+type Announcement struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// SessionEnded tells the members of a session that it is over
+type SessionEnded struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason"`
+}
+
+// SessionStatus tells the members of a session that it paused, resumed or
+// was abandoned. Outcome is set when an abandoned session was forfeited.
+type SessionStatus struct {
+	Type      string    `json:"type"`
+	SessionID string    `json:"session_id"`
+	Status    string    `json:"status"`
+	Previous  string    `json:"previous"`
+	At        time.Time `json:"at"`
+	Outcome   *Outcome  `json:"outcome,omitempty"`
+}
+
+// MatchmakingCancelled tells a player they were removed from the matchmaking queue
+type MatchmakingCancelled struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// OfflineMessage carries a direct message stored while the recipient was
+// offline. The client acknowledges it with an AckMessage carrying its ID.
+type OfflineMessage struct {
+	Type    string    `json:"type"`
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Content string    `json:"content"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// AckMessage is sent by a client once it has processed an OfflineMessage
+type AckMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Receipt tells the sender of a message with an ID whether it was delivered
+type Receipt struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewServerMessage builds a server message with content marshalled as JSON
+func NewServerMessage(to string, content interface{}) Message {
+	contentBytes, _ := json.Marshal(content)
+	return Message{
+		From:    ServerID,
+		To:      to,
+		Content: string(contentBytes),
+	}
+}
+
+// NewErrorMessage builds a server message carrying an Error to the given connection
+func NewErrorMessage(to, code, text string) Message {
+	return NewServerMessage(to, Error{Type: ErrorType, Code: code, Message: text})
+}
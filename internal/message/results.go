@@ -0,0 +1,139 @@
+package message
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of result requests sent by clients. MatchHistoryType is both the
+// request and its answer.
+const (
+	ReportResultType = "reportResult"
+	MatchHistoryType = "matchHistory"
+)
+
+// Types of result events sent by the server
+const (
+	ResultReceivedType  = "resultReceived"
+	SessionFinishedType = "sessionFinished"
+)
+
+// Error codes sent in response to result reports
+const (
+	ErrorCodeResultRefused = "result_refused"
+)
+
+// How the outcome of a session was decided
+const (
+	// ResolutionAgreed means every report claimed the same result
+	ResolutionAgreed = "agreed"
+	// ResolutionUncontested means a single player reported before the deadline
+	ResolutionUncontested = "uncontested"
+	// ResolutionAuthoritative means the game engine or an operator decided
+	ResolutionAuthoritative = "authoritative"
+	// ResolutionDisputed means the players disagreed and nobody settled it
+	ResolutionDisputed = "disputed"
+	// ResolutionForfeit means the other players left and never came back
+	ResolutionForfeit = "forfeit"
+)
+
+// Statuses of a result report
+const (
+	// ResultPending means some players haven't reported yet
+	ResultPending = "pending"
+	// ResultDisputed means the reports disagree; the session finishes as
+	// disputed at the deadline unless an authoritative result arrives
+	ResultDisputed = "disputed"
+	// ResultAccepted means the report finished the session
+	ResultAccepted = "accepted"
+)
+
+// MaxMatchHistory is the most sessions returned by one matchHistory request
+const MaxMatchHistory = 100
+
+// Outcome is how a session ended: a winner, a draw, or neither when disputed
+type Outcome struct {
+	WinnerID   string         `json:"winner_id,omitempty"`
+	Draw       bool           `json:"draw,omitempty"`
+	Scores     map[string]int `json:"scores,omitempty"`
+	Resolution string         `json:"resolution,omitempty"`
+}
+
+// ResultReport is sent by a player, or the game engine, once a session is over
+type ResultReport struct {
+	Type      string         `json:"type"`
+	SessionID string         `json:"session_id"`
+	WinnerID  string         `json:"winner_id,omitempty"`
+	Draw      bool           `json:"draw,omitempty"`
+	Scores    map[string]int `json:"scores,omitempty"`
+}
+
+// Outcome returns the outcome the report claims
+func (r ResultReport) Outcome() Outcome {
+	return Outcome{WinnerID: r.WinnerID, Draw: r.Draw, Scores: r.Scores}
+}
+
+// ResultReceived answers a result report with its status
+type ResultReceived struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	Status    string `json:"status"`
+}
+
+// SessionFinished tells the members of a session its outcome
+type SessionFinished struct {
+	Type      string    `json:"type"`
+	SessionID string    `json:"session_id"`
+	EndedAt   time.Time `json:"ended_at"`
+	Outcome   Outcome   `json:"outcome"`
+}
+
+// MatchHistoryRequest asks for the player's last finished sessions, newest
+// first; zero asks for MaxMatchHistory
+type MatchHistoryRequest struct {
+	Type  string `json:"type"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// MatchRecord is a finished session in a player's history. Players lists
+// every player in slot order; Player1ID and Player2ID hold the first two.
+type MatchRecord struct {
+	SessionID string    `json:"session_id"`
+	Player1ID string    `json:"player1_id"`
+	Player2ID string    `json:"player2_id"`
+	Players   []string  `json:"players"`
+	Status    string    `json:"status"`
+	EndedAt   time.Time `json:"ended_at"`
+	Outcome   Outcome   `json:"outcome"`
+}
+
+// MatchHistory answers a matchHistory request
+type MatchHistory struct {
+	Type    string        `json:"type"`
+	Matches []MatchRecord `json:"matches"`
+}
+
+func validateResultReport(content []byte) error {
+	var report ResultReport
+	if err := decodeStrict(content, &report); err != nil {
+		return err
+	}
+	if report.SessionID == "" || len(report.SessionID) > MaxSessionIDLength {
+		return fmt.Errorf("%w: session id must be 1 to %d bytes", ErrInvalidPayload, MaxSessionIDLength)
+	}
+	if report.Draw == (report.WinnerID != "") {
+		return fmt.Errorf("%w: result needs either a winner or a draw", ErrInvalidPayload)
+	}
+	return nil
+}
+
+func validateMatchHistoryRequest(content []byte) error {
+	var request MatchHistoryRequest
+	if err := decodeStrict(content, &request); err != nil {
+		return err
+	}
+	if request.Limit < 0 || request.Limit > MaxMatchHistory {
+		return fmt.Errorf("%w: limit must be 0 to %d", ErrInvalidPayload, MaxMatchHistory)
+	}
+	return nil
+}
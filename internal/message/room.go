@@ -0,0 +1,100 @@
+package message
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of room requests sent by clients
+const (
+	JoinRoomType        = "joinRoom"
+	LeaveRoomType       = "leaveRoom"
+	ListRoomMembersType = "listRoomMembers"
+	// RoomMessageType is both the client request and the message relayed to members
+	RoomMessageType = "roomMessage"
+)
+
+// Types of room events sent by the server
+const (
+	RoomJoinedType       = "roomJoined"
+	RoomMembersType      = "roomMembers"
+	RoomMemberJoinedType = "roomMemberJoined"
+	RoomMemberLeftType   = "roomMemberLeft"
+)
+
+// Error codes sent in response to room requests
+const (
+	ErrorCodeNotInRoom = "not_in_room"
+)
+
+// MaxRoomNameLength is the longest accepted room name in bytes
+const MaxRoomNameLength = 64
+
+// RoomRequest is sent by a client to join, leave, list or post to a room
+type RoomRequest struct {
+	Type string `json:"type"`
+	Room string `json:"room"`
+	// Text is the message posted with roomMessage
+	Text string `json:"text,omitempty"`
+}
+
+// RoomMember is a connection that has joined a room
+type RoomMember struct {
+	ConnectionID string `json:"connection_id"`
+	PlayerID     string `json:"player_id"`
+	DisplayName  string `json:"display_name,omitempty"`
+}
+
+// RoomMessage is a message relayed to every member of a room
+type RoomMessage struct {
+	Type   string     `json:"type"`
+	Room   string     `json:"room"`
+	From   RoomMember `json:"from"`
+	Text   string     `json:"text"`
+	SentAt time.Time  `json:"sent_at"`
+}
+
+// RoomJoined confirms a join with the current members and recent history
+type RoomJoined struct {
+	Type    string        `json:"type"`
+	Room    string        `json:"room"`
+	Members []RoomMember  `json:"members"`
+	History []RoomMessage `json:"history"`
+}
+
+// RoomMembers answers listRoomMembers
+type RoomMembers struct {
+	Type    string       `json:"type"`
+	Room    string       `json:"room"`
+	Members []RoomMember `json:"members"`
+}
+
+// RoomMemberEvent tells room members that someone joined or left
+type RoomMemberEvent struct {
+	Type   string     `json:"type"`
+	Room   string     `json:"room"`
+	Member RoomMember `json:"member"`
+}
+
+// IsRoomRequest reports whether a content type is handled as a RoomRequest
+func IsRoomRequest(contentType string) bool {
+	switch contentType {
+	case JoinRoomType, LeaveRoomType, ListRoomMembersType, RoomMessageType:
+		return true
+	}
+	return false
+}
+
+func validateRoomRequest(content []byte) error {
+	var request RoomRequest
+	if err := decodeStrict(content, &request); err != nil {
+		return err
+	}
+	if request.Room == "" || len(request.Room) > MaxRoomNameLength {
+		return fmt.Errorf("%w: room name must be 1 to %d bytes", ErrInvalidPayload, MaxRoomNameLength)
+	}
+	if request.Type == RoomMessageType && request.Text == "" {
+		return fmt.Errorf("%w: room message has no text", ErrInvalidPayload)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package message
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of spectator requests sent by clients
+const (
+	SpectateType       = "spectate"
+	StopSpectatingType = "stopSpectating"
+	ListSpectatorsType = "listSpectators"
+)
+
+// Types of spectator events sent by the server
+const (
+	SpectatingType        = "spectating"
+	SpectatorsType        = "spectators"
+	SpectatedMessageType  = "spectatedMessage"
+	SpectatingStoppedType = "spectatingStopped"
+)
+
+// Error codes sent in response to spectator requests
+const (
+	ErrorCodeSpectateRefused = "spectate_refused"
+	// ErrorCodeSpectatorInput refuses a message from a spectator to a player it watches
+	ErrorCodeSpectatorInput = "spectator_input"
+)
+
+// MaxSessionIDLength is the longest accepted session ID in bytes
+const MaxSessionIDLength = 64
+
+// SpectateRequest is sent by a client to start or stop spectating a
+// session, or to list its spectators
+type SpectateRequest struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+}
+
+// Spectator is a connection watching a session
+type Spectator struct {
+	ConnectionID string `json:"connection_id"`
+	PlayerID     string `json:"player_id"`
+	DisplayName  string `json:"display_name,omitempty"`
+}
+
+// Spectating confirms a spectate request. Players lists every player of the
+// session in slot order; Player1ID and Player2ID hold the first two.
+// Messages between the players reach the spectator DelaySeconds after they
+// were sent.
+type Spectating struct {
+	Type         string      `json:"type"`
+	SessionID    string      `json:"session_id"`
+	Player1ID    string      `json:"player1_id"`
+	Player2ID    string      `json:"player2_id"`
+	Players      []string    `json:"players"`
+	DelaySeconds int         `json:"delay_seconds"`
+	Spectators   []Spectator `json:"spectators"`
+}
+
+// Spectators answers listSpectators
+type Spectators struct {
+	Type       string      `json:"type"`
+	SessionID  string      `json:"session_id"`
+	Spectators []Spectator `json:"spectators"`
+}
+
+// SpectatedMessage relays a message sent within a session to its spectators
+type SpectatedMessage struct {
+	Type      string    `json:"type"`
+	SessionID string    `json:"session_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to,omitempty"`
+	Content   string    `json:"content"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// SpectatingStopped tells a spectator it no longer receives a session's messages
+type SpectatingStopped struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason"`
+}
+
+// IsSpectateRequest reports whether a content type is handled as a SpectateRequest
+func IsSpectateRequest(contentType string) bool {
+	switch contentType {
+	case SpectateType, StopSpectatingType, ListSpectatorsType:
+		return true
+	}
+	return false
+}
+
+func validateSpectateRequest(content []byte) error {
+	var request SpectateRequest
+	if err := decodeStrict(content, &request); err != nil {
+		return err
+	}
+	if request.SessionID == "" || len(request.SessionID) > MaxSessionIDLength {
+		return fmt.Errorf("%w: session id must be 1 to %d bytes", ErrInvalidPayload, MaxSessionIDLength)
+	}
+	return nil
+}
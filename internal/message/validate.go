@@ -0,0 +1,112 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidPayload   = errors.New("invalid message payload")
+	ErrContentTooLong   = errors.New("message content too long")
+	ErrMissingRecipient = errors.New("message has no recipient")
+)
+
+// contentValidators check the schema of typed content, keyed by content type
+var contentValidators = map[string]func(content []byte) error{
+	MatchmakingRequestType: validateMatchmakingRequest,
+	AckMessageType:         validateAckMessage,
+	JoinRoomType:           validateRoomRequest,
+	LeaveRoomType:          validateRoomRequest,
+	ListRoomMembersType:    validateRoomRequest,
+	RoomMessageType:        validateRoomRequest,
+	SpectateType:           validateSpectateRequest,
+	StopSpectatingType:     validateSpectateRequest,
+	ListSpectatorsType:     validateSpectateRequest,
+	ReportResultType:       validateResultReport,
+	MatchHistoryType:       validateMatchHistoryRequest,
+	CreateLobbyType:        validateLobbyRequest,
+	JoinLobbyType:          validateLobbyRequest,
+	LeaveLobbyType:         validateLobbyRequest,
+	KickFromLobbyType:      validateLobbyRequest,
+	SetLobbyMaxPlayersType: validateLobbyRequest,
+	LockLobbyType:          validateLobbyRequest,
+	UnlockLobbyType:        validateLobbyRequest,
+	StartLobbyType:         validateLobbyRequest,
+	LobbyMessageType:       validateLobbyRequest,
+}
+
+// Decode parses a client frame, rejecting anything but a well-formed envelope
+func Decode(data []byte) (Message, error) {
+	var msg Message
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&msg); err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if decoder.More() {
+		return Message{}, fmt.Errorf("%w: trailing data after message", ErrInvalidPayload)
+	}
+	return msg, nil
+}
+
+// ContentType returns the type of JSON object content, or "" for plain content
+func ContentType(content string) string {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(content), &typed); err != nil {
+		return ""
+	}
+	return typed.Type
+}
+
+// Validate checks a client message before dispatch. Content longer than
+// maxContentLength bytes is rejected unless maxContentLength is zero; typed
+// content must match the schema of its type; untyped content is relayed and
+// needs a recipient.
+func Validate(msg Message, maxContentLength int) error {
+	if maxContentLength > 0 && len(msg.Content) > maxContentLength {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrContentTooLong, len(msg.Content), maxContentLength)
+	}
+
+	if len(msg.ID) > MaxMessageIDLength {
+		return fmt.Errorf("%w: message id longer than %d bytes", ErrInvalidPayload, MaxMessageIDLength)
+	}
+
+	if validator, ok := contentValidators[ContentType(msg.Content)]; ok {
+		return validator([]byte(msg.Content))
+	}
+
+	if msg.To == "" {
+		return ErrMissingRecipient
+	}
+	return nil
+}
+
+func validateMatchmakingRequest(content []byte) error {
+	var mmr MatchmakingRequest
+	return decodeStrict(content, &mmr)
+}
+
+func validateAckMessage(content []byte) error {
+	var ack AckMessage
+	if err := decodeStrict(content, &ack); err != nil {
+		return err
+	}
+	if ack.ID == "" {
+		return fmt.Errorf("%w: ack has no message id", ErrInvalidPayload)
+	}
+	return nil
+}
+
+// decodeStrict unmarshals content, rejecting fields the type doesn't define
+func decodeStrict(content []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return nil
+}
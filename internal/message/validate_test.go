@@ -0,0 +1,76 @@
+package message
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	msg, err := Decode([]byte(`{"to":"client2","content":"Hello"}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if msg.To != "client2" || msg.Content != "Hello" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	for _, payload := range []string{
+		`not json`,
+		`["array"]`,
+		`{"to":"client2","content":"Hello","extra":true}`,
+		`{"to":"client2","content":42}`,
+		`{"to":"client2"} {"to":"client3"}`,
+	} {
+		if _, err := Decode([]byte(payload)); !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("Expected ErrInvalidPayload for %s, got %v", payload, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name             string
+		msg              Message
+		maxContentLength int
+		expected         error
+	}{
+		{"direct message", Message{To: "client2", Content: "Hello"}, 10, nil},
+		{"missing recipient", Message{Content: "Hello"}, 10, ErrMissingRecipient},
+		{"content too long", Message{To: "client2", Content: strings.Repeat("a", 11)}, 10, ErrContentTooLong},
+		{"no content limit", Message{To: "client2", Content: strings.Repeat("a", 11)}, 0, nil},
+		{"matchmaking request", Message{Content: `{"type":"matchmakingRequest"}`}, 0, nil},
+		{"matchmaking request with unknown field", Message{Content: `{"type":"matchmakingRequest","elo":9000}`}, 0, ErrInvalidPayload},
+		{"matchmaking request with wrong field type", Message{Content: `{"type":"matchmakingRequest","player_id":1}`}, 0, ErrInvalidPayload},
+		{"join room", Message{Content: `{"type":"joinRoom","room":"lobby"}`}, 0, nil},
+		{"join room without name", Message{Content: `{"type":"joinRoom"}`}, 0, ErrInvalidPayload},
+		{"room name too long", Message{Content: `{"type":"joinRoom","room":"` + strings.Repeat("r", MaxRoomNameLength+1) + `"}`}, 0, ErrInvalidPayload},
+		{"room message", Message{Content: `{"type":"roomMessage","room":"lobby","text":"hi"}`}, 0, nil},
+		{"room message without text", Message{Content: `{"type":"roomMessage","room":"lobby"}`}, 0, ErrInvalidPayload},
+		{"spectate", Message{Content: `{"type":"spectate","session_id":"session1"}`}, 0, nil},
+		{"spectate without session", Message{Content: `{"type":"listSpectators"}`}, 0, ErrInvalidPayload},
+		{"result", Message{Content: `{"type":"reportResult","session_id":"session1","winner_id":"alice"}`}, 0, nil},
+		{"result with winner and draw", Message{Content: `{"type":"reportResult","session_id":"session1","winner_id":"alice","draw":true}`}, 0, ErrInvalidPayload},
+		{"result without outcome", Message{Content: `{"type":"reportResult","session_id":"session1"}`}, 0, ErrInvalidPayload},
+		{"match history", Message{Content: `{"type":"matchHistory","limit":10}`}, 0, nil},
+		{"match history limit too high", Message{Content: `{"type":"matchHistory","limit":1000}`}, 0, ErrInvalidPayload},
+		{"create lobby", Message{Content: `{"type":"createLobby","max_players":4}`}, 0, nil},
+		{"create lobby for one", Message{Content: `{"type":"createLobby","max_players":1}`}, 0, ErrInvalidPayload},
+		{"join lobby", Message{Content: `{"type":"joinLobby","code":"ABC234"}`}, 0, nil},
+		{"join lobby with short code", Message{Content: `{"type":"joinLobby","code":"ABC"}`}, 0, ErrInvalidPayload},
+		{"kick without player", Message{Content: `{"type":"kickFromLobby"}`}, 0, ErrInvalidPayload},
+		{"lobby message without text", Message{Content: `{"type":"lobbyMessage"}`}, 0, ErrInvalidPayload},
+		{"ack", Message{Content: `{"type":"ackMessage","id":"m1"}`}, 0, nil},
+		{"ack without id", Message{Content: `{"type":"ackMessage"}`}, 0, ErrInvalidPayload},
+		{"message with id", Message{ID: "m1", To: "client2", Content: "Hello"}, 0, nil},
+		{"message id too long", Message{ID: strings.Repeat("i", MaxMessageIDLength+1), To: "client2", Content: "Hello"}, 0, ErrInvalidPayload},
+		{"unknown type is relayed", Message{To: "client2", Content: `{"type":"move","x":1}`}, 0, nil},
+	}
+
+	for _, test := range tests {
+		err := Validate(test.msg, test.maxContentLength)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "multiplayer"
+
+// Metrics holds the service's Prometheus collectors. All methods are safe to
+// call on a nil *Metrics, which records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	connectionsActive   prometheus.Gauge
+	connectionsTotal    prometheus.Counter
+	connectionsRejected *prometheus.CounterVec
+
+	matchmakingRequests prometheus.Counter
+	matchmakingWaiting  prometheus.Gauge
+	matchmakingWait     prometheus.Histogram
+	matchesCreated      prometheus.Counter
+	matchFailures       prometheus.Counter
+	sessions            prometheus.Gauge
+	sessionTransitions  *prometheus.CounterVec
+
+	messagesReceived *prometheus.CounterVec
+	messagesSent     prometheus.Counter
+	sendFailures     prometheus.Counter
+	messageLatency   prometheus.Histogram
+	notificationLag  prometheus.Histogram
+	deliveryRetries  prometheus.Counter
+	deliveryExpired  prometheus.Counter
+}
+
+// New creates the collectors and registers them, along with the Go runtime
+// and process collectors, on a dedicated registry
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		connectionsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "connections_active",
+			Help: "Number of currently registered connections.",
+		}),
+		connectionsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "connections_total",
+			Help: "Total number of registered connections.",
+		}),
+		connectionsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "connections_rejected_total",
+			Help: "Total number of refused connection attempts by reason.",
+		}, []string{"reason"}),
+		matchmakingRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "matchmaking_requests_total",
+			Help: "Total number of matchmaking requests taken from the queue.",
+		}),
+		matchmakingWaiting: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "matchmaking_waiting_players",
+			Help: "Number of players waiting for an opponent.",
+		}),
+		matchmakingWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "matchmaking_wait_seconds",
+			Help:    "Time from a matchmaking request being queued to its match.",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+		}),
+		matchesCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "matches_created_total",
+			Help: "Total number of sessions created by matchmaking.",
+		}),
+		matchFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "match_failures_total",
+			Help: "Total number of matches that could not be stored.",
+		}),
+		sessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "sessions",
+			Help: "Number of sessions created by this process (SessionNumber).",
+		}),
+		sessionTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "session_transitions_total",
+			Help: "Total number of session status changes by previous and new status.",
+		}, []string{"from", "to"}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "messages_received_total",
+			Help: "Total number of client messages by type.",
+		}, []string{"type"}),
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "messages_sent_total",
+			Help: "Total number of messages written to connections.",
+		}),
+		sendFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "send_failures_total",
+			Help: "Total number of messages that could not be delivered.",
+		}),
+		messageLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "message_delivery_seconds",
+			Help:    "Time to route and write a client message to its recipients.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		notificationLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "notification_lag_seconds",
+			Help:    "Time from a session notification being created to its delivery.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+		deliveryRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "delivery_retries_total",
+			Help: "Total number of unacknowledged messages sent again.",
+		}),
+		deliveryExpired: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "delivery_expired_total",
+			Help: "Total number of messages never acknowledged after every attempt.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connectionsActive, m.connectionsTotal, m.connectionsRejected,
+		m.matchmakingRequests, m.matchmakingWaiting, m.matchmakingWait,
+		m.matchesCreated, m.matchFailures, m.sessions, m.sessionTransitions,
+		m.messagesReceived, m.messagesSent, m.sendFailures,
+		m.messageLatency, m.notificationLag,
+		m.deliveryRetries, m.deliveryExpired,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WatchQueue exports the current length of a queue as a gauge
+func (m *Metrics) WatchQueue(name, help string, length func() int) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace, Name: name, Help: help,
+	}, func() float64 {
+		return float64(length())
+	}))
+}
+
+// ConnectionOpened records a newly registered connection
+func (m *Metrics) ConnectionOpened() {
+	if m == nil {
+		return
+	}
+	m.connectionsActive.Inc()
+	m.connectionsTotal.Inc()
+}
+
+// ConnectionClosed records an unregistered connection
+func (m *Metrics) ConnectionClosed() {
+	if m == nil {
+		return
+	}
+	m.connectionsActive.Dec()
+}
+
+// ConnectionRejected records a refused connection attempt
+func (m *Metrics) ConnectionRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.connectionsRejected.WithLabelValues(reason).Inc()
+}
+
+// MatchmakingRequest records a request taken from the queue and the number of players now waiting
+func (m *Metrics) MatchmakingRequest(waiting int) {
+	if m == nil {
+		return
+	}
+	m.matchmakingRequests.Inc()
+	m.matchmakingWaiting.Set(float64(waiting))
+}
+
+// MatchmakingWaiting records the number of players waiting for an opponent
+func (m *Metrics) MatchmakingWaiting(waiting int) {
+	if m == nil {
+		return
+	}
+	m.matchmakingWaiting.Set(float64(waiting))
+}
+
+// MatchCreated records a stored session, the total session count and how long the players waited
+func (m *Metrics) MatchCreated(sessions int, queuedAt time.Time) {
+	if m == nil {
+		return
+	}
+	m.matchesCreated.Inc()
+	m.sessions.Set(float64(sessions))
+	if !queuedAt.IsZero() {
+		m.matchmakingWait.Observe(time.Since(queuedAt).Seconds())
+	}
+}
+
+// MatchFailed records a session that could not be stored
+func (m *Metrics) MatchFailed() {
+	if m == nil {
+		return
+	}
+	m.matchFailures.Inc()
+}
+
+// SessionTransition records a session moving between statuses; from is
+// empty for a session that was just created
+func (m *Metrics) SessionTransition(from, to string) {
+	if m == nil {
+		return
+	}
+	if from == "" {
+		from = "none"
+	}
+	m.sessionTransitions.WithLabelValues(from, to).Inc()
+}
+
+// MessageReceived records a client message of the given type
+func (m *Metrics) MessageReceived(messageType string) {
+	if m == nil {
+		return
+	}
+	m.messagesReceived.WithLabelValues(messageType).Inc()
+}
+
+// MessageSent records a message written to a connection
+func (m *Metrics) MessageSent() {
+	if m == nil {
+		return
+	}
+	m.messagesSent.Inc()
+}
+
+// SendFailed records a message that could not be delivered
+func (m *Metrics) SendFailed() {
+	if m == nil {
+		return
+	}
+	m.sendFailures.Inc()
+}
+
+// MessageDelivered records how long routing and writing a message took
+func (m *Metrics) MessageDelivered(started time.Time) {
+	if m == nil {
+		return
+	}
+	m.messageLatency.Observe(time.Since(started).Seconds())
+}
+
+// NotificationDelivered records the lag between a notification's creation and its delivery
+func (m *Metrics) NotificationDelivered(createdAt time.Time) {
+	if m == nil || createdAt.IsZero() {
+		return
+	}
+	m.notificationLag.Observe(time.Since(createdAt).Seconds())
+}
+
+// DeliveryRetried records an unacknowledged message being sent again
+func (m *Metrics) DeliveryRetried() {
+	if m == nil {
+		return
+	}
+	m.deliveryRetries.Inc()
+}
+
+// DeliveryExpired records a message that was never acknowledged
+func (m *Metrics) DeliveryExpired() {
+	if m == nil {
+		return
+	}
+	m.deliveryExpired.Inc()
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerExposesMetrics(t *testing.T) {
+	m := New()
+	queue := make(chan struct{}, 10)
+	queue <- struct{}{}
+	m.WatchQueue("test_queue_depth", "Test queue depth.", func() int { return len(queue) })
+
+	m.ConnectionOpened()
+	m.ConnectionOpened()
+	m.ConnectionClosed()
+	m.ConnectionRejected("unauthorized")
+	m.MatchmakingRequest(1)
+	m.MatchCreated(3, time.Now().Add(-2*time.Second))
+	m.SessionTransition("", "proposed")
+	m.MessageReceived("direct")
+	m.MessageSent()
+	m.SendFailed()
+	m.MessageDelivered(time.Now())
+	m.NotificationDelivered(time.Now())
+	m.DeliveryRetried()
+	m.DeliveryExpired()
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	output := string(body)
+
+	for _, expected := range []string{
+		"multiplayer_connections_active 1",
+		"multiplayer_connections_total 2",
+		`multiplayer_connections_rejected_total{reason="unauthorized"} 1`,
+		"multiplayer_matchmaking_requests_total 1",
+		"multiplayer_matchmaking_wait_seconds_count 1",
+		"multiplayer_matches_created_total 1",
+		"multiplayer_sessions 3",
+		`multiplayer_session_transitions_total{from="none",to="proposed"} 1`,
+		`multiplayer_messages_received_total{type="direct"} 1`,
+		"multiplayer_messages_sent_total 1",
+		"multiplayer_send_failures_total 1",
+		"multiplayer_message_delivery_seconds_count 1",
+		"multiplayer_notification_lag_seconds_count 1",
+		"multiplayer_delivery_retries_total 1",
+		"multiplayer_delivery_expired_total 1",
+		"multiplayer_test_queue_depth 1",
+		"go_goroutines",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected metrics output to contain %q", expected)
+		}
+	}
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *Metrics
+	m.ConnectionOpened()
+	m.ConnectionClosed()
+	m.ConnectionRejected("unauthorized")
+	m.MatchmakingRequest(1)
+	m.MatchmakingWaiting(0)
+	m.MatchCreated(1, time.Now())
+	m.MatchFailed()
+	m.SessionTransition("active", "finished")
+	m.MessageReceived("direct")
+	m.MessageSent()
+	m.SendFailed()
+	m.MessageDelivered(time.Now())
+	m.NotificationDelivered(time.Now())
+	m.DeliveryRetried()
+	m.DeliveryExpired()
+	m.WatchQueue("unused", "Unused.", func() int { return 0 })
+}
@@ -1,9 +1,45 @@
 package notification
 
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
 type SessionNotification struct {
-	SessionID           string `json:"session_id"`
-	Player1ConnectionID string `json:"player_1_connection_id"`
-	Player2ConnectionID string `json:"player_2_connection_id"`
+	SessionID string `json:"session_id"`
+	Player1ID string `json:"player_1_id"`
+	Player2ID string `json:"player_2_id"`
+	// PlayerIDs lists every player in slot order for sessions started from
+	// a lobby, which may have more than two
+	PlayerIDs []string  `json:"player_ids,omitempty"`
+	CreatedAt time.Time `json:"-"`
+}
+
+// MarshalJSON also writes the first two player IDs under the
+// player_1_connection_id and player_2_connection_id keys, which clients read
+// before sessions were made between players. Anonymous players' IDs are
+// their connection IDs, so those clients keep working.
+func (n SessionNotification) MarshalJSON() ([]byte, error) {
+	type notification SessionNotification
+	return json.Marshal(struct {
+		notification
+		Player1ConnectionID string `json:"player_1_connection_id"`
+		Player2ConnectionID string `json:"player_2_connection_id"`
+	}{notification(n), n.Player1ID, n.Player2ID})
+}
+
+// Players returns the players to notify, in slot order
+func (n SessionNotification) Players() []string {
+	if len(n.PlayerIDs) > 0 {
+		return n.PlayerIDs
+	}
+	return []string{n.Player1ID, n.Player2ID}
+}
+
+// HasPlayer reports whether playerID is to be notified
+func (n SessionNotification) HasPlayer(playerID string) bool {
+	return slices.Contains(n.Players(), playerID)
 }
 
 type Service struct {
@@ -1,6 +1,7 @@
 package notification
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -20,9 +21,9 @@ func TestNewNotificationService(t *testing.T) {
 	// We can send 100 messages without blocking
 	for i := 0; i < 100; i++ {
 		service.Channel <- SessionNotification{
-			SessionID:           "test",
-			Player1ConnectionID: "player1",
-			Player2ConnectionID: "player2",
+			SessionID: "test",
+			Player1ID: "player1",
+			Player2ID: "player2",
 		}
 	}
 
@@ -36,3 +37,33 @@ func TestNewNotificationService(t *testing.T) {
 		}
 	}
 }
+
+func TestSessionNotificationPlayers(t *testing.T) {
+	matched := SessionNotification{SessionID: "test", Player1ID: "player1", Player2ID: "player2"}
+	if players := matched.Players(); len(players) != 2 || !matched.HasPlayer("player2") || matched.HasPlayer("player3") {
+		t.Errorf("Expected the two matched players, got %v", players)
+	}
+
+	// Sessions started from a lobby list every player
+	lobby := SessionNotification{SessionID: "test", Player1ID: "player1", Player2ID: "player2", PlayerIDs: []string{"player1", "player2", "player3"}}
+	if players := lobby.Players(); len(players) != 3 || !lobby.HasPlayer("player3") {
+		t.Errorf("Expected every lobby player, got %v", players)
+	}
+}
+
+func TestSessionNotificationLegacyKeys(t *testing.T) {
+	data, err := json.Marshal(SessionNotification{SessionID: "test", Player1ID: "player1", Player2ID: "player2"})
+	if err != nil {
+		t.Fatalf("Error encoding notification: %v", err)
+	}
+	var keys map[string]interface{}
+	json.Unmarshal(data, &keys)
+	if keys["player_1_id"] != "player1" || keys["player_1_connection_id"] != "player1" || keys["player_2_connection_id"] != "player2" {
+		t.Errorf("Expected the player IDs under the new and the old keys, got %s", data)
+	}
+
+	var decoded SessionNotification
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Player2ID != "player2" {
+		t.Errorf("Expected the notification to decode, got %+v, %v", decoded, err)
+	}
+}
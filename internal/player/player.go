@@ -0,0 +1,36 @@
+package player
+
+import (
+	"fmt"
+)
+
+// Player is the stable identity behind one or more connections
+type Player struct {
+	ID          string            `json:"id"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// ConnectionPolicy decides what happens when a player opens another connection
+type ConnectionPolicy string
+
+const (
+	// PolicyAllow keeps every connection of the player open
+	PolicyAllow ConnectionPolicy = "allow"
+	// PolicyKickOld closes the player's existing connections
+	PolicyKickOld ConnectionPolicy = "kick-old"
+	// PolicyRejectNew refuses the new connection
+	PolicyRejectNew ConnectionPolicy = "reject-new"
+)
+
+// ParseConnectionPolicy validates a policy name, defaulting to PolicyAllow when empty
+func ParseConnectionPolicy(name string) (ConnectionPolicy, error) {
+	switch policy := ConnectionPolicy(name); policy {
+	case "":
+		return PolicyAllow, nil
+	case PolicyAllow, PolicyKickOld, PolicyRejectNew:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown connection policy %q", name)
+	}
+}
@@ -0,0 +1,27 @@
+package player
+
+import (
+	"testing"
+)
+
+func TestParseConnectionPolicy(t *testing.T) {
+	tests := map[string]ConnectionPolicy{
+		"":           PolicyAllow,
+		"allow":      PolicyAllow,
+		"kick-old":   PolicyKickOld,
+		"reject-new": PolicyRejectNew,
+	}
+	for name, expected := range tests {
+		policy, err := ParseConnectionPolicy(name)
+		if err != nil {
+			t.Errorf("Expected no error for '%s', got %v", name, err)
+		}
+		if policy != expected {
+			t.Errorf("Expected policy '%s' for '%s', got '%s'", expected, name, policy)
+		}
+	}
+
+	if _, err := ParseConnectionPolicy("bogus"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
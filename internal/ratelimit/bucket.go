@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket refilled continuously at a fixed rate
+type Bucket struct {
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewBucket creates a full bucket holding up to burst tokens refilled at rate per second
+func NewBucket(rate float64, burst int, now func() time.Time) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &Bucket{rate: rate, capacity: float64(burst), tokens: float64(burst), last: now(), now: now}
+}
+
+// Allow takes a token if one is available. A nil bucket allows everything.
+func (b *Bucket) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Options configures the limits. A zero rate or count disables that limit.
+type Options struct {
+	MessagesPerSecond      float64
+	MessageBurst           int
+	MatchmakingPerMinute   float64
+	IPMessagesPerSecond    float64
+	IPMatchmakingPerMinute float64
+	MaxConnectionsPerIP    int
+	// MaxViolations is how many rejected requests a connection may make
+	// before it is disconnected
+	MaxViolations int
+}
+
+// Limiter tracks per-IP state shared by all connections from the same address
+type Limiter struct {
+	opts  Options
+	ips   map[string]*ipState
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+type ipState struct {
+	connections int
+	messages    *Bucket
+	matchmaking *Bucket
+}
+
+// NewLimiter creates a limiter with the given options
+func NewLimiter(opts Options) *Limiter {
+	return &Limiter{opts: opts, ips: make(map[string]*ipState), now: time.Now}
+}
+
+// Connect reserves a connection slot for ip and returns the limiter for the
+// new connection, or false when ip already has too many connections
+func (l *Limiter) Connect(ip string) (*ConnectionLimiter, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	state, exists := l.ips[ip]
+	if !exists {
+		state = &ipState{
+			messages:    l.newBucket(l.opts.IPMessagesPerSecond, max(l.opts.MessageBurst, int(math.Ceil(l.opts.IPMessagesPerSecond)))),
+			matchmaking: l.newBucket(l.opts.IPMatchmakingPerMinute/60, int(math.Ceil(l.opts.IPMatchmakingPerMinute))),
+		}
+		l.ips[ip] = state
+	}
+	if l.opts.MaxConnectionsPerIP > 0 && state.connections >= l.opts.MaxConnectionsPerIP {
+		return nil, false
+	}
+	state.connections++
+
+	return &ConnectionLimiter{
+		limiter:       l,
+		ip:            ip,
+		ipState:       state,
+		messages:      l.newBucket(l.opts.MessagesPerSecond, l.opts.MessageBurst),
+		matchmaking:   l.newBucket(l.opts.MatchmakingPerMinute/60, int(math.Ceil(l.opts.MatchmakingPerMinute))),
+		maxViolations: l.opts.MaxViolations,
+	}, true
+}
+
+// Connections returns the number of open connections from ip
+func (l *Limiter) Connections(ip string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if state, exists := l.ips[ip]; exists {
+		return state.connections
+	}
+	return 0
+}
+
+func (l *Limiter) release(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	state, exists := l.ips[ip]
+	if !exists {
+		return
+	}
+	state.connections--
+	if state.connections <= 0 {
+		delete(l.ips, ip)
+	}
+}
+
+func (l *Limiter) newBucket(rate float64, burst int) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return NewBucket(rate, burst, l.now)
+}
+
+// ConnectionLimiter applies the per-connection and per-IP limits to one connection
+type ConnectionLimiter struct {
+	limiter       *Limiter
+	ip            string
+	ipState       *ipState
+	messages      *Bucket
+	matchmaking   *Bucket
+	maxViolations int
+	violations    int
+	released      sync.Once
+}
+
+// AllowMessage reports whether the connection may send another message
+func (c *ConnectionLimiter) AllowMessage() bool {
+	if c == nil {
+		return true
+	}
+	return c.messages.Allow() && c.ipState.messages.Allow()
+}
+
+// AllowMatchmaking reports whether the connection may send another matchmaking request
+func (c *ConnectionLimiter) AllowMatchmaking() bool {
+	if c == nil {
+		return true
+	}
+	return c.matchmaking.Allow() && c.ipState.matchmaking.Allow()
+}
+
+// Violation records a rejected request and reports whether the connection
+// has exceeded its allowed violations and should be disconnected
+func (c *ConnectionLimiter) Violation() bool {
+	if c == nil {
+		return false
+	}
+	c.violations++
+	return c.maxViolations > 0 && c.violations >= c.maxViolations
+}
+
+// Release frees the connection's slot for its IP. It is safe to call more than once.
+func (c *ConnectionLimiter) Release() {
+	if c == nil {
+		return
+	}
+	c.released.Do(func() {
+		c.limiter.release(c.ip)
+	})
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	current time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.current
+}
+
+func TestBucket(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(0, 0)}
+	bucket := NewBucket(2, 3, clock.now)
+
+	// The bucket starts full
+	for i := 0; i < 3; i++ {
+		if !bucket.Allow() {
+			t.Fatalf("Expected token %d to be available", i)
+		}
+	}
+	if bucket.Allow() {
+		t.Fatal("Expected bucket to be empty")
+	}
+
+	// Half a second refills one token at 2 tokens/sec
+	clock.current = clock.current.Add(500 * time.Millisecond)
+	if !bucket.Allow() {
+		t.Error("Expected a token after refill")
+	}
+	if bucket.Allow() {
+		t.Error("Expected only one token after refill")
+	}
+
+	// Refill never exceeds capacity
+	clock.current = clock.current.Add(time.Hour)
+	allowed := 0
+	for bucket.Allow() {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 3 tokens after long idle, got %d", allowed)
+	}
+}
+
+func TestLimiterConnectionsPerIP(t *testing.T) {
+	limiter := NewLimiter(Options{MaxConnectionsPerIP: 2})
+
+	first, ok := limiter.Connect("10.0.0.1")
+	if !ok {
+		t.Fatal("Expected first connection to be allowed")
+	}
+	if _, ok := limiter.Connect("10.0.0.1"); !ok {
+		t.Fatal("Expected second connection to be allowed")
+	}
+	if _, ok := limiter.Connect("10.0.0.1"); ok {
+		t.Error("Expected third connection to be rejected")
+	}
+	if _, ok := limiter.Connect("10.0.0.2"); !ok {
+		t.Error("Expected connection from another IP to be allowed")
+	}
+
+	// Releasing twice only frees one slot
+	first.Release()
+	first.Release()
+	if got := limiter.Connections("10.0.0.1"); got != 1 {
+		t.Errorf("Expected 1 connection after release, got %d", got)
+	}
+	if _, ok := limiter.Connect("10.0.0.1"); !ok {
+		t.Error("Expected connection to be allowed after release")
+	}
+}
+
+func TestConnectionLimiterRates(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(0, 0)}
+	limiter := NewLimiter(Options{
+		MessagesPerSecond:      1,
+		MessageBurst:           2,
+		MatchmakingPerMinute:   1,
+		IPMessagesPerSecond:    1,
+		IPMatchmakingPerMinute: 10,
+		MaxViolations:          2,
+	})
+	limiter.now = clock.now
+
+	conn1, _ := limiter.Connect("10.0.0.1")
+	conn2, _ := limiter.Connect("10.0.0.1")
+
+	// Per-connection message burst
+	if !conn1.AllowMessage() || !conn1.AllowMessage() {
+		t.Fatal("Expected burst of 2 messages to be allowed")
+	}
+	if conn1.AllowMessage() {
+		t.Error("Expected third message to be rejected")
+	}
+
+	// The IP bucket is shared, so the second connection is limited too
+	if conn2.AllowMessage() {
+		t.Error("Expected message from same IP to be rejected by the IP limit")
+	}
+
+	// One matchmaking request per minute per connection
+	if !conn1.AllowMatchmaking() {
+		t.Error("Expected first matchmaking request to be allowed")
+	}
+	if conn1.AllowMatchmaking() {
+		t.Error("Expected second matchmaking request to be rejected")
+	}
+	if !conn2.AllowMatchmaking() {
+		t.Error("Expected matchmaking request from another connection to be allowed")
+	}
+	clock.current = clock.current.Add(time.Minute)
+	if !conn1.AllowMatchmaking() {
+		t.Error("Expected matchmaking request to be allowed after a minute")
+	}
+
+	// Repeat offenders are disconnected
+	if conn1.Violation() {
+		t.Error("Expected first violation to be tolerated")
+	}
+	if !conn1.Violation() {
+		t.Error("Expected second violation to disconnect")
+	}
+}
+
+func TestNilConnectionLimiterAllowsEverything(t *testing.T) {
+	var limiter *ConnectionLimiter
+	if !limiter.AllowMessage() || !limiter.AllowMatchmaking() || limiter.Violation() {
+		t.Error("Expected nil limiter to allow everything")
+	}
+	limiter.Release()
+}
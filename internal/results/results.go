@@ -0,0 +1,240 @@
+// Package results ends sessions from the results reported by their players
+// or by the game engine, reconciling reports that disagree.
+//
+// A session finishes as soon as every one of its players reports the same
+// outcome or an authoritative result arrives. Otherwise it finishes at the
+// report deadline with the reports in by then: the only report wins as
+// uncontested, matching reports win as agreed, and reports that disagree
+// finish the session as disputed, without a winner.
+package results
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+var (
+	// ErrSessionNotFound is returned for results of unknown sessions
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionNotStarted is returned for results of sessions whose players haven't been told yet
+	ErrSessionNotStarted = errors.New("session hasn't started")
+	// ErrSessionFinished is returned for results of sessions that are already over
+	ErrSessionFinished = errors.New("session already finished")
+	// ErrNotPlayer is returned when someone outside a session reports its result
+	ErrNotPlayer = errors.New("only players can report the result of a session")
+	// ErrInvalidOutcome is returned for outcomes naming a winner outside the session
+	ErrInvalidOutcome = errors.New("invalid outcome")
+)
+
+// Store looks up sessions and their outcomes
+type Store interface {
+	GetSession(sessionID string) (matchmaking.Session, error)
+	// PlayerSessions returns the last limit finished sessions of a player, newest first
+	PlayerSessions(playerID string, limit int) ([]matchmaking.Session, error)
+}
+
+// pending holds the reports of a session that hasn't finished yet
+type pending struct {
+	session  matchmaking.Session
+	reports  map[string]message.Outcome // player ID -> reported outcome
+	deadline *time.Timer
+}
+
+// Service collects result reports and finishes sessions through their
+// lifecycle, whose subscribers learn the outcome
+type Service struct {
+	Logger *slog.Logger
+
+	store         Store
+	sessions      *matchmaking.Lifecycle
+	reportTimeout time.Duration
+
+	mutex   sync.Mutex
+	pending map[string]*pending // session ID -> reports so far
+}
+
+// NewService creates a result service reading sessions from store and
+// finishing them through sessions. Once a player reported, the other players
+// have reportTimeout to report too.
+func NewService(store Store, sessions *matchmaking.Lifecycle, reportTimeout time.Duration) *Service {
+	s := &Service{
+		Logger:        slog.Default(),
+		store:         store,
+		sessions:      sessions,
+		reportTimeout: reportTimeout,
+		pending:       make(map[string]*pending),
+	}
+	sessions.Subscribe(s.sessionChanged)
+	return s
+}
+
+func (s *Service) logger() *slog.Logger {
+	return logging.OrDefault(s.Logger)
+}
+
+// Report records the outcome a player claims and returns the report's
+// status, finishing the session once every player reported the same outcome
+func (s *Service) Report(sessionID, playerID string, outcome message.Outcome) (string, error) {
+	session, err := s.open(sessionID, outcome)
+	if err != nil {
+		return "", err
+	}
+	if !session.HasPlayer(playerID) {
+		return "", ErrNotPlayer
+	}
+
+	s.mutex.Lock()
+	p, exists := s.pending[sessionID]
+	if !exists {
+		p = &pending{session: session, reports: make(map[string]message.Outcome)}
+		p.deadline = time.AfterFunc(s.reportTimeout, func() { s.expire(p) })
+		s.pending[sessionID] = p
+	}
+	p.reports[playerID] = outcome
+	s.logger().Debug("result reported", slog.String(logging.KeySessionID, sessionID), slog.String(logging.KeyPlayerID, playerID))
+
+	if len(p.reports) < len(session.Players()) {
+		s.mutex.Unlock()
+		return message.ResultPending, nil
+	}
+	agreed, ok := agree(p.reports)
+	if !ok {
+		// The deadline decides unless an authoritative result comes first
+		s.mutex.Unlock()
+		s.logger().Info("players disagree on result", slog.String(logging.KeySessionID, sessionID))
+		return message.ResultDisputed, nil
+	}
+	s.forget(p)
+	s.mutex.Unlock()
+
+	agreed.Resolution = message.ResolutionAgreed
+	if _, err := s.finish(session, agreed); err != nil {
+		return "", err
+	}
+	return message.ResultAccepted, nil
+}
+
+// Resolve finishes a session with an authoritative outcome from the game
+// engine or an operator, overriding what the players reported
+func (s *Service) Resolve(sessionID string, outcome message.Outcome) (matchmaking.Session, error) {
+	session, err := s.open(sessionID, outcome)
+	if err != nil {
+		return matchmaking.Session{}, err
+	}
+
+	s.mutex.Lock()
+	if p, exists := s.pending[sessionID]; exists {
+		s.forget(p)
+	}
+	s.mutex.Unlock()
+
+	outcome.Resolution = message.ResolutionAuthoritative
+	return s.finish(session, outcome)
+}
+
+// History returns the last limit finished sessions of a player, newest first
+func (s *Service) History(playerID string, limit int) ([]matchmaking.Session, error) {
+	return s.store.PlayerSessions(playerID, limit)
+}
+
+// open returns a session that can still take outcome
+func (s *Service) open(sessionID string, outcome message.Outcome) (matchmaking.Session, error) {
+	session, err := s.store.GetSession(sessionID)
+	if err != nil {
+		return matchmaking.Session{}, ErrSessionNotFound
+	}
+	if session.Finished() {
+		return matchmaking.Session{}, ErrSessionFinished
+	}
+	if session.Status == matchmaking.StatusProposed {
+		return matchmaking.Session{}, ErrSessionNotStarted
+	}
+	if outcome.Draw == (outcome.WinnerID != "") || (outcome.WinnerID != "" && !session.HasPlayer(outcome.WinnerID)) {
+		return matchmaking.Session{}, ErrInvalidOutcome
+	}
+	return session, nil
+}
+
+// expire finishes a session whose report deadline passed
+func (s *Service) expire(p *pending) {
+	s.mutex.Lock()
+	if s.pending[p.session.SessionID] != p {
+		s.mutex.Unlock()
+		return
+	}
+	s.forget(p)
+	outcome, agreed := agree(p.reports)
+	s.mutex.Unlock()
+
+	switch {
+	case len(p.reports) == 1:
+		outcome.Resolution = message.ResolutionUncontested
+	case agreed:
+		outcome.Resolution = message.ResolutionAgreed
+	default:
+		outcome = message.Outcome{Resolution: message.ResolutionDisputed}
+	}
+	if _, err := s.finish(p.session, outcome); err != nil && !errors.Is(err, ErrSessionFinished) {
+		s.logger().Error("error finishing session", slog.String(logging.KeySessionID, p.session.SessionID), logging.Err(err))
+	}
+}
+
+// sessionChanged drops the reports of a session that ended otherwise, such
+// as one abandoned by an operator
+func (s *Service) sessionChanged(event matchmaking.SessionEvent) {
+	if !event.To.Over() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if p, exists := s.pending[event.Session.SessionID]; exists {
+		s.forget(p)
+	}
+}
+
+// forget drops the reports of a session; the caller holds the mutex
+func (s *Service) forget(p *pending) {
+	p.deadline.Stop()
+	delete(s.pending, p.session.SessionID)
+}
+
+// finish records the outcome of a session
+func (s *Service) finish(session matchmaking.Session, outcome message.Outcome) (matchmaking.Session, error) {
+	finished, err := s.sessions.Finish(session.SessionID, outcome)
+	if err != nil {
+		if errors.Is(err, db.ErrSessionFinished) || errors.Is(err, db.ErrIllegalTransition) {
+			return matchmaking.Session{}, ErrSessionFinished
+		}
+		return matchmaking.Session{}, fmt.Errorf("finishing session %s: %w", session.SessionID, err)
+	}
+	s.logger().Info("session finished",
+		slog.String(logging.KeySessionID, session.SessionID),
+		slog.String("winner_id", outcome.WinnerID),
+		slog.String("resolution", outcome.Resolution))
+	return finished, nil
+}
+
+// agree returns the outcome every report claims, if they all claim the same
+func agree(reports map[string]message.Outcome) (message.Outcome, bool) {
+	var agreed message.Outcome
+	first := true
+	for _, outcome := range reports {
+		if first {
+			agreed, first = outcome, false
+			continue
+		}
+		if outcome.WinnerID != agreed.WinnerID || outcome.Draw != agreed.Draw || !maps.Equal(outcome.Scores, agreed.Scores) {
+			return message.Outcome{}, false
+		}
+	}
+	return agreed, true
+}
@@ -0,0 +1,177 @@
+package results
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+// finished collects the sessions a lifecycle finishes
+type finished struct {
+	mutex    sync.Mutex
+	sessions []matchmaking.Session
+}
+
+func (f *finished) add(event matchmaking.SessionEvent) {
+	if event.To != matchmaking.StatusFinished {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sessions = append(f.sessions, event.Session)
+}
+
+func (f *finished) get() []matchmaking.Session {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]matchmaking.Session(nil), f.sessions...)
+}
+
+// wait waits for n sessions to finish
+func (f *finished) wait(t *testing.T, n int) []matchmaking.Session {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(f.get()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d finished sessions, got %+v", n, f.get())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return f.get()
+}
+
+// newTestService creates a result service over active sessions between alice and bob
+func newTestService(timeout time.Duration, sessionIDs ...string) (*Service, *finished) {
+	store := local.DB{}
+	lifecycle := matchmaking.NewLifecycle(store)
+	for _, sessionID := range sessionIDs {
+		lifecycle.Create(sessionID, matchmaking.ModeMatchmaking, []string{"alice", "bob"})
+		lifecycle.Transition(sessionID, matchmaking.StatusActive)
+	}
+	var f finished
+	lifecycle.Subscribe(f.add)
+	return NewService(store, lifecycle, timeout), &f
+}
+
+func TestReportAgreed(t *testing.T) {
+	s, f := newTestService(time.Minute, "results-agreed")
+	win := message.Outcome{WinnerID: "alice", Scores: map[string]int{"alice": 3, "bob": 1}}
+
+	if _, err := s.Report("results-missing", "alice", win); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	s.sessions.Create("results-proposed", matchmaking.ModeMatchmaking, []string{"alice", "bob"})
+	if _, err := s.Report("results-proposed", "alice", win); !errors.Is(err, ErrSessionNotStarted) {
+		t.Errorf("Expected ErrSessionNotStarted, got %v", err)
+	}
+	if _, err := s.Report("results-agreed", "carol", win); !errors.Is(err, ErrNotPlayer) {
+		t.Errorf("Expected ErrNotPlayer, got %v", err)
+	}
+	if _, err := s.Report("results-agreed", "alice", message.Outcome{WinnerID: "carol"}); !errors.Is(err, ErrInvalidOutcome) {
+		t.Errorf("Expected ErrInvalidOutcome, got %v", err)
+	}
+
+	if status, err := s.Report("results-agreed", "alice", win); err != nil || status != message.ResultPending {
+		t.Fatalf("Expected the first report to be pending, got %q %v", status, err)
+	}
+	if status, err := s.Report("results-agreed", "bob", win); err != nil || status != message.ResultAccepted {
+		t.Fatalf("Expected the matching report to be accepted, got %q %v", status, err)
+	}
+
+	sessions := f.get()
+	if len(sessions) != 1 || sessions[0].Status != matchmaking.StatusFinished || sessions[0].Outcome.WinnerID != "alice" || sessions[0].Outcome.Resolution != message.ResolutionAgreed || sessions[0].EndedAt == nil {
+		t.Fatalf("Unexpected finished sessions %+v", sessions)
+	}
+	if _, err := s.Report("results-agreed", "bob", win); !errors.Is(err, ErrSessionFinished) {
+		t.Errorf("Expected ErrSessionFinished, got %v", err)
+	}
+
+	history, err := s.History("bob", 10)
+	if err != nil || len(history) != 1 || history[0].SessionID != "results-agreed" {
+		t.Errorf("Expected the session in bob's history, got %+v %v", history, err)
+	}
+}
+
+func TestReportDeadline(t *testing.T) {
+	s, f := newTestService(20*time.Millisecond, "results-uncontested", "results-disputed")
+
+	s.Report("results-uncontested", "bob", message.Outcome{Draw: true})
+	s.Report("results-disputed", "alice", message.Outcome{WinnerID: "alice"})
+	if status, _ := s.Report("results-disputed", "bob", message.Outcome{WinnerID: "bob"}); status != message.ResultDisputed {
+		t.Errorf("Expected conflicting reports to be disputed, got %q", status)
+	}
+
+	outcomes := make(map[string]message.Outcome)
+	for _, session := range f.wait(t, 2) {
+		outcomes[session.SessionID] = *session.Outcome
+	}
+	if o := outcomes["results-uncontested"]; !o.Draw || o.Resolution != message.ResolutionUncontested {
+		t.Errorf("Expected an uncontested draw, got %+v", o)
+	}
+	if o := outcomes["results-disputed"]; o.WinnerID != "" || o.Resolution != message.ResolutionDisputed {
+		t.Errorf("Expected a disputed outcome without winner, got %+v", o)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s, f := newTestService(20*time.Millisecond, "results-resolved")
+
+	s.Report("results-resolved", "alice", message.Outcome{WinnerID: "alice"})
+	s.Report("results-resolved", "bob", message.Outcome{WinnerID: "bob"})
+	session, err := s.Resolve("results-resolved", message.Outcome{WinnerID: "bob"})
+	if err != nil || session.Outcome.WinnerID != "bob" || session.Outcome.Resolution != message.ResolutionAuthoritative {
+		t.Fatalf("Unexpected resolved session %+v %v", session, err)
+	}
+	if _, err := s.Resolve("results-resolved", message.Outcome{Draw: true}); !errors.Is(err, ErrSessionFinished) {
+		t.Errorf("Expected ErrSessionFinished, got %v", err)
+	}
+
+	// The pending deadline doesn't finish the session again
+	time.Sleep(50 * time.Millisecond)
+	if sessions := f.get(); len(sessions) != 1 {
+		t.Errorf("Expected the session to finish once, got %+v", sessions)
+	}
+}
+
+func TestReportEveryPlayer(t *testing.T) {
+	s, f := newTestService(time.Minute)
+	s.sessions.Create("results-lobby", matchmaking.ModeLobby, []string{"gina", "hank", "ivan"})
+	s.sessions.Transition("results-lobby", matchmaking.StatusActive)
+	win := message.Outcome{WinnerID: "ivan"}
+
+	// Two matching reports don't settle a session of three
+	for _, playerID := range []string{"gina", "hank"} {
+		if status, err := s.Report("results-lobby", playerID, win); err != nil || status != message.ResultPending {
+			t.Fatalf("Expected %s's report to be pending, got %q %v", playerID, status, err)
+		}
+	}
+	if sessions := f.get(); len(sessions) != 0 {
+		t.Fatalf("Expected the session to wait for ivan, got %+v", sessions)
+	}
+	if status, err := s.Report("results-lobby", "ivan", win); err != nil || status != message.ResultAccepted {
+		t.Fatalf("Expected the last report to be accepted, got %q %v", status, err)
+	}
+	if sessions := f.get(); len(sessions) != 1 || sessions[0].Outcome.WinnerID != "ivan" || sessions[0].Outcome.Resolution != message.ResolutionAgreed {
+		t.Errorf("Unexpected finished sessions %+v", sessions)
+	}
+}
+
+func TestAbandonDropsReports(t *testing.T) {
+	s, _ := newTestService(time.Minute, "results-abandoned")
+
+	s.Report("results-abandoned", "alice", message.Outcome{WinnerID: "alice"})
+	if _, err := s.sessions.Abandon("results-abandoned", nil); err != nil {
+		t.Fatalf("Error abandoning session: %v", err)
+	}
+	s.mutex.Lock()
+	_, exists := s.pending["results-abandoned"]
+	s.mutex.Unlock()
+	if exists {
+		t.Error("Expected the reports of the abandoned session to be dropped")
+	}
+}
@@ -0,0 +1,150 @@
+package room
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+// ErrNotMember is returned when a connection acts on a room it hasn't joined
+var ErrNotMember = errors.New("not a member of the room")
+
+// room holds the members of one room, keyed by connection ID, and its recent messages
+type room struct {
+	members map[string]message.RoomMember
+	history []message.RoomMessage
+}
+
+// Manager tracks room membership and history. Rooms are created on first
+// join and removed, along with their history, when the last member leaves.
+type Manager struct {
+	mutex       sync.Mutex
+	rooms       map[string]*room
+	historySize int
+}
+
+// NewManager creates a room manager keeping the last historySize messages of
+// each room for late joiners; zero disables history
+func NewManager(historySize int) *Manager {
+	return &Manager{rooms: make(map[string]*room), historySize: historySize}
+}
+
+// Join adds member to a room and returns the members, including the new one,
+// and the room's history. joined is false when the connection was already a member.
+func (m *Manager) Join(name string, member message.RoomMember) (members []message.RoomMember, history []message.RoomMessage, joined bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, exists := m.rooms[name]
+	if !exists {
+		r = &room{members: make(map[string]message.RoomMember)}
+		m.rooms[name] = r
+	}
+	_, already := r.members[member.ConnectionID]
+	r.members[member.ConnectionID] = member
+
+	history = make([]message.RoomMessage, len(r.history))
+	copy(history, r.history)
+	return r.memberList(), history, !already
+}
+
+// Leave removes a connection from a room and returns the remaining members
+func (m *Manager) Leave(name, connectionID string) ([]message.RoomMember, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, exists := m.rooms[name]
+	if !exists {
+		return nil, ErrNotMember
+	}
+	if _, ok := r.members[connectionID]; !ok {
+		return nil, ErrNotMember
+	}
+	delete(r.members, connectionID)
+	if len(r.members) == 0 {
+		delete(m.rooms, name)
+	}
+	return r.memberList(), nil
+}
+
+// LeaveAll removes a connection from every room it joined and returns the
+// remaining members of each, keyed by room name
+func (m *Manager) LeaveAll(connectionID string) map[string][]message.RoomMember {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	left := make(map[string][]message.RoomMember)
+	for name, r := range m.rooms {
+		if _, ok := r.members[connectionID]; !ok {
+			continue
+		}
+		delete(r.members, connectionID)
+		if len(r.members) == 0 {
+			delete(m.rooms, name)
+		}
+		left[name] = r.memberList()
+	}
+	return left
+}
+
+// Members returns the members of a room, empty if it doesn't exist
+func (m *Manager) Members(name string) []message.RoomMember {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, exists := m.rooms[name]
+	if !exists {
+		return []message.RoomMember{}
+	}
+	return r.memberList()
+}
+
+// Post records msg in the history of msg.Room and returns the members it
+// must be delivered to. The sender must be a member.
+func (m *Manager) Post(msg message.RoomMessage) ([]message.RoomMember, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, exists := m.rooms[msg.Room]
+	if !exists {
+		return nil, ErrNotMember
+	}
+	if _, ok := r.members[msg.From.ConnectionID]; !ok {
+		return nil, ErrNotMember
+	}
+
+	if m.historySize > 0 {
+		r.history = append(r.history, msg)
+		if len(r.history) > m.historySize {
+			r.history = r.history[len(r.history)-m.historySize:]
+		}
+	}
+	return r.memberList(), nil
+}
+
+// Rooms returns the names of all rooms with members
+func (m *Manager) Rooms() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := make([]string, 0, len(m.rooms))
+	for name := range m.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// memberList returns the members ordered by connection ID
+func (r *room) memberList() []message.RoomMember {
+	members := make([]message.RoomMember, 0, len(r.members))
+	for _, member := range r.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ConnectionID < members[j].ConnectionID
+	})
+	return members
+}
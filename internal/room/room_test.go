@@ -0,0 +1,92 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"simple-multiplayer-service/internal/message"
+)
+
+func member(id string) message.RoomMember {
+	return message.RoomMember{ConnectionID: id, PlayerID: "player-" + id}
+}
+
+func TestJoinAndLeave(t *testing.T) {
+	m := NewManager(0)
+
+	members, _, joined := m.Join("lobby", member("a"))
+	if !joined || len(members) != 1 {
+		t.Fatalf("Expected a to join an empty lobby, got joined=%v members=%v", joined, members)
+	}
+	members, _, _ = m.Join("lobby", member("b"))
+	if len(members) != 2 {
+		t.Errorf("Expected 2 members, got %d", len(members))
+	}
+	if _, _, joined := m.Join("lobby", member("a")); joined {
+		t.Error("Expected joining twice to report joined=false")
+	}
+
+	remaining, err := m.Leave("lobby", "a")
+	if err != nil || len(remaining) != 1 || remaining[0].ConnectionID != "b" {
+		t.Errorf("Expected b to remain, got %v, %v", remaining, err)
+	}
+	if _, err := m.Leave("lobby", "a"); !errors.Is(err, ErrNotMember) {
+		t.Errorf("Expected ErrNotMember leaving twice, got %v", err)
+	}
+
+	// The last member leaving removes the room
+	m.Leave("lobby", "b")
+	if rooms := m.Rooms(); len(rooms) != 0 {
+		t.Errorf("Expected no rooms, got %v", rooms)
+	}
+}
+
+func TestLeaveAll(t *testing.T) {
+	m := NewManager(0)
+	m.Join("lobby", member("a"))
+	m.Join("lobby", member("b"))
+	m.Join("team", member("a"))
+
+	left := m.LeaveAll("a")
+	if len(left) != 2 {
+		t.Fatalf("Expected a to leave 2 rooms, got %v", left)
+	}
+	if len(left["lobby"]) != 1 || len(left["team"]) != 0 {
+		t.Errorf("Unexpected remaining members %v", left)
+	}
+	if rooms := m.Rooms(); len(rooms) != 1 || rooms[0] != "lobby" {
+		t.Errorf("Expected only the lobby to remain, got %v", rooms)
+	}
+}
+
+func TestPostKeepsLastMessages(t *testing.T) {
+	m := NewManager(2)
+	m.Join("lobby", member("a"))
+
+	if _, err := m.Post(message.RoomMessage{Room: "lobby", From: member("b"), Text: "hi"}); !errors.Is(err, ErrNotMember) {
+		t.Errorf("Expected ErrNotMember posting without joining, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		members, err := m.Post(message.RoomMessage{Room: "lobby", From: member("a"), Text: fmt.Sprint(i)})
+		if err != nil || len(members) != 1 {
+			t.Fatalf("Expected post to reach 1 member, got %v, %v", members, err)
+		}
+	}
+
+	_, history, _ := m.Join("lobby", member("b"))
+	if len(history) != 2 || history[0].Text != "1" || history[1].Text != "2" {
+		t.Errorf("Expected the last 2 messages, got %v", history)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	m := NewManager(0)
+	m.Join("lobby", member("a"))
+	m.Post(message.RoomMessage{Room: "lobby", From: member("a"), Text: "hi"})
+
+	if _, history, _ := m.Join("lobby", member("b")); len(history) != 0 {
+		t.Errorf("Expected no history, got %v", history)
+	}
+}
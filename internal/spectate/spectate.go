@@ -0,0 +1,317 @@
+// Package spectate tracks the clients watching sessions and relays the
+// messages exchanged within a session to them, optionally delayed so
+// spectators can't feed the players what their opponent is doing.
+package spectate
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+var (
+	// ErrSessionNotFound is returned when spectating a session that doesn't exist
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionFull is returned when a session has its maximum of spectators
+	ErrSessionFull = errors.New("session has the maximum number of spectators")
+	// ErrPlayer is returned when a player of a session asks to spectate it
+	ErrPlayer = errors.New("players can't spectate their own session")
+	// ErrSessionFinished is returned when spectating a session that already has an outcome
+	ErrSessionFinished = errors.New("session already finished")
+	// ErrNotSpectating is returned when a connection leaves a session it isn't watching
+	ErrNotSpectating = errors.New("not spectating the session")
+)
+
+// feedSize is how many delayed messages a session holds before dropping new ones
+const feedSize = 256
+
+// Sessions looks up the sessions that can be spectated
+type Sessions interface {
+	GetSession(sessionID string) (matchmaking.Session, error)
+}
+
+// feedItem is a message due to spectators at a given time. An item without
+// a message ends the session's spectating.
+type feedItem struct {
+	due time.Time
+	msg *message.Message
+}
+
+// watched is a session with spectators
+type watched struct {
+	session    matchmaking.Session
+	spectators map[string]message.Spectator // connection ID -> spectator
+	feed       chan feedItem                // nil without a delay
+	done       chan struct{}                // closed when the feed stops
+}
+
+// Manager tracks spectators. Each connection watches at most one session.
+type Manager struct {
+	// DeliverFunc writes a message to spectators; it is called for every
+	// relayed message, and with the stopped notice when spectating ends
+	DeliverFunc func(spectators []message.Spectator, msg message.Message)
+
+	mutex         sync.Mutex
+	sessions      Sessions
+	maxPerSession int
+	delay         time.Duration
+	watched       map[string]*watched // session ID -> session
+	watching      map[string]string   // connection ID -> session ID
+}
+
+// NewManager creates a spectator manager looking sessions up in sessions.
+// Sessions take at most maxPerSession spectators, zero for no limit, and
+// messages reach spectators delay after they were sent.
+func NewManager(sessions Sessions, maxPerSession int, delay time.Duration) *Manager {
+	return &Manager{
+		sessions:      sessions,
+		maxPerSession: maxPerSession,
+		delay:         delay,
+		watched:       make(map[string]*watched),
+		watching:      make(map[string]string),
+	}
+}
+
+// Delay is how long after being sent messages reach spectators
+func (m *Manager) Delay() time.Duration {
+	return m.delay
+}
+
+// Watch makes spectator watch a session, leaving the session it watched
+// before, and returns the session and its spectators including the new one
+func (m *Manager) Watch(sessionID string, spectator message.Spectator) (matchmaking.Session, []message.Spectator, error) {
+	session, err := m.sessions.GetSession(sessionID)
+	if err != nil {
+		return matchmaking.Session{}, nil, ErrSessionNotFound
+	}
+	if session.Finished() {
+		return matchmaking.Session{}, nil, ErrSessionFinished
+	}
+	if session.HasPlayer(spectator.PlayerID) {
+		return matchmaking.Session{}, nil, ErrPlayer
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w, exists := m.watched[sessionID]
+	if exists {
+		if _, already := w.spectators[spectator.ConnectionID]; already {
+			return w.session, w.list(), nil
+		}
+	}
+	if exists && m.maxPerSession > 0 && len(w.spectators) >= m.maxPerSession {
+		return matchmaking.Session{}, nil, ErrSessionFull
+	}
+	if previous, ok := m.watching[spectator.ConnectionID]; ok {
+		m.remove(previous, spectator.ConnectionID)
+	}
+
+	if !exists {
+		w = &watched{session: session, spectators: make(map[string]message.Spectator), done: make(chan struct{})}
+		if m.delay > 0 {
+			w.feed = make(chan feedItem, feedSize)
+			go m.run(w)
+		}
+		m.watched[sessionID] = w
+	}
+	w.spectators[spectator.ConnectionID] = spectator
+	m.watching[spectator.ConnectionID] = sessionID
+	return w.session, w.list(), nil
+}
+
+// Leave stops a connection watching a session
+func (m *Manager) Leave(sessionID, connectionID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.watching[connectionID] != sessionID {
+		return ErrNotSpectating
+	}
+	m.remove(sessionID, connectionID)
+	return nil
+}
+
+// LeaveAll stops a closed connection watching its session, if any
+func (m *Manager) LeaveAll(connectionID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if sessionID, ok := m.watching[connectionID]; ok {
+		m.remove(sessionID, connectionID)
+	}
+}
+
+// Watching returns the session a connection watches
+func (m *Manager) Watching(connectionID string) (matchmaking.Session, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sessionID, ok := m.watching[connectionID]
+	if !ok {
+		return matchmaking.Session{}, false
+	}
+	return m.watched[sessionID].session, true
+}
+
+// Spectators returns the spectators of a session, empty if it has none
+func (m *Manager) Spectators(sessionID string) []message.Spectator {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w, exists := m.watched[sessionID]
+	if !exists {
+		return []message.Spectator{}
+	}
+	return w.list()
+}
+
+// SessionBetween returns the watched session two players are both playing,
+// so messages between them can be relayed
+func (m *Manager) SessionBetween(playerID1, playerID2 string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for sessionID, w := range m.watched {
+		if playerID1 != playerID2 && w.session.HasPlayer(playerID1) && w.session.HasPlayer(playerID2) {
+			return sessionID, true
+		}
+	}
+	return "", false
+}
+
+// Publish relays msg to the spectators of a session after the delay.
+// Sessions without spectators are skipped.
+func (m *Manager) Publish(sessionID string, msg message.Message) {
+	m.mutex.Lock()
+	w, exists := m.watched[sessionID]
+	if !exists {
+		m.mutex.Unlock()
+		return
+	}
+	if w.feed == nil {
+		spectators := w.list()
+		m.mutex.Unlock()
+		m.deliver(spectators, msg)
+		return
+	}
+	select {
+	case w.feed <- feedItem{due: time.Now().Add(m.delay), msg: &msg}:
+	default:
+		// A spectator stream missing a message beats blocking the players
+	}
+	m.mutex.Unlock()
+}
+
+// End stops relaying a session once its pending messages have been
+// delivered, and tells its spectators why
+func (m *Manager) End(sessionID, reason string) {
+	m.mutex.Lock()
+	w, exists := m.watched[sessionID]
+	if !exists {
+		m.mutex.Unlock()
+		return
+	}
+	stopped := message.NewServerMessage("", message.SpectatingStopped{
+		Type:      message.SpectatingStoppedType,
+		SessionID: sessionID,
+		Reason:    reason,
+	})
+	if w.feed == nil {
+		spectators := w.list()
+		m.removeSession(w)
+		m.mutex.Unlock()
+		m.deliver(spectators, stopped)
+		return
+	}
+
+	// The notice and the end marker wait behind the pending messages
+	select {
+	case w.feed <- feedItem{due: time.Now().Add(m.delay), msg: &stopped}:
+	default:
+	}
+	select {
+	case w.feed <- feedItem{due: time.Now().Add(m.delay)}:
+	default:
+		m.removeSession(w)
+	}
+	m.mutex.Unlock()
+}
+
+// run delivers a delayed session's messages when they are due, until the
+// session has no spectators left or ends
+func (m *Manager) run(w *watched) {
+	for {
+		select {
+		case item := <-w.feed:
+			if wait := time.Until(item.due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-w.done:
+					timer.Stop()
+					return
+				}
+			}
+			m.mutex.Lock()
+			if item.msg == nil {
+				m.removeSession(w)
+				m.mutex.Unlock()
+				return
+			}
+			spectators := w.list()
+			m.mutex.Unlock()
+			m.deliver(spectators, *item.msg)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// remove takes a connection off a session, dropping the session once nobody watches it
+func (m *Manager) remove(sessionID, connectionID string) {
+	delete(m.watching, connectionID)
+	w, exists := m.watched[sessionID]
+	if !exists {
+		return
+	}
+	delete(w.spectators, connectionID)
+	if len(w.spectators) == 0 {
+		m.removeSession(w)
+	}
+}
+
+// removeSession forgets a session and its spectators, unless it was already
+// replaced by a later one with the same ID
+func (m *Manager) removeSession(w *watched) {
+	if m.watched[w.session.SessionID] != w {
+		return
+	}
+	for connectionID := range w.spectators {
+		delete(m.watching, connectionID)
+	}
+	delete(m.watched, w.session.SessionID)
+	close(w.done)
+}
+
+func (m *Manager) deliver(spectators []message.Spectator, msg message.Message) {
+	if m.DeliverFunc != nil && len(spectators) > 0 {
+		m.DeliverFunc(spectators, msg)
+	}
+}
+
+// list returns the spectators ordered by connection ID
+func (w *watched) list() []message.Spectator {
+	spectators := make([]message.Spectator, 0, len(w.spectators))
+	for _, spectator := range w.spectators {
+		spectators = append(spectators, spectator)
+	}
+	sort.Slice(spectators, func(i, j int) bool {
+		return spectators[i].ConnectionID < spectators[j].ConnectionID
+	})
+	return spectators
+}
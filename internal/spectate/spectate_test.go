@@ -0,0 +1,180 @@
+package spectate
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+type testSessions map[string]matchmaking.Session
+
+func (s testSessions) GetSession(sessionID string) (matchmaking.Session, error) {
+	session, ok := s[sessionID]
+	if !ok {
+		return matchmaking.Session{}, errors.New("not found")
+	}
+	return session, nil
+}
+
+var sessions = testSessions{
+	"session1": {SessionID: "session1", Player1ID: "alice", Player2ID: "bob"},
+	"session2": {SessionID: "session2", Player1ID: "carol", Player2ID: "dave"},
+	"session3": {SessionID: "session3", Player1ID: "erin", Player2ID: "frank", EndedAt: &time.Time{}},
+	"session4": matchmaking.NewSession("session4", matchmaking.ModeLobby, []string{"gina", "hank", "ivan"}, time.Time{}),
+}
+
+// recorder collects what a manager delivers
+type recorder struct {
+	mutex      sync.Mutex
+	deliveries []delivery
+}
+
+type delivery struct {
+	spectators []message.Spectator
+	msg        message.Message
+	at         time.Time
+}
+
+func (r *recorder) deliver(spectators []message.Spectator, msg message.Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.deliveries = append(r.deliveries, delivery{spectators, msg, time.Now()})
+}
+
+func (r *recorder) get() []delivery {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+func spectatorOf(connectionID string) message.Spectator {
+	return message.Spectator{ConnectionID: connectionID, PlayerID: "player-" + connectionID}
+}
+
+func TestWatch(t *testing.T) {
+	m := NewManager(sessions, 2, 0)
+
+	if _, _, err := m.Watch("missing", spectatorOf("c1")); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if _, _, err := m.Watch("session3", spectatorOf("c1")); !errors.Is(err, ErrSessionFinished) {
+		t.Errorf("Expected ErrSessionFinished, got %v", err)
+	}
+	if _, _, err := m.Watch("session1", message.Spectator{ConnectionID: "c0", PlayerID: "alice"}); !errors.Is(err, ErrPlayer) {
+		t.Errorf("Expected players to be refused, got %v", err)
+	}
+
+	session, spectators, err := m.Watch("session1", spectatorOf("c1"))
+	if err != nil || session.Player1ID != "alice" || len(spectators) != 1 {
+		t.Fatalf("Unexpected watch %+v %v %v", session, spectators, err)
+	}
+	m.Watch("session1", spectatorOf("c2"))
+	if _, _, err := m.Watch("session1", spectatorOf("c3")); !errors.Is(err, ErrSessionFull) {
+		t.Errorf("Expected the third spectator to be refused, got %v", err)
+	}
+	// Watching again isn't refused by the limit
+	if _, spectators, err := m.Watch("session1", spectatorOf("c2")); err != nil || len(spectators) != 2 {
+		t.Errorf("Expected watching twice to be harmless, got %v %v", spectators, err)
+	}
+
+	// Watching another session leaves the first
+	m.Watch("session2", spectatorOf("c2"))
+	if watching, _ := m.Watching("c2"); watching.SessionID != "session2" {
+		t.Errorf("Expected c2 to watch session2, got %+v", watching)
+	}
+	if spectators := m.Spectators("session1"); len(spectators) != 1 || spectators[0].ConnectionID != "c1" {
+		t.Errorf("Expected only c1 on session1, got %+v", spectators)
+	}
+
+	if err := m.Leave("session2", "c1"); !errors.Is(err, ErrNotSpectating) {
+		t.Errorf("Expected ErrNotSpectating, got %v", err)
+	}
+	m.Leave("session1", "c1")
+	m.LeaveAll("c2")
+	if _, watching := m.Watching("c2"); watching {
+		t.Error("Expected c2 to watch nothing")
+	}
+	if len(m.Spectators("session1"))+len(m.Spectators("session2")) != 0 {
+		t.Error("Expected no spectators left")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	m := NewManager(sessions, 0, 0)
+	var r recorder
+	m.DeliverFunc = r.deliver
+
+	m.Publish("session1", message.Message{Content: "unwatched"})
+	m.Watch("session1", spectatorOf("c1"))
+	if sessionID, ok := m.SessionBetween("bob", "alice"); !ok || sessionID != "session1" {
+		t.Errorf("Expected bob and alice to play session1, got %q", sessionID)
+	}
+	if _, ok := m.SessionBetween("alice", "carol"); ok {
+		t.Error("Expected alice and carol to share no session")
+	}
+	m.Watch("session4", spectatorOf("c2"))
+	if sessionID, ok := m.SessionBetween("ivan", "gina"); !ok || sessionID != "session4" {
+		t.Errorf("Expected the third player of a lobby session to be found, got %q", sessionID)
+	}
+
+	m.Publish("session1", message.Message{Content: "move"})
+	m.End("session1", "finished")
+	deliveries := r.get()
+	if len(deliveries) != 2 || deliveries[0].msg.Content != "move" || deliveries[0].spectators[0].ConnectionID != "c1" {
+		t.Fatalf("Expected the move then the stop notice, got %+v", deliveries)
+	}
+	if message.ContentType(deliveries[1].msg.Content) != message.SpectatingStoppedType {
+		t.Errorf("Expected a stop notice, got %s", deliveries[1].msg.Content)
+	}
+	if _, watching := m.Watching("c1"); watching {
+		t.Error("Expected the ended session to have no spectators")
+	}
+}
+
+func TestPublishDelayed(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	m := NewManager(sessions, 0, delay)
+	var r recorder
+	m.DeliverFunc = r.deliver
+	m.Watch("session1", spectatorOf("c1"))
+
+	sent := time.Now()
+	for _, content := range []string{"1", "2", "3"} {
+		m.Publish("session1", message.Message{Content: content})
+	}
+	m.End("session1", "finished")
+	if len(r.get()) != 0 {
+		t.Fatal("Expected nothing before the delay")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(r.get()) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 4 deliveries, got %+v", r.get())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i, d := range r.get() {
+		if d.at.Sub(sent) < delay {
+			t.Errorf("Delivery %d came %v after sending, before the delay", i, d.at.Sub(sent))
+		}
+		if i < 3 && d.msg.Content != []string{"1", "2", "3"}[i] {
+			t.Errorf("Expected delivery %d to be in order, got %q", i, d.msg.Content)
+		}
+	}
+
+	// The session is forgotten once the end marker is reached
+	for {
+		if _, watching := m.Watching("c1"); !watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the session to be forgotten after ending")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
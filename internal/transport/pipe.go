@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// pipeBuffer is how many messages an end holds before writes to it block
+const pipeBuffer = 64
+
+// pipeAddr is the address of both ends of a pipe
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeFrame is a message, or a close frame when closeErr is set
+type pipeFrame struct {
+	messageType int
+	data        []byte
+	closeErr    *websocket.CloseError
+}
+
+// PipeConn is one end of an in-memory connection created by Pipe
+type PipeConn struct {
+	incoming  chan pipeFrame
+	peer      *PipeConn
+	closed    chan struct{}
+	closeOnce sync.Once
+	readLimit atomic.Int64
+	// readErr fails every read after the first failed one, as on a websocket
+	readErr error
+}
+
+// Pipe creates an in-memory connection and returns its two ends, e.g. one
+// for a client under test and one for the test to play the remote side.
+// Messages are buffered, so a write only blocks when its peer falls behind.
+func Pipe() (*PipeConn, *PipeConn) {
+	a := &PipeConn{incoming: make(chan pipeFrame, pipeBuffer), closed: make(chan struct{})}
+	b := &PipeConn{incoming: make(chan pipeFrame, pipeBuffer), closed: make(chan struct{})}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+// ReadMessage returns the next message written by the peer. Once the peer
+// closed its end, it returns the close error the peer sent with WriteClose,
+// or CloseAbnormalClosure when it sent none.
+func (c *PipeConn) ReadMessage() (int, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	frame, err := c.next()
+	switch {
+	case err != nil:
+		c.readErr = err
+	case frame.closeErr != nil:
+		c.readErr = frame.closeErr
+	case c.readLimit.Load() > 0 && int64(len(frame.data)) > c.readLimit.Load():
+		// Websockets refuse the frame and tell the peer why
+		_ = c.WriteClose(websocket.CloseMessageTooBig, "")
+		c.readErr = websocket.ErrReadLimit
+	default:
+		return frame.messageType, frame.data, nil
+	}
+	return 0, nil, c.readErr
+}
+
+func (c *PipeConn) next() (pipeFrame, error) {
+	select {
+	case <-c.closed:
+		return pipeFrame{}, net.ErrClosed
+	default:
+	}
+	// Messages written before the peer closed are still delivered
+	select {
+	case frame := <-c.incoming:
+		return frame, nil
+	default:
+	}
+	select {
+	case frame := <-c.incoming:
+		return frame, nil
+	case <-c.closed:
+		return pipeFrame{}, net.ErrClosed
+	case <-c.peer.closed:
+		select {
+		case frame := <-c.incoming:
+			return frame, nil
+		default:
+			return pipeFrame{}, &websocket.CloseError{Code: websocket.CloseAbnormalClosure, Text: io.ErrUnexpectedEOF.Error()}
+		}
+	}
+}
+
+// WriteMessage sends a copy of data to the peer
+func (c *PipeConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("transport: unsupported message type")
+	}
+	return c.send(pipeFrame{messageType: messageType, data: append([]byte(nil), data...)})
+}
+
+// WriteClose sends the peer a close frame; its reads fail with a
+// *websocket.CloseError carrying code and reason
+func (c *PipeConn) WriteClose(code int, reason string) error {
+	return c.send(pipeFrame{closeErr: &websocket.CloseError{Code: code, Text: reason}})
+}
+
+func (c *PipeConn) send(frame pipeFrame) error {
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	case <-c.peer.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case c.peer.incoming <- frame:
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	case <-c.peer.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// SetReadLimit makes reads of messages over limit bytes fail, closing the
+// pipe with CloseMessageTooBig
+func (c *PipeConn) SetReadLimit(limit int64) {
+	c.readLimit.Store(limit)
+}
+
+// Close closes this end. Reads on the peer fail once they have drained the
+// messages already written.
+func (c *PipeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// RemoteAddr returns an address naming the pipe
+func (c *PipeConn) RemoteAddr() net.Addr {
+	return pipeAddr{}
+}
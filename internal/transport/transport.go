@@ -0,0 +1,76 @@
+// Package transport abstracts the connections clients talk over, so clients
+// don't depend on websockets. Message types and close codes follow the
+// websocket protocol, which the other transports mimic.
+package transport
+
+import (
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message types of frames, as in the websocket protocol
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+// maxCloseReason is the longest reason a websocket close frame can carry
+const maxCloseReason = 123
+
+// Conn is a connection exchanging whole messages with a client.
+// ReadMessage returns a *websocket.CloseError once the peer closed the
+// connection, with the code it gave or CloseAbnormalClosure. A single
+// goroutine may read and a single one may write at a time.
+type Conn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+	RemoteAddr() net.Addr
+}
+
+// CloseWriter is implemented by connections able to tell the peer why they
+// are being closed
+type CloseWriter interface {
+	WriteClose(code int, reason string) error
+}
+
+// ReadLimiter is implemented by connections able to refuse large messages.
+// Reading a message over the limit fails, closing the connection with
+// CloseMessageTooBig.
+type ReadLimiter interface {
+	SetReadLimit(limit int64)
+}
+
+// Compressor is implemented by connections able to compress messages
+type Compressor interface {
+	EnableWriteCompression(enable bool)
+}
+
+// WriteClose tells the peer the close code and reason, when conn supports it.
+// Reasons too long for a websocket close frame are truncated.
+func WriteClose(conn Conn, code int, reason string) error {
+	closer, ok := conn.(CloseWriter)
+	if !ok {
+		return nil
+	}
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	return closer.WriteClose(code, reason)
+}
+
+// SetReadLimit limits the size of messages read from conn, when it supports it
+func SetReadLimit(conn Conn, limit int64) {
+	if limiter, ok := conn.(ReadLimiter); ok {
+		limiter.SetReadLimit(limit)
+	}
+}
+
+// EnableWriteCompression turns compression of the next messages written to
+// conn on or off, when it supports it
+func EnableWriteCompression(conn Conn, enable bool) {
+	if compressor, ok := conn.(Compressor); ok {
+		compressor.EnableWriteCompression(enable)
+	}
+}
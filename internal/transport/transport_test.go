@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+
+	// Messages arrive in order with their type, and later changes to the
+	// written buffer don't reach the peer
+	data := []byte("hello")
+	if err := a.WriteMessage(TextMessage, data); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	data[0] = 'j'
+	a.WriteMessage(BinaryMessage, []byte{1, 2})
+	if messageType, got, err := b.ReadMessage(); err != nil || messageType != TextMessage || string(got) != "hello" {
+		t.Errorf("Expected a text message hello, got %d %q %v", messageType, got, err)
+	}
+	if messageType, got, err := b.ReadMessage(); err != nil || messageType != BinaryMessage || len(got) != 2 {
+		t.Errorf("Expected a binary message, got %d %v %v", messageType, got, err)
+	}
+	if err := a.WriteMessage(websocket.PingMessage, nil); err == nil {
+		t.Error("Expected control frames to be refused")
+	}
+
+	// The peer reads what was written before the close, then the close code
+	b.WriteMessage(TextMessage, []byte("bye"))
+	WriteClose(b, websocket.ClosePolicyViolation, strings.Repeat("x", 200))
+	b.Close()
+	if _, got, err := a.ReadMessage(); err != nil || string(got) != "bye" {
+		t.Errorf("Expected bye before the close, got %q %v", got, err)
+	}
+	var closeErr *websocket.CloseError
+	if _, _, err := a.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || len(closeErr.Text) != maxCloseReason {
+		t.Errorf("Expected a truncated policy violation close, got %v", err)
+	}
+	if _, _, err := a.ReadMessage(); !errors.As(err, &closeErr) {
+		t.Errorf("Expected reads to keep failing, got %v", err)
+	}
+	if err := a.WriteMessage(TextMessage, []byte("anyone?")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Expected writes to a closed peer to fail, got %v", err)
+	}
+	if a.RemoteAddr().Network() != "pipe" {
+		t.Errorf("Unexpected remote address %v", a.RemoteAddr())
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	// A peer going away without a close frame is an abnormal closure
+	a, b := Pipe()
+	b.Close()
+	if _, _, err := a.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+		t.Errorf("Expected an abnormal closure, got %v", err)
+	}
+
+	// Closing an end unblocks its reader
+	a, _ = Pipe()
+	done := make(chan error)
+	go func() {
+		_, _, err := a.ReadMessage()
+		done <- err
+	}()
+	a.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected reads on a closed end to fail, got %v", err)
+	}
+}
+
+func TestPipeReadLimit(t *testing.T) {
+	a, b := Pipe()
+	SetReadLimit(b, 4)
+	a.WriteMessage(TextMessage, []byte("fits"))
+	a.WriteMessage(TextMessage, []byte("too long"))
+
+	if _, got, err := b.ReadMessage(); err != nil || string(got) != "fits" {
+		t.Errorf("Expected the small message, got %q %v", got, err)
+	}
+	if _, _, err := b.ReadMessage(); !errors.Is(err, websocket.ErrReadLimit) {
+		t.Errorf("Expected the read limit error, got %v", err)
+	}
+	if _, _, err := a.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected the writer to get close code 1009, got %v", err)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var conn Conn = WebSocket(ws)
+		defer conn.Close()
+		SetReadLimit(conn, 8)
+		EnableWriteCompression(conn, false)
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(TextMessage, data)
+		WriteClose(conn, websocket.CloseNormalClosure, "done")
+	}))
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer ws.Close()
+
+	ws.WriteMessage(websocket.TextMessage, []byte("echo"))
+	if _, data, err := ws.ReadMessage(); err != nil || string(data) != "echo" {
+		t.Errorf("Expected the echo, got %q %v", data, err)
+	}
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected a normal close, got %v", err)
+	}
+}
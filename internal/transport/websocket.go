@@ -0,0 +1,26 @@
+package transport
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeTimeout bounds how long writing a close frame may block
+const closeTimeout = time.Second
+
+// WebSocketConn adapts a gorilla websocket connection to Conn. Reads, writes,
+// read limits and compression pass straight through.
+type WebSocketConn struct {
+	*websocket.Conn
+}
+
+// WebSocket wraps conn as a Conn
+func WebSocket(conn *websocket.Conn) *WebSocketConn {
+	return &WebSocketConn{Conn: conn}
+}
+
+// WriteClose sends a close frame with code and reason
+func (c *WebSocketConn) WriteClose(code int, reason string) error {
+	return c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeTimeout))
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"simple-multiplayer-service/internal/abandon"
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+// SetAbandonment pauses the sessions of players who lose their last
+// connection and abandons them through tracker when they don't come back
+func (cm *ConnectionManager) SetAbandonment(tracker *abandon.Tracker) {
+	tracker.DisconnectedFunc = cm.playerDisconnected
+	tracker.ReconnectedFunc = cm.playerReconnected
+	tracker.OnlineFunc = cm.playerOnline
+	cm.abandon = tracker
+}
+
+// AdmitMatchmaking refuses matchmaking requests from players serving a
+// leaver penalty
+func (cm *ConnectionManager) AdmitMatchmaking(c *client.Client) bool {
+	if cm.abandon == nil {
+		return true
+	}
+	until, penalized := cm.abandon.Penalized(c.PlayerID())
+	if !penalized {
+		return true
+	}
+	cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeLeaverPenalty,
+		"matchmaking unavailable until "+until.UTC().Format(time.RFC3339)+" after abandoning a session"))
+	return false
+}
+
+// playerDisconnected tells the rest of a session that a player left
+func (cm *ConnectionManager) playerDisconnected(session matchmaking.Session, playerID string, deadline time.Time) {
+	cm.broadcastToOthers(session, playerID, message.NewServerMessage("", message.PlayerDisconnected{
+		Type:        message.PlayerDisconnectedType,
+		SessionID:   session.SessionID,
+		PlayerID:    playerID,
+		ReconnectBy: deadline.UTC(),
+	}))
+}
+
+// playerReconnected tells the rest of a session that a player is back
+func (cm *ConnectionManager) playerReconnected(session matchmaking.Session, playerID string) {
+	cm.broadcastToOthers(session, playerID, message.NewServerMessage("", message.PlayerReconnected{
+		Type:      message.PlayerReconnectedType,
+		SessionID: session.SessionID,
+		PlayerID:  playerID,
+	}))
+}
+
+// broadcastToOthers sends msg to the players of a session but playerID, and to its spectators
+func (cm *ConnectionManager) broadcastToOthers(session matchmaking.Session, playerID string, msg message.Message) {
+	others := make([]string, 0, len(session.Players()))
+	for _, id := range session.Players() {
+		if id != playerID {
+			others = append(others, id)
+		}
+	}
+	cm.BroadcastToPlayers(others, msg)
+	cm.relayToSpectators(session, msg)
+}
+
+// playerOnline reports whether a player has a connection here or, with a
+// presence registry, on another node
+func (cm *ConnectionManager) playerOnline(playerID string) bool {
+	cm.mutex.RLock()
+	local := len(cm.players[playerID]) > 0
+	cm.mutex.RUnlock()
+	if local || cm.presence == nil {
+		return local
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	nodes, err := cm.presence.PlayerNodes(ctx, playerID)
+	if err != nil {
+		cm.logger.Warn("error looking up presence of disconnected player", slog.String(logging.KeyPlayerID, playerID), logging.Err(err))
+		return false
+	}
+	return len(nodes) > 0
+}
+
+// playerLeft starts the reconnection window of a player whose last connection closed
+func (cm *ConnectionManager) playerLeft(playerID string) {
+	if cm.abandon != nil {
+		cm.abandon.Disconnected(playerID)
+	}
+}
+
+// playerReturned ends the reconnection windows of a player opening its first connection
+func (cm *ConnectionManager) playerReturned(playerID string) {
+	if cm.abandon != nil {
+		cm.abandon.Reconnected(playerID)
+	}
+}
@@ -0,0 +1,70 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/abandon"
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+)
+
+// TestAbandonment tests that a player's opponent is told when it leaves and
+// comes back, and wins by forfeit when it doesn't come back in time
+func TestAbandonment(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	mmSvc := matchmaking.NewMatchmakingService(10, sessions, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	tracker := abandon.NewTracker(sessions, mmSvc.Sessions, 100*time.Millisecond)
+	tracker.Forfeit = true
+	tracker.Penalty = time.Minute
+	manager.SetAbandonment(tracker)
+	mmSvc.Sessions.Create("abandoned-session", matchmaking.ModeMatchmaking, []string{"leaver", "stayer"})
+	mmSvc.Sessions.Transition("abandoned-session", matchmaking.StatusActive)
+
+	stayer, _ := connectPipe(t, manager, "stayer")
+	leaver, _ := connectPipe(t, manager, "leaver")
+
+	leaver.Close()
+	var status message.SessionStatus
+	readContentPipe(t, stayer, message.SessionStatusType, &status)
+	if status.Status != string(matchmaking.StatusPaused) {
+		t.Errorf("Expected the session to pause, got %+v", status)
+	}
+	var disconnected message.PlayerDisconnected
+	readContentPipe(t, stayer, message.PlayerDisconnectedType, &disconnected)
+	if disconnected.PlayerID != "leaver" || disconnected.SessionID != "abandoned-session" || disconnected.ReconnectBy.Before(time.Now()) {
+		t.Errorf("Unexpected disconnect notice %+v", disconnected)
+	}
+
+	leaver, _ = connectPipe(t, manager, "leaver")
+	var reconnected message.PlayerReconnected
+	readContentPipe(t, stayer, message.PlayerReconnectedType, &reconnected)
+	if reconnected.PlayerID != "leaver" {
+		t.Errorf("Unexpected reconnect notice %+v", reconnected)
+	}
+	readContentPipe(t, stayer, message.SessionStatusType, &status)
+	if status.Status != string(matchmaking.StatusActive) {
+		t.Errorf("Expected the session to resume, got %+v", status)
+	}
+
+	leaver.Close()
+	readContentPipe(t, stayer, message.SessionStatusType, &status)
+	readContentPipe(t, stayer, message.PlayerDisconnectedType, &disconnected)
+	readContentPipe(t, stayer, message.SessionStatusType, &status)
+	if status.Status != string(matchmaking.StatusAbandoned) || status.Outcome == nil || status.Outcome.WinnerID != "stayer" || status.Outcome.Resolution != message.ResolutionForfeit {
+		t.Errorf("Expected the stayer to win by forfeit, got %+v", status)
+	}
+
+	// The leaver can't queue for another match for a while
+	leaver, _ = connectPipe(t, manager, "leaver")
+	sendPipe(t, leaver, message.Message{Content: `{"type":"matchmakingRequest"}`})
+	var refused message.Error
+	readContentPipe(t, leaver, message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeLeaverPenalty {
+		t.Errorf("Expected a leaver penalty, got %+v", refused)
+	}
+}
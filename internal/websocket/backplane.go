@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"simple-multiplayer-service/internal/backplane"
+	"simple-multiplayer-service/internal/logging"
+
+	"github.com/google/uuid"
+)
+
+// publishTimeout bounds how long a send waits on the backplane
+const publishTimeout = 2 * time.Second
+
+// originSeparator separates the sending node from the rest of a reliable event's ID
+const originSeparator = "/"
+
+// SetBackplane connects the manager to the other nodes of a deployment so
+// that messages reach clients connected to any node. nodeID must be unique
+// per node.
+func (cm *ConnectionManager) SetBackplane(bp backplane.Backplane, nodeID string) {
+	cm.backplane = bp
+	cm.nodeID = nodeID
+}
+
+// NodeID returns the ID this node uses on the backplane
+func (cm *ConnectionManager) NodeID() string {
+	return cm.nodeID
+}
+
+// StartBackplane delivers messages published by other nodes to this node's
+// clients until ctx is done
+func (cm *ConnectionManager) StartBackplane(ctx context.Context) error {
+	if cm.backplane == nil {
+		return nil
+	}
+	return cm.backplane.Subscribe(ctx, cm.nodeID, cm.handleEnvelope)
+}
+
+// publish sends env to every node, stamped with this node's ID
+func (cm *ConnectionManager) publish(env backplane.Envelope) error {
+	env.Origin = cm.nodeID
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return cm.backplane.Publish(ctx, env)
+}
+
+// publishToNode sends env to a single node, stamped with this node's ID
+func (cm *ConnectionManager) publishToNode(nodeID string, env backplane.Envelope) error {
+	env.Origin = cm.nodeID
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return cm.backplane.PublishToNode(ctx, nodeID, env)
+}
+
+// reliableID returns a new ID for a reliable event sent by nodeID
+func reliableID(nodeID string) string {
+	return nodeID + originSeparator + uuid.New().String()
+}
+
+// reliableOrigin returns the node that sent the reliable event with messageID
+func reliableOrigin(messageID string) (string, bool) {
+	i := strings.LastIndex(messageID, originSeparator)
+	if i <= 0 {
+		return "", false
+	}
+	return messageID[:i], true
+}
+
+func (cm *ConnectionManager) handleEnvelope(env backplane.Envelope) {
+	// Envelopes for every node come back to their publisher too
+	if env.Origin == cm.nodeID {
+		return
+	}
+
+	switch env.Kind {
+	case backplane.KindMessage:
+		err := cm.sendLocal(env.Message)
+		if err != nil && !errors.Is(err, ErrClientNotFound) {
+			cm.logger.Warn("error delivering backplane message",
+				slog.String(logging.KeyRecipient, env.Message.To),
+				slog.String("origin", env.Origin),
+				logging.Err(err))
+		}
+	case backplane.KindBroadcast:
+		cm.fanOut(cm.ListClients(), env.Message)
+	case backplane.KindAck:
+		if env.Ack != nil {
+			cm.delivery.Ack(env.Ack.MessageID, env.Ack.ConnectionID, env.Ack.PlayerID)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/backplane"
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/db/redisdb"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// startNode runs a connection manager on a shared backplane and returns it with its websocket URL
+func startNode(t *testing.T, ctx context.Context, bp backplane.Backplane, nodeID string) (*ConnectionManager, string) {
+	t.Helper()
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetBackplane(bp, nodeID)
+	manager.SetDeliveryRetry(20*time.Millisecond, 50)
+	if err := manager.StartBackplane(ctx); err != nil {
+		t.Fatalf("Error starting backplane on %s: %v", nodeID, err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	t.Cleanup(server.Close)
+	return manager, "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+// TestBackplaneRoutesBetweenNodes tests that messages, broadcasts and acks cross
+// nodes, acks going only to the node that sent the event
+func TestBackplaneRoutesBetweenNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bp := backplane.NewMemory()
+
+	node1, url1 := startNode(t, ctx, bp, "node1")
+	_, url2 := startNode(t, ctx, bp, "node2")
+
+	dial := func(wsURL string) (*websocket.Conn, string) {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		var welcome message.Message
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Fatalf("Error reading welcome message: %v", err)
+		}
+		return conn, welcome.To
+	}
+	read := func(conn *websocket.Conn) message.Message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg message.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Error reading message: %v", err)
+		}
+		return msg
+	}
+
+	// node3 sees envelopes published to every node
+	var acks atomic.Int32
+	bp.Subscribe(ctx, "node3", func(env backplane.Envelope) {
+		if env.Kind == backplane.KindAck {
+			acks.Add(1)
+		}
+	})
+
+	alice, aliceID := dial(url1)
+	bob, bobID := dial(url2)
+
+	// A direct message from node1 reaches a client on node2
+	if err := alice.WriteJSON(message.Message{To: bobID, Content: "hello from node1"}); err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+	if msg := read(bob); msg.Content != "hello from node1" || msg.From != aliceID {
+		t.Errorf("Unexpected message on node2 %+v", msg)
+	}
+
+	// A broadcast on node1 reaches clients on both nodes
+	if n := node1.Broadcast(message.Message{From: message.ServerID, Content: "maintenance"}); n != 1 {
+		t.Errorf("Expected the broadcast to reach 1 local client, got %d", n)
+	}
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		if msg := read(conn); msg.Content != "maintenance" {
+			t.Errorf("Expected the broadcast, got %+v", msg)
+		}
+	}
+
+	// A reliable event from node1 is resent to node2 until bob's ack crosses back
+	if err := node1.SendReliable(message.Message{From: message.ServerID, To: bobID, Content: "session"}); err != nil {
+		t.Fatalf("Error sending reliable message: %v", err)
+	}
+	event := read(bob)
+	if origin, _ := reliableOrigin(event.ID); origin != "node1" {
+		t.Errorf("Expected the event ID to name node1, got %s", event.ID)
+	}
+	ack, _ := json.Marshal(message.AckMessage{Type: message.AckMessageType, ID: event.ID})
+	if err := bob.WriteJSON(message.Message{Content: string(ack)}); err != nil {
+		t.Fatalf("Error sending ack: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for node1.delivery.Pending() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the ack from node2 to stop node1 resending")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if acks.Load() != 0 {
+		t.Error("Expected the ack to go only to node1")
+	}
+}
+
+// TestPresenceRoutesToNode tests that the presence registry tracks
+// connections and sends messages only to the node holding the recipient
+func TestPresenceRoutesToNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bp := backplane.NewMemory()
+	store := redisdb.NewStore(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}), "test")
+	defer store.Close()
+
+	node1, _ := startNode(t, ctx, bp, "node1")
+	node2, url2 := startNode(t, ctx, bp, "node2")
+	node1.SetPresence(store, time.Minute)
+	node2.SetPresence(store, time.Minute)
+
+	// node3 would see envelopes published to every node
+	var leaked atomic.Int32
+	bp.Subscribe(ctx, "node3", func(backplane.Envelope) { leaked.Add(1) })
+
+	bob, _, err := websocket.DefaultDialer.Dial(url2, nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer bob.Close()
+	var welcome message.Message
+	if err := bob.ReadJSON(&welcome); err != nil {
+		t.Fatalf("Error reading welcome message: %v", err)
+	}
+	bobID := welcome.To
+
+	if nodeID, _ := store.ConnectionNode(ctx, bobID); nodeID != "node2" {
+		t.Fatalf("Expected bob registered on node2, got '%s'", nodeID)
+	}
+
+	if err := node1.SendMessageToClient(message.Message{From: "alice", To: bobID, Content: "direct"}); err != nil {
+		t.Fatalf("Error sending to bob: %v", err)
+	}
+	bob.SetReadDeadline(time.Now().Add(time.Second))
+	var msg message.Message
+	if err := bob.ReadJSON(&msg); err != nil || msg.Content != "direct" {
+		t.Fatalf("Expected the message on node2, got %+v, %v", msg, err)
+	}
+	if leaked.Load() != 0 {
+		t.Error("Expected the message to go only to node2")
+	}
+
+	// Once bob leaves, the registry knows bob is connected nowhere
+	bob.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if nodeID, _ := store.ConnectionNode(ctx, bobID); nodeID == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected bob's presence to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := node1.SendMessageToClient(message.Message{From: "alice", To: bobID, Content: "late"}); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("Expected ErrClientNotFound for a player connected nowhere, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+
+	"github.com/gorilla/websocket"
+)
+
+// countingConn counts the bytes read from the network
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// dialCounting starts a server with opts and connects a client that counts
+// the bytes it receives, returning the manager, the client and its connection ID
+func dialCounting(tb testing.TB, opts UpgradeOptions, clientCompression bool) (*ConnectionManager, *websocket.Conn, string, *atomic.Int64) {
+	tb.Helper()
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetUpgradeOptions(opts)
+	// Keep connection logs out of benchmark output
+	manager.SetLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), false)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	tb.Cleanup(server.Close)
+
+	read := &atomic.Int64{}
+	dialer := websocket.Dialer{
+		EnableCompression: clientCompression,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return countingConn{Conn: conn, read: read}, err
+		},
+	}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		tb.Fatalf("Could not connect: %v", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+
+	var welcome message.Message
+	if err := conn.ReadJSON(&welcome); err != nil {
+		tb.Fatalf("Error reading welcome message: %v", err)
+	}
+	return manager, conn, welcome.To, read
+}
+
+// snapshot builds a game state update of roughly size bytes
+func snapshot(size int) string {
+	var b strings.Builder
+	b.WriteString(`{"type":"snapshot","entities":[`)
+	for i := 0; b.Len() < size; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"x":%d.25,"y":%d.5,"hp":100,"state":"idle"}`, i, i*3%1000, i*7%1000)
+	}
+	b.WriteString(`]}`)
+	return b.String()
+}
+
+// wireBytes sends content to the client and returns the bytes it took on the wire
+func wireBytes(tb testing.TB, manager *ConnectionManager, conn *websocket.Conn, clientID string, read *atomic.Int64, content string) int64 {
+	tb.Helper()
+	before := read.Load()
+	if err := manager.SendMessageToClient(message.Message{From: message.ServerID, To: clientID, Content: content}); err != nil {
+		tb.Fatalf("Error sending: %v", err)
+	}
+	var msg message.Message
+	if err := conn.ReadJSON(&msg); err != nil || msg.Content != content {
+		tb.Fatalf("Expected the content back, got %d bytes, %v", len(msg.Content), err)
+	}
+	return read.Load() - before
+}
+
+// TestCompressionThreshold tests that only frames over the threshold are compressed
+func TestCompressionThreshold(t *testing.T) {
+	opts := DefaultUpgradeOptions()
+	opts.EnableCompression = true
+	opts.CompressionThreshold = 256
+	manager, conn, clientID, read := dialCounting(t, opts, true)
+
+	small := "a short chat message, a short chat message, a short chat message"
+	if n := wireBytes(t, manager, conn, clientID, read, small); n < int64(len(small)) {
+		t.Errorf("Expected a frame under the threshold to be sent uncompressed, took %d bytes for %d of content", n, len(small))
+	}
+
+	large := snapshot(8192)
+	if n := wireBytes(t, manager, conn, clientID, read, large); n > int64(len(large))/2 {
+		t.Errorf("Expected a snapshot to compress, took %d bytes for %d of content", n, len(large))
+	}
+}
+
+// TestCompressionNeedsClientSupport tests that clients without permessage-deflate get plain frames
+func TestCompressionNeedsClientSupport(t *testing.T) {
+	opts := DefaultUpgradeOptions()
+	opts.EnableCompression = true
+	opts.CompressionThreshold = 0
+	manager, conn, clientID, read := dialCounting(t, opts, false)
+
+	large := snapshot(8192)
+	if n := wireBytes(t, manager, conn, clientID, read, large); n < int64(len(large)) {
+		t.Errorf("Expected an uncompressed frame, took %d bytes for %d of content", n, len(large))
+	}
+}
+
+// BenchmarkCompression compares writing chat-sized and snapshot-sized frames
+// with and without compression. wire-B/op is what the client downloads.
+func BenchmarkCompression(b *testing.B) {
+	payloads := []struct {
+		name    string
+		content string
+	}{
+		{"chat-100B", strings.Repeat("gg wp ", 17)},
+		{"snapshot-2KB", snapshot(2048)},
+		{"snapshot-16KB", snapshot(16384)},
+		{"history-64KB", snapshot(65536)},
+	}
+	configs := []struct {
+		name      string
+		enabled   bool
+		level     int
+		threshold int
+	}{
+		{"off", false, 0, 0},
+		{"level1", true, 1, 0},
+		{"level6", true, 6, 0},
+		{"level1-threshold512", true, 1, DefaultCompressionThreshold},
+	}
+
+	for _, config := range configs {
+		for _, payload := range payloads {
+			content := payload.content
+			b.Run(config.name+"/"+payload.name, func(b *testing.B) {
+				opts := DefaultUpgradeOptions()
+				opts.EnableCompression = config.enabled
+				opts.CompressionLevel = config.level
+				opts.CompressionThreshold = config.threshold
+				manager, conn, clientID, read := dialCounting(b, opts, true)
+
+				b.SetBytes(int64(len(content)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				var wire int64
+				for i := 0; i < b.N; i++ {
+					wire += wireBytes(b, manager, conn, clientID, read, content)
+				}
+				b.ReportMetric(float64(wire)/float64(b.N), "wire-B/op")
+			})
+		}
+	}
+}
@@ -2,57 +2,174 @@ package websocket
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"time"
 
+	"simple-multiplayer-service/internal/auth"
 	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/codec"
+	"simple-multiplayer-service/internal/logging"
 	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/player"
+	"simple-multiplayer-service/internal/ratelimit"
+	"simple-multiplayer-service/internal/transport"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-// Upgrader for WebSocket connections
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	// Allow all origins for now (in production, this should be restricted)
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// admission is a connection request that passed authentication and rate limiting
+type admission struct {
+	ip          string
+	identity    auth.Identity
+	rateLimiter *ratelimit.ConnectionLimiter
+	logger      *slog.Logger
+}
+
+// admit authenticates r when authentication is enabled and reserves a
+// connection slot for its IP, answering the request itself when it is refused
+func admit(manager *ConnectionManager, w http.ResponseWriter, r *http.Request, transport string) (admission, bool) {
+	// Get the client's IP address
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	a := admission{ip: ip, logger: manager.logger.With(slog.String(logging.KeyIP, ip))}
+
+	// Verify the caller before connecting when authentication is enabled
+	if manager.authenticator != nil {
+		a.identity, err = manager.authenticator.Authenticate(r)
+		if err != nil {
+			a.logger.Warn("rejecting "+transport+" connection", slog.String("reason", "unauthorized"), logging.Err(err))
+			manager.metrics.ConnectionRejected("unauthorized")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return a, false
+		}
+	}
+	// Anonymous clients may name themselves
+	if a.identity.UserID == "" {
+		a.identity.DisplayName = r.URL.Query().Get("name")
+	}
+
+	// Reserve a connection slot for the IP when rate limiting is enabled
+	if manager.rateLimiter != nil {
+		var ok bool
+		a.rateLimiter, ok = manager.rateLimiter.Connect(ip)
+		if !ok {
+			a.logger.Warn("rejecting "+transport+" connection", slog.String("reason", "too many connections"))
+			manager.metrics.ConnectionRejected("rate_limited")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return a, false
+		}
+	}
+	return a, true
 }
 
 // HandleWebSocket handles WebSocket connection requests
 func HandleWebSocket(manager *ConnectionManager, w http.ResponseWriter, r *http.Request) {
+	a, ok := admit(manager, w, r, "websocket")
+	if !ok {
+		return
+	}
+	logger := a.logger
+
+	// Browsers require one of their offered subprotocols to be selected
+	upgrader := manager.upgrader
+	if a.identity.Subprotocol != "" {
+		withToken := *upgrader
+		withToken.Subprotocols = append(append([]string(nil), upgrader.Subprotocols...), a.identity.Subprotocol)
+		upgrader = &withToken
+	}
+
+	// Clients pick their wire format by offering codec names as subprotocols,
+	// most preferred first
+	wsCodec, offered := codec.Negotiate(websocket.Subprotocols(r))
+	if offered {
+		withCodec := *upgrader
+		withCodec.Subprotocols = append([]string{wsCodec.Name()}, upgrader.Subprotocols...)
+		upgrader = &withCodec
+	}
+
 	// Upgrade the HTTP connection to a WebSocket connection
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Error upgrading to WebSocket: %v", err)
+		logger.Warn("error upgrading to websocket", logging.Err(err))
+		manager.metrics.ConnectionRejected("upgrade_failed")
+		a.rateLimiter.Release()
 		return
 	}
 
-	// Get the wsClient's IP address
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		ip = r.RemoteAddr
+	if manager.upgradeOptions.CompressionLevel != 0 {
+		if err := conn.SetCompressionLevel(manager.upgradeOptions.CompressionLevel); err != nil {
+			logger.Warn("error setting compression level", logging.Err(err))
+		}
 	}
 
+	startClient(manager, transport.WebSocket(conn), wsCodec, a)
+}
+
+// ServeConn runs a client over a connection accepted outside of HTTP, such
+// as one end of a transport.Pipe in tests. The client plays as identity,
+// or anonymously under its connection ID when identity has no user ID, and
+// isn't subject to the per-IP connection limit. It returns nil when the
+// client was refused, having closed conn.
+func (cm *ConnectionManager) ServeConn(conn transport.Conn, wireCodec codec.Codec, identity auth.Identity) *client.Client {
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	a := admission{ip: ip, identity: identity, logger: cm.logger.With(slog.String(logging.KeyIP, ip))}
+	return startClient(cm, conn, wireCodec, a)
+}
+
+// startClient registers a client talking over conn, welcomes it and starts
+// its read loop. It returns nil when the client was refused or dropped,
+// having closed conn.
+func startClient(manager *ConnectionManager, conn transport.Conn, wsCodec codec.Codec, a admission) *client.Client {
 	// Create a unique ID for the wsClient using UUID
 	clientID := uuid.New().String()
 
+	// Authenticated clients play as their user, anonymous ones as their connection
+	wsPlayer := &player.Player{
+		ID:          a.identity.UserID,
+		DisplayName: a.identity.DisplayName,
+		Metadata:    a.identity.Metadata,
+	}
+	if wsPlayer.ID == "" {
+		wsPlayer.ID = clientID
+	}
+
+	logger := a.logger.With(slog.String(logging.KeyClientID, clientID), slog.String(logging.KeyPlayerID, wsPlayer.ID))
+
 	// Create a new wsClient
 	wsClient := &client.Client{
-		ID:                  clientID,
-		IPAddress:           ip,
-		Connection:          conn,
-		MatchmakingService:  manager.matchmakingService,
-		NotificationService: manager.notificationService,
-		Done:                make(chan struct{}),
+		ID:                   clientID,
+		Player:               wsPlayer,
+		IPAddress:            a.ip,
+		ConnectedAt:          time.Now(),
+		Connection:           conn,
+		MatchmakingService:   manager.matchmakingService,
+		RateLimiter:          a.rateLimiter,
+		MaxFrameSize:         manager.maxFrameSize,
+		MaxContentLength:     manager.maxContentLength,
+		Metrics:              manager.metrics,
+		Logger:               logger,
+		LogContent:           manager.logContent,
+		Codec:                wsCodec,
+		CompressionThreshold: manager.upgradeOptions.CompressionThreshold,
+		Done:                 make(chan struct{}),
 	}
 
 	// Register the wsClient
-	manager.RegisterClient(wsClient)
+	err := manager.RegisterClient(wsClient)
+	if err != nil {
+		logger.Warn("error registering client", logging.Err(err))
+		closeConnection(wsClient, websocket.ClosePolicyViolation, err.Error())
+		a.rateLimiter.Release()
+		return nil
+	}
 
 	// Send the wsClient their ID
 	welcomeMsg := message.Message{
@@ -60,17 +177,22 @@ func HandleWebSocket(manager *ConnectionManager, w http.ResponseWriter, r *http.
 		To:      clientID,
 		Content: fmt.Sprintf("Welcome! Your connection ID is: %s", clientID),
 	}
-	err = conn.WriteJSON(welcomeMsg)
+	if wsPlayer.ID != clientID {
+		welcomeMsg.Content = fmt.Sprintf("Welcome! Your connection ID is: %s, player ID is: %s", clientID, wsPlayer.ID)
+	}
+	err = wsClient.WriteMessage(welcomeMsg)
 	if err != nil {
-		log.Printf("Error sending welcome message: %v", err)
+		logger.Warn("error sending welcome message", logging.Err(err))
 		conn.Close()
 		manager.UnregisterClient(clientID)
-		return
+		a.rateLimiter.Release()
+		return nil
 	}
 
+	// Hand over messages received while the player was offline
+	manager.deliverOfflineMessages(wsClient)
+
 	// Start reading messages from the wsClient
 	go wsClient.ReadMessages()
-
-	// Start checking notifications from notification service
-	go wsClient.CheckNotifications()
+	return wsClient
 }
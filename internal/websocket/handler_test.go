@@ -1,17 +1,26 @@
 package websocket
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"simple-multiplayer-service/internal/auth"
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/codec"
 	"simple-multiplayer-service/internal/db/local"
 	"simple-multiplayer-service/internal/matchmaking"
 	"simple-multiplayer-service/internal/message"
 	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/ratelimit"
+	"simple-multiplayer-service/internal/transport"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 )
 
@@ -168,3 +177,689 @@ func TestMultipleClients(t *testing.T) {
 		t.Error("Client 1 was not properly unregistered after disconnection")
 	}
 }
+
+// TestHandleWebSocketAuthentication tests that upgrades require a valid token when an authenticator is set
+func TestHandleWebSocketAuthentication(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessionDB := local.DB{}
+	mmSvc := matchmaking.NewMatchmakingService(10, sessionDB, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+
+	authenticator, err := auth.NewAuthenticator(auth.Options{HMACSecret: "secret", QueryParam: "token"})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+	manager.SetAuthenticator(authenticator)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// Without a token the upgrade is rejected
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected upgrade without token to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 response, got %v", resp)
+	}
+
+	// With a token the client is registered under its user ID
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "user1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte("secret"))
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("Could not connect with token: %v", err)
+	}
+	defer conn.Close()
+
+	var welcomeMsg message.Message
+	if err := conn.ReadJSON(&welcomeMsg); err != nil {
+		t.Fatalf("Error reading welcome message: %v", err)
+	}
+
+	playerClients := manager.GetPlayerClients("user1")
+	if len(playerClients) != 1 {
+		t.Fatalf("Expected 1 connection for user1, got %d", len(playerClients))
+	}
+	wsClient := playerClients[0]
+	if wsClient.ID != welcomeMsg.To {
+		t.Errorf("Expected connection ID '%s', got '%s'", welcomeMsg.To, wsClient.ID)
+	}
+	if wsClient.PlayerID() != "user1" {
+		t.Errorf("Expected PlayerID to be 'user1', got '%s'", wsClient.PlayerID())
+	}
+}
+
+// TestHandleWebSocketOrigins tests that the origin allow-list is enforced on upgrade
+func TestHandleWebSocketOrigins(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+
+	opts := DefaultUpgradeOptions()
+	opts.AllowedOrigins = []string{"https://game.example.com", "https://*.partner.example"}
+	opts.Subprotocols = []string{"json"}
+	manager.SetUpgradeOptions(opts)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// Cross-origin requests outside the allow-list are rejected
+	for _, origin := range []string{"https://evil.example.com", "http://game.example.com", "https://partner.example"} {
+		_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {origin}})
+		if err == nil {
+			t.Errorf("Expected upgrade from %s to fail", origin)
+			continue
+		}
+		if resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s, got %v", origin, resp)
+		}
+	}
+
+	// Allowed origins connect and negotiate the configured subprotocol
+	for _, origin := range []string{"https://game.example.com", "https://eu.partner.example"} {
+		dialer := websocket.Dialer{Subprotocols: []string{"json"}}
+		conn, _, err := dialer.Dial(wsURL, http.Header{"Origin": {origin}})
+		if err != nil {
+			t.Errorf("Expected upgrade from %s to succeed, got %v", origin, err)
+			continue
+		}
+		if conn.Subprotocol() != "json" {
+			t.Errorf("Expected subprotocol 'json', got '%s'", conn.Subprotocol())
+		}
+		conn.Close()
+	}
+}
+
+// TestHandleWebSocketSameOriginByDefault tests that only same-origin browsers connect without an allow-list
+func TestHandleWebSocketSameOriginByDefault(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected cross-origin upgrade to be rejected with 403, got %v", resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {server.URL}})
+	if err != nil {
+		t.Fatalf("Expected same-origin upgrade to succeed, got %v", err)
+	}
+	conn.Close()
+}
+
+// TestHandleWebSocketRateLimits tests connection limits per IP and disconnecting flooding clients
+func TestHandleWebSocketRateLimits(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetRateLimiter(ratelimit.NewLimiter(ratelimit.Options{
+		MessagesPerSecond:   0.001,
+		MessageBurst:        1,
+		MaxConnectionsPerIP: 1,
+		MaxViolations:       2,
+	}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	var welcomeMsg message.Message
+	if err := conn.ReadJSON(&welcomeMsg); err != nil {
+		t.Fatalf("Error reading welcome message: %v", err)
+	}
+
+	// A second connection from the same IP is refused
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected second connection to be rejected with 429, got %v", resp)
+	}
+
+	// The first message uses the burst, the second is answered with an error frame
+	for i := 0; i < 2; i++ {
+		if err := conn.WriteJSON(message.Message{To: welcomeMsg.To, Content: "spam"}); err != nil {
+			t.Fatalf("Error sending message: %v", err)
+		}
+	}
+	var echoed, errorFrame message.Message
+	if err := conn.ReadJSON(&echoed); err != nil || echoed.Content != "spam" {
+		t.Fatalf("Expected first message to be delivered, got %v (%v)", echoed, err)
+	}
+	if err := conn.ReadJSON(&errorFrame); err != nil {
+		t.Fatalf("Error reading error frame: %v", err)
+	}
+	var frame message.Error
+	if err := json.Unmarshal([]byte(errorFrame.Content), &frame); err != nil || frame.Code != message.ErrorCodeRateLimited {
+		t.Errorf("Expected rate_limited error frame, got %s", errorFrame.Content)
+	}
+
+	// Acks count too, and another violation disconnects the client
+	ack, _ := json.Marshal(message.AckMessage{Type: message.AckMessageType, ID: "flood"})
+	if err := conn.WriteJSON(message.Message{Content: string(ack)}); err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected policy violation close, got %v", err)
+	}
+
+	// The slot is released once the connection is gone
+	time.Sleep(100 * time.Millisecond)
+	conn2, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Expected connection to be allowed after disconnect, got %v", err)
+	}
+	conn2.Close()
+}
+
+// TestHandleWebSocketMessageLimits tests that oversized and malformed messages close the connection
+func TestHandleWebSocketMessageLimits(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetMessageLimits(256, 64)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tests := []struct {
+		name     string
+		payload  string
+		expected int
+	}{
+		{"frame too large", `{"to":"x","content":"` + strings.Repeat("a", 300) + `"}`, websocket.CloseMessageTooBig},
+		{"content too long", `{"to":"x","content":"` + strings.Repeat("a", 65) + `"}`, websocket.CloseMessageTooBig},
+		{"malformed json", `{"to":`, websocket.CloseInvalidFramePayloadData},
+		{"unknown field", `{"to":"x","content":"hi","admin":true}`, websocket.CloseInvalidFramePayloadData},
+		{"invalid matchmaking request", `{"content":"{\"type\":\"matchmakingRequest\",\"player_id\":1}"}`, websocket.CloseInvalidFramePayloadData},
+	}
+
+	for _, test := range tests {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("%s: could not connect: %v", test.name, err)
+		}
+		var welcomeMsg message.Message
+		if err := conn.ReadJSON(&welcomeMsg); err != nil {
+			t.Fatalf("%s: error reading welcome message: %v", test.name, err)
+		}
+
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(test.payload)); err != nil {
+			t.Fatalf("%s: error sending message: %v", test.name, err)
+		}
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, test.expected) {
+			t.Errorf("%s: expected close code %d, got %v", test.name, test.expected, err)
+		}
+		conn.Close()
+	}
+}
+
+// TestBroadcastTargets tests broadcasting to all clients, the matchmaking queue and a session
+func TestBroadcastTargets(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	go mmSvc.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.StartNotifications(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conns := make([]*websocket.Conn, 3)
+	ids := make([]string, 3)
+	for i := range conns {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Could not connect client %d: %v", i, err)
+		}
+		defer conn.Close()
+		var welcome message.Message
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Fatalf("Error reading welcome message: %v", err)
+		}
+		conns[i], ids[i] = conn, welcome.To
+	}
+
+	// Client 2 waits for a match, clients 0 and 1 share a session
+	mmSvc.SessionQueue <- message.MatchmakingRequest{PlayerID: ids[2], ConnectionID: ids[2]}
+	deadline := time.Now().Add(time.Second)
+	for len(mmSvc.Waiting()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	session := matchmaking.Session{SessionID: "session1", Player1ID: ids[0], Player2ID: ids[1]}
+
+	expectContent := func(conn *websocket.Conn, content string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg message.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Error reading broadcast: %v", err)
+		}
+		if msg.Content != content {
+			t.Errorf("Expected content '%s', got '%s'", content, msg.Content)
+		}
+	}
+
+	if n := manager.BroadcastToQueue(message.Message{From: message.ServerID, Content: "queue"}); n != 1 {
+		t.Errorf("Expected queue broadcast to reach 1 client, got %d", n)
+	}
+	if n := manager.BroadcastToSession(session, message.Message{From: message.ServerID, Content: "session"}); n != 2 {
+		t.Errorf("Expected session broadcast to reach 2 clients, got %d", n)
+	}
+	if n := manager.Broadcast(message.Message{From: message.ServerID, Content: "all"}); n != 3 {
+		t.Errorf("Expected broadcast to reach 3 clients, got %d", n)
+	}
+
+	// Each client sees exactly the broadcasts addressed to it, in order
+	expectContent(conns[2], "queue")
+	expectContent(conns[0], "session")
+	expectContent(conns[1], "session")
+	for _, conn := range conns {
+		expectContent(conn, "all")
+	}
+}
+
+// TestConcurrentBroadcasts tests that broadcasts and direct messages to the same client don't interleave writes
+func TestConcurrentBroadcasts(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	var welcome message.Message
+	if err := conn.ReadJSON(&welcome); err != nil {
+		t.Fatalf("Error reading welcome message: %v", err)
+	}
+
+	const writers, perWriter = 4, 25
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < perWriter; j++ {
+				if i%2 == 0 {
+					manager.Broadcast(message.Message{From: message.ServerID, Content: "broadcast"})
+				} else {
+					manager.SendMessageToClient(message.Message{From: message.ServerID, To: welcome.To, Content: "direct"})
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < writers*perWriter; i++ {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg message.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Error reading message %d: %v", i, err)
+		}
+	}
+	for i := 0; i < writers; i++ {
+		<-done
+	}
+}
+
+// TestSessionNotificationsResentUntilAcknowledged tests at-least-once delivery of session notifications
+func TestSessionNotificationsResentUntilAcknowledged(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetDeliveryRetry(20*time.Millisecond, 10)
+	go mmSvc.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.StartNotifications(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	conns := make([]*websocket.Conn, 2)
+	for i := range conns {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Could not connect client %d: %v", i, err)
+		}
+		defer conn.Close()
+		var welcome message.Message
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Fatalf("Error reading welcome message: %v", err)
+		}
+		if err := conn.WriteJSON(message.Message{Content: `{"type":"matchmakingRequest"}`}); err != nil {
+			t.Fatalf("Error queueing client %d: %v", i, err)
+		}
+		conns[i] = conn
+	}
+
+	readNotification := func(conn *websocket.Conn) message.Message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg message.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Error reading session notification: %v", err)
+		}
+		if msg.ID == "" {
+			t.Fatalf("Expected the session notification to carry an ID, got %+v", msg)
+		}
+		return msg
+	}
+
+	// The first client acknowledges its notification, the second doesn't
+	acked := readNotification(conns[0])
+	ack, _ := json.Marshal(message.AckMessage{Type: message.AckMessageType, ID: acked.ID})
+	if err := conns[0].WriteJSON(message.Message{Content: string(ack)}); err != nil {
+		t.Fatalf("Error sending ack: %v", err)
+	}
+
+	first := readNotification(conns[1])
+	if again := readNotification(conns[1]); again.ID != first.ID {
+		t.Errorf("Expected the unacknowledged notification to be resent, got ID %s", again.ID)
+	}
+
+	// Only the unacknowledged notification is still being resent
+	if pending := manager.delivery.Pending(); pending != 1 {
+		t.Errorf("Expected 1 pending notification, got %d", pending)
+	}
+
+	// Telling the players started the session
+	var notif notification.SessionNotification
+	if err := json.Unmarshal([]byte(first.Content), &notif); err != nil {
+		t.Fatalf("Error decoding session notification: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		session, err := local.DB{}.GetSession(notif.SessionID)
+		if err == nil && session.Status == matchmaking.StatusActive && session.StartedAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the notified session to be active, got %+v %v", session, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSessionNotificationsRoutedByPlayer tests that session notifications
+// reach their players and start the session however many other clients are
+// connected
+func TestSessionNotificationsRoutedByPlayer(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	mmSvc := matchmaking.NewMatchmakingService(10, sessions, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.StartNotifications(ctx)
+
+	for i := 0; i < 5; i++ {
+		connectPipe(t, manager, fmt.Sprintf("routed-bystander-%d", i))
+	}
+	alice, _ := connectPipe(t, manager, "routed-alice")
+	bob, _ := connectPipe(t, manager, "routed-bob")
+
+	session, err := mmSvc.Sessions.Create("routed-session", matchmaking.ModeMatchmaking, []string{"routed-alice", "routed-bob"})
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	notifSvc.Channel <- notification.SessionNotification{
+		SessionID: session.SessionID,
+		Player1ID: session.Player1ID,
+		Player2ID: session.Player2ID,
+		CreatedAt: session.CreatedAt,
+	}
+	for _, conn := range []*transport.PipeConn{alice, bob} {
+		var notif notification.SessionNotification
+		if err := json.Unmarshal([]byte(readPipe(t, conn, codec.JSON).Content), &notif); err != nil || notif.SessionID != "routed-session" {
+			t.Fatalf("Expected the session notification, got %+v %v", notif, err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		session, err := sessions.GetSession("routed-session")
+		if err == nil && session.Status == matchmaking.StatusActive {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the session to start, got %+v %v", session, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestCodecNegotiation tests that clients pick their wire format by subprotocol
+// and can message clients using another one
+func TestCodecNegotiation(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	type peer struct {
+		conn  *websocket.Conn
+		codec codec.Codec
+		id    string
+	}
+	dial := func(subprotocols []string, expected codec.Codec) peer {
+		t.Helper()
+		dialer := websocket.Dialer{Subprotocols: subprotocols}
+		conn, _, err := dialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if conn.Subprotocol() != expected.Name() && len(subprotocols) > 0 {
+			t.Fatalf("Expected subprotocol '%s', got '%s'", expected.Name(), conn.Subprotocol())
+		}
+		p := peer{conn: conn, codec: expected}
+		p.id = read(t, p.conn, p.codec).To
+		return p
+	}
+
+	jsonPeer := dial(nil, codec.JSON)
+	msgpackPeer := dial([]string{"msgpack"}, codec.Msgpack)
+	protobufPeer := dial([]string{"protobuf", "json"}, codec.Protobuf)
+	peers := []peer{jsonPeer, msgpackPeer, protobufPeer}
+
+	// Every client can reach every other, each receiving in its own format
+	for _, from := range peers {
+		for _, to := range peers {
+			if from.id == to.id {
+				continue
+			}
+			data, _ := from.codec.Encode(message.Message{To: to.id, Content: "hello from " + from.codec.Name()})
+			if err := from.conn.WriteMessage(from.codec.FrameType(), data); err != nil {
+				t.Fatalf("Error sending: %v", err)
+			}
+			msg := read(t, to.conn, to.codec)
+			if msg.From != from.id || msg.Content != "hello from "+from.codec.Name() {
+				t.Errorf("%s to %s: unexpected message %+v", from.codec.Name(), to.codec.Name(), msg)
+			}
+		}
+	}
+
+	// Typed requests are understood in any format
+	data, _ := msgpackPeer.codec.Encode(message.Message{Content: `{"type":"joinRoom","room":"lobby"}`})
+	msgpackPeer.conn.WriteMessage(websocket.BinaryMessage, data)
+	if contentType := message.ContentType(read(t, msgpackPeer.conn, msgpackPeer.codec).Content); contentType != message.RoomJoinedType {
+		t.Errorf("Expected roomJoined, got '%s'", contentType)
+	}
+
+	// A frame that doesn't decode closes the connection
+	protobufPeer.conn.WriteMessage(websocket.BinaryMessage, []byte{0x12, 0x7f})
+	protobufPeer.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := protobufPeer.conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData) {
+		t.Errorf("Expected close 1007 for a malformed frame, got %v", err)
+	}
+}
+
+// read decodes the next frame on conn with c, checking its frame type
+func read(t *testing.T, conn *websocket.Conn, c codec.Codec) message.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	frameType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Error reading: %v", err)
+	}
+	if frameType != c.FrameType() {
+		t.Errorf("Expected frame type %d for %s, got %d", c.FrameType(), c.Name(), frameType)
+	}
+	msg, err := c.Decode(data)
+	if err != nil {
+		t.Fatalf("Error decoding %s: %v", c.Name(), err)
+	}
+	return msg
+}
+
+// TestServeConnOverPipe tests clients served over in-memory pipes, without an HTTP server
+func TestServeConnOverPipe(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	manager := NewConnectionManager(matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc), notifSvc)
+
+	aliceConn, alice := transport.Pipe()
+	aliceClient := manager.ServeConn(aliceConn, codec.JSON, auth.Identity{UserID: "alice", DisplayName: "Alice"})
+	bobConn, bob := transport.Pipe()
+	bobClient := manager.ServeConn(bobConn, codec.Msgpack, auth.Identity{})
+	if aliceClient == nil || bobClient == nil {
+		t.Fatal("Expected both clients to be served")
+	}
+	if welcome := readPipe(t, alice, codec.JSON); welcome.To != aliceClient.ID || !strings.Contains(welcome.Content, "player ID is: alice") {
+		t.Errorf("Unexpected welcome %+v", welcome)
+	}
+	readPipe(t, bob, codec.Msgpack)
+	if p, ok := manager.GetPlayer("alice"); !ok || p.DisplayName != "Alice" {
+		t.Errorf("Expected alice to play under the identity, got %+v", p)
+	}
+	if bobClient.PlayerID() != bobClient.ID || bobClient.IPAddress != "pipe" {
+		t.Errorf("Expected an anonymous client at the pipe address, got %s at %s", bobClient.PlayerID(), bobClient.IPAddress)
+	}
+
+	// Each side gets messages in its own wire format
+	data, _ := codec.JSON.Encode(message.Message{To: bobClient.ID, Content: "hi bob"})
+	alice.WriteMessage(transport.TextMessage, data)
+	if msg := readPipe(t, bob, codec.Msgpack); msg.From != "alice" || msg.Content != "hi bob" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	// Kicking sends the close code over the pipe
+	manager.KickClient(bobClient.ID, "bye")
+	if _, _, err := bob.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected close code 1008, got %v", err)
+	}
+	if _, exists := manager.GetClient(bobClient.ID); exists {
+		t.Error("Expected the kicked client to be unregistered")
+	}
+}
+
+// readPipe decodes the next message on conn with c, failing after a second
+func readPipe(t *testing.T, conn transport.Conn, c codec.Codec) message.Message {
+	t.Helper()
+	type frame struct {
+		data []byte
+		err  error
+	}
+	frames := make(chan frame, 1)
+	go func() {
+		_, data, err := conn.ReadMessage()
+		frames <- frame{data, err}
+	}()
+	select {
+	case f := <-frames:
+		if f.err != nil {
+			t.Fatalf("Error reading: %v", f.err)
+		}
+		msg, err := c.Decode(f.data)
+		if err != nil {
+			t.Fatalf("Error decoding %s: %v", c.Name(), err)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return message.Message{}
+}
+
+// connectPipe serves a JSON client playing as userID over a pipe and reads
+// its welcome message. It returns the test's end of the pipe and the client.
+func connectPipe(t *testing.T, manager *ConnectionManager, userID string) (*transport.PipeConn, *client.Client) {
+	t.Helper()
+	conn, peer := transport.Pipe()
+	c := manager.ServeConn(conn, codec.JSON, auth.Identity{UserID: userID})
+	if c == nil {
+		t.Fatalf("Expected %s to be served", userID)
+	}
+	t.Cleanup(func() { peer.Close() })
+	readPipe(t, peer, codec.JSON)
+	return peer, c
+}
+
+// sendPipe writes msg on conn as JSON
+func sendPipe(t *testing.T, conn transport.Conn, msg message.Message) {
+	t.Helper()
+	data, _ := codec.JSON.Encode(msg)
+	if err := conn.WriteMessage(transport.TextMessage, data); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+}
+
+// readContentPipe reads the next JSON message on conn, checks its content
+// is of wantType and decodes the content into into
+func readContentPipe(t *testing.T, conn transport.Conn, wantType string, into interface{}) {
+	t.Helper()
+	msg := readPipe(t, conn, codec.JSON)
+	if contentType := message.ContentType(msg.Content); contentType != wantType {
+		t.Fatalf("Expected %s, got %+v", wantType, msg)
+	}
+	if err := json.Unmarshal([]byte(msg.Content), into); err != nil {
+		t.Fatalf("Error decoding content: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/lobby"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrLobbiesDisabled answers lobby requests when no lobby manager is set
+	ErrLobbiesDisabled = errors.New("lobbies are disabled")
+	// ErrLobbyNotStarted answers a start request whose session couldn't be created
+	ErrLobbyNotStarted = errors.New("session could not be created")
+)
+
+// SetLobbies lets players create and join private lobbies through lobbies
+func (cm *ConnectionManager) SetLobbies(lobbies *lobby.Manager) {
+	cm.lobbies = lobbies
+}
+
+// HandleLobbyRequest creates, joins, leaves, runs or chats in a lobby on
+// behalf of a client. Members are told the lobby's state after every change.
+func (cm *ConnectionManager) HandleLobbyRequest(c *client.Client, request message.LobbyRequest) {
+	if cm.lobbies == nil {
+		cm.rejectLobbyRequest(c, ErrLobbiesDisabled)
+		return
+	}
+
+	playerID := c.PlayerID()
+	var updated message.Lobby
+	var err error
+	switch request.Type {
+	case message.CreateLobbyType:
+		updated, err = cm.lobbies.Create(lobbyMember(c), request.MaxPlayers)
+	case message.JoinLobbyType:
+		updated, err = cm.lobbies.Join(request.Code, lobbyMember(c))
+	case message.LeaveLobbyType:
+		if updated, err = cm.lobbies.Leave(playerID); err == nil {
+			cm.lobbyLeft(playerID, updated.Code, message.LobbyLeftReasonLeft)
+		}
+	case message.KickFromLobbyType:
+		if updated, err = cm.lobbies.Kick(playerID, request.PlayerID); err == nil {
+			cm.lobbyLeft(request.PlayerID, updated.Code, message.LobbyLeftReasonKicked)
+		}
+	case message.SetLobbyMaxPlayersType:
+		updated, err = cm.lobbies.SetMaxPlayers(playerID, request.MaxPlayers)
+	case message.LockLobbyType, message.UnlockLobbyType:
+		updated, err = cm.lobbies.SetLocked(playerID, request.Type == message.LockLobbyType)
+	case message.StartLobbyType:
+		cm.startLobby(c)
+		return
+	case message.LobbyMessageType:
+		cm.postToLobby(c, request.Text)
+		return
+	}
+	if err != nil {
+		cm.rejectLobbyRequest(c, err)
+		return
+	}
+	cm.logger.Debug("lobby changed", slog.String(logging.KeyPlayerID, playerID), slog.String(logging.KeyMessageType, request.Type), slog.String(logging.KeyLobby, updated.Code))
+	cm.lobbyUpdated(updated)
+}
+
+// startLobby creates a session with the members of the host's lobby and
+// notifies them like a match from the queue. The notification goes straight
+// to the members rather than through the notification channel, so a full
+// channel never holds up the host's read loop.
+func (cm *ConnectionManager) startLobby(c *client.Client) {
+	var session matchmaking.Session
+	started, err := cm.lobbies.Start(c.PlayerID(), func(l message.Lobby) error {
+		var err error
+		session, err = cm.matchmakingService.Sessions.Create(uuid.New().String(), matchmaking.ModeLobby, lobbyPlayers(l))
+		if err != nil {
+			cm.logger.Error("error creating lobby session", slog.String(logging.KeyLobby, l.Code), logging.Err(err))
+			return ErrLobbyNotStarted
+		}
+		return nil
+	})
+	if err != nil {
+		cm.rejectLobbyRequest(c, err)
+		return
+	}
+
+	cm.logger.Info("lobby started", slog.String(logging.KeyLobby, started.Code), slog.String(logging.KeySessionID, session.SessionID))
+	cm.BroadcastToPlayers(lobbyPlayers(started), message.NewServerMessage("", message.LobbyStarted{
+		Type:      message.LobbyStartedType,
+		Code:      started.Code,
+		SessionID: session.SessionID,
+	}))
+	cm.notifySession(notification.SessionNotification{
+		SessionID: session.SessionID,
+		Player1ID: session.Player1ID,
+		Player2ID: session.Player2ID,
+		PlayerIDs: session.Players(),
+		CreatedAt: session.CreatedAt,
+	})
+}
+
+// postToLobby relays a chat message to every member of the sender's lobby,
+// the sender included so every member sees the same order
+func (cm *ConnectionManager) postToLobby(c *client.Client, text string) {
+	l, err := cm.lobbies.Get(c.PlayerID())
+	if err != nil {
+		cm.rejectLobbyRequest(c, err)
+		return
+	}
+	msg := message.NewServerMessage("", message.LobbyMessage{
+		Type:   message.LobbyMessageType,
+		Code:   l.Code,
+		From:   lobbyMember(c),
+		Text:   text,
+		SentAt: time.Now().UTC(),
+	})
+	msg.From = c.PlayerID()
+	cm.BroadcastToPlayers(lobbyPlayers(l), msg)
+}
+
+// leaveLobby takes a player whose last connection closed out of its lobby
+func (cm *ConnectionManager) leaveLobby(playerID string) {
+	if cm.lobbies == nil {
+		return
+	}
+	if updated, err := cm.lobbies.Leave(playerID); err == nil {
+		cm.lobbyUpdated(updated)
+	}
+}
+
+// lobbyUpdated sends the state of a lobby to its members
+func (cm *ConnectionManager) lobbyUpdated(l message.Lobby) {
+	if len(l.Members) == 0 {
+		return
+	}
+	cm.BroadcastToPlayers(lobbyPlayers(l), message.NewServerMessage("", message.LobbyUpdated{
+		Type:  message.LobbyUpdatedType,
+		Lobby: l,
+	}))
+}
+
+// lobbyLeft tells a player it is no longer in a lobby
+func (cm *ConnectionManager) lobbyLeft(playerID, code, reason string) {
+	cm.BroadcastToPlayers([]string{playerID}, message.NewServerMessage("", message.LobbyLeft{
+		Type:   message.LobbyLeftType,
+		Code:   code,
+		Reason: reason,
+	}))
+}
+
+// rejectLobbyRequest answers a lobby request that can't be carried out
+func (cm *ConnectionManager) rejectLobbyRequest(c *client.Client, err error) {
+	cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeLobbyRefused, err.Error()))
+}
+
+func lobbyMember(c *client.Client) message.LobbyMember {
+	member := message.LobbyMember{PlayerID: c.PlayerID()}
+	if c.Player != nil {
+		member.DisplayName = c.Player.DisplayName
+	}
+	return member
+}
+
+// lobbyPlayers returns the player IDs of a lobby's members in join order
+func lobbyPlayers(l message.Lobby) []string {
+	playerIDs := make([]string, 0, len(l.Members))
+	for _, member := range l.Members {
+		playerIDs = append(playerIDs, member.PlayerID)
+	}
+	return playerIDs
+}
@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/codec"
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/lobby"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/transport"
+)
+
+// TestLobby tests that friends meet in a lobby by code, chat, and start a
+// session from it that they are notified of like a match
+func TestLobby(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	mmSvc := matchmaking.NewMatchmakingService(10, sessions, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetLobbies(lobby.NewManager(4))
+
+	host, _ := connectPipe(t, manager, "lobby-host")
+	friend, _ := connectPipe(t, manager, "lobby-friend")
+	stranger, _ := connectPipe(t, manager, "lobby-stranger")
+
+	var updated message.LobbyUpdated
+	sendPipe(t, host, message.Message{Content: `{"type":"createLobby","max_players":3}`})
+	readContentPipe(t, host, message.LobbyUpdatedType, &updated)
+	code := updated.Lobby.Code
+	if len(code) != message.LobbyCodeLength || updated.Lobby.HostID != "lobby-host" {
+		t.Fatalf("Unexpected new lobby %+v", updated.Lobby)
+	}
+
+	join := message.Message{Content: `{"type":"joinLobby","code":"` + code + `"}`}
+	sendPipe(t, friend, join)
+	readContentPipe(t, host, message.LobbyUpdatedType, &updated)
+	readContentPipe(t, friend, message.LobbyUpdatedType, &updated)
+	if len(updated.Lobby.Members) != 2 {
+		t.Errorf("Expected two members, got %+v", updated.Lobby)
+	}
+
+	// Only the host runs the lobby, and a locked lobby takes nobody new
+	var refused message.Error
+	sendPipe(t, friend, message.Message{Content: `{"type":"lockLobby"}`})
+	readContentPipe(t, friend, message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeLobbyRefused {
+		t.Errorf("Expected the friend to be refused, got %+v", refused)
+	}
+	sendPipe(t, host, message.Message{Content: `{"type":"lockLobby"}`})
+	readContentPipe(t, host, message.LobbyUpdatedType, &updated)
+	readContentPipe(t, friend, message.LobbyUpdatedType, &updated)
+	sendPipe(t, stranger, join)
+	readContentPipe(t, stranger, message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeLobbyRefused {
+		t.Errorf("Expected the stranger to be refused, got %+v", refused)
+	}
+
+	var chat message.LobbyMessage
+	sendPipe(t, friend, message.Message{Content: `{"type":"lobbyMessage","text":"ready?"}`})
+	readContentPipe(t, host, message.LobbyMessageType, &chat)
+	if chat.From.PlayerID != "lobby-friend" || chat.Text != "ready?" || chat.Code != code {
+		t.Errorf("Unexpected lobby message %+v", chat)
+	}
+	readContentPipe(t, friend, message.LobbyMessageType, &chat)
+
+	// Only the members are told about the session, with the stranger still connected
+	sendPipe(t, host, message.Message{Content: `{"type":"startLobby"}`})
+	var started message.LobbyStarted
+	readContentPipe(t, host, message.LobbyStartedType, &started)
+	readContentPipe(t, friend, message.LobbyStartedType, &started)
+	for _, conn := range []*transport.PipeConn{host, friend} {
+		var notif notification.SessionNotification
+		if err := json.Unmarshal([]byte(readPipe(t, conn, codec.JSON).Content), &notif); err != nil || notif.SessionID != started.SessionID {
+			t.Fatalf("Expected the session notification, got %+v %v", notif, err)
+		}
+		if len(notif.PlayerIDs) != 2 || notif.PlayerIDs[0] != "lobby-host" || notif.PlayerIDs[1] != "lobby-friend" {
+			t.Errorf("Expected the players in join order, got %v", notif.PlayerIDs)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		session, err := sessions.GetSession(started.SessionID)
+		if err == nil && session.Status == matchmaking.StatusActive {
+			if session.Mode != matchmaking.ModeLobby {
+				t.Errorf("Expected a lobby session, got %+v", session)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the session to start, got %+v %v", session, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
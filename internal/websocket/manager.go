@@ -1,57 +1,204 @@
 package websocket
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
 	"sync"
+	"time"
 
+	"simple-multiplayer-service/internal/abandon"
+	"simple-multiplayer-service/internal/auth"
+	"simple-multiplayer-service/internal/backplane"
 	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/delivery"
+	"simple-multiplayer-service/internal/lobby"
+	"simple-multiplayer-service/internal/logging"
 	"simple-multiplayer-service/internal/matchmaking"
 	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/metrics"
 	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/player"
+	"simple-multiplayer-service/internal/ratelimit"
+	"simple-multiplayer-service/internal/results"
+	"simple-multiplayer-service/internal/room"
+	"simple-multiplayer-service/internal/spectate"
+	"simple-multiplayer-service/internal/transport"
+
+	"github.com/gorilla/websocket"
+)
+
+// Defaults for resending unacknowledged server events
+const (
+	DefaultRetryInterval       = 2 * time.Second
+	DefaultMaxDeliveryAttempts = 5
+)
+
+var (
+	// ErrPlayerAlreadyConnected is returned by RegisterClient under PolicyRejectNew
+	ErrPlayerAlreadyConnected = errors.New("player already connected")
+	// ErrClientNotFound is returned when no connection matches an ID
+	ErrClientNotFound = errors.New("client not found")
 )
 
 // ConnectionManager manages all active WebSocket connections
 type ConnectionManager struct {
 	clients             map[string]*client.Client
+	players             map[string]map[string]*client.Client // player ID -> connection ID -> client
 	mutex               sync.RWMutex
 	matchmakingService  *matchmaking.Service
 	notificationService *notification.Service
+	rooms               *room.Manager
+	spectators          *spectate.Manager
+	results             *results.Service
+	abandon             *abandon.Tracker
+	lobbies             *lobby.Manager
+	messageStore        db.MessageStore
+	delivery            *delivery.Tracker
+	backplane           backplane.Backplane
+	nodeID              string
+	presence            db.Presence
+	presenceTTL         time.Duration
+	authenticator       *auth.Authenticator
+	connectionPolicy    player.ConnectionPolicy
+	upgrader            *websocket.Upgrader
+	upgradeOptions      UpgradeOptions
+	sse                 sseRegistry
+	rateLimiter         *ratelimit.Limiter
+	maxFrameSize        int64
+	maxContentLength    int
+	metrics             *metrics.Metrics
+	logger              *slog.Logger
+	logContent          bool
 }
 
 // NewConnectionManager creates a new connection manager
 func NewConnectionManager(mmSvc *matchmaking.Service, notifSvc *notification.Service) *ConnectionManager {
-	return &ConnectionManager{
+	cm := &ConnectionManager{
 		clients:             make(map[string]*client.Client),
+		players:             make(map[string]map[string]*client.Client),
 		matchmakingService:  mmSvc,
 		notificationService: notifSvc,
+		rooms:               room.NewManager(0),
+		connectionPolicy:    player.PolicyAllow,
+		upgrader:            newUpgrader(DefaultUpgradeOptions()),
+		upgradeOptions:      DefaultUpgradeOptions(),
+		logger:              slog.Default(),
 	}
+	cm.SetDeliveryRetry(DefaultRetryInterval, DefaultMaxDeliveryAttempts)
+	mmSvc.Sessions.Subscribe(cm.sessionChanged)
+	return cm
 }
 
-// RegisterClient adds a new client to the manager
-func (cm *ConnectionManager) RegisterClient(client *client.Client) {
+// RegisterClient adds a new client to the manager, applying the connection
+// policy when its player already has open connections
+func (cm *ConnectionManager) RegisterClient(wsClient *client.Client) error {
 	// Set the client's SendMessageFunc and UnregisterFunc
-	client.SendMessageFunc = cm.SendMessageToClient
-	client.UnregisterFunc = cm.UnregisterClient
+	wsClient.SendMessageFunc = cm.SendMessageToClient
+	wsClient.DeliverMessageFunc = func(msg message.Message) (string, error) {
+		return cm.deliverFrom(wsClient, msg)
+	}
+	wsClient.AckMessageFunc = cm.AckMessage
+	wsClient.UnregisterFunc = cm.UnregisterClient
+	wsClient.RoomRequestFunc = cm.HandleRoomRequest
+	wsClient.SpectateRequestFunc = cm.HandleSpectateRequest
+	wsClient.ResultReportFunc = cm.HandleResultReport
+	wsClient.MatchHistoryFunc = cm.HandleMatchHistory
+	wsClient.LobbyRequestFunc = cm.HandleLobbyRequest
+	wsClient.AdmitMatchmakingFunc = cm.AdmitMatchmaking
 
 	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	cm.clients[client.ID] = client
-	log.Printf("Client registered: %s (IP: %s)", client.ID, client.IPAddress)
+	playerID := wsClient.PlayerID()
+	existing := cm.players[playerID]
+	returning := len(existing) == 0
+	if len(existing) > 0 && cm.connectionPolicy == player.PolicyRejectNew {
+		cm.mutex.Unlock()
+		cm.metrics.ConnectionRejected("player_connected")
+		return ErrPlayerAlreadyConnected
+	}
+
+	var kicked []*client.Client
+	if len(existing) > 0 && cm.connectionPolicy == player.PolicyKickOld {
+		for connectionID, old := range existing {
+			delete(cm.clients, connectionID)
+			kicked = append(kicked, old)
+		}
+		existing = nil
+	}
+	if existing == nil {
+		existing = make(map[string]*client.Client)
+		cm.players[playerID] = existing
+	}
+
+	cm.clients[wsClient.ID] = wsClient
+	existing[wsClient.ID] = wsClient
+	cm.logger.Info("client registered",
+		slog.String(logging.KeyClientID, wsClient.ID),
+		slog.String(logging.KeyPlayerID, playerID),
+		slog.String(logging.KeyIP, wsClient.IPAddress))
+	cm.mutex.Unlock()
+	cm.metrics.ConnectionOpened()
+	cm.registerPresence(wsClient)
+	if returning {
+		cm.playerReturned(playerID)
+	}
+
+	// Close replaced connections outside the lock; their read loops will
+	// find them already unregistered
+	for _, old := range kicked {
+		cm.logger.Info("client replaced by new connection",
+			slog.String(logging.KeyClientID, old.ID),
+			slog.String(logging.KeyPlayerID, playerID))
+		cm.metrics.ConnectionClosed()
+		closeConnection(old, websocket.ClosePolicyViolation, "replaced by new connection")
+		cm.leaveRooms(old)
+		cm.stopSpectating(old)
+		cm.removePresence(old)
+	}
+
+	return nil
 }
 
 // UnregisterClient removes a client from the manager
 func (cm *ConnectionManager) UnregisterClient(clientID string) {
 	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	if _, exists := cm.clients[clientID]; exists {
+	client, exists := cm.clients[clientID]
+	left := false
+	if exists {
 		delete(cm.clients, clientID)
-		log.Printf("Client unregistered: %s", clientID)
-		cm.matchmakingService.ClientDisconnects <- clientID
+		cm.logger.Info("client unregistered",
+			slog.String(logging.KeyClientID, clientID),
+			slog.String(logging.KeyPlayerID, client.PlayerID()))
+		cm.metrics.ConnectionClosed()
+
+		// Only the player's last connection leaving takes them out of matchmaking
+		playerID := client.PlayerID()
+		delete(cm.players[playerID], clientID)
+		if len(cm.players[playerID]) == 0 {
+			delete(cm.players, playerID)
+			left = true
+		}
+	}
+	cm.mutex.Unlock()
+
+	// Matchmaking, room members, spectated sessions, the presence registry,
+	// and the player's lobby and opponents are told outside the lock: a full
+	// disconnect queue must not hold up the senders that need it
+	if exists {
+		cm.leaveRooms(client)
+		cm.stopSpectating(client)
+		cm.removePresence(client)
+	}
+	if left {
+		cm.matchmakingService.ClientDisconnects <- client.PlayerID()
+		cm.leaveLobby(client.PlayerID())
+		cm.playerLeft(client.PlayerID())
 	}
 }
 
-// GetClient retrieves a client by ID
+// GetClient retrieves a client by connection ID
 func (cm *ConnectionManager) GetClient(clientID string) (*client.Client, bool) {
 	cm.mutex.RLock()
 	defer cm.mutex.RUnlock()
@@ -59,17 +206,261 @@ func (cm *ConnectionManager) GetClient(clientID string) (*client.Client, bool) {
 	return client, exists
 }
 
-// SendMessageToClient sends a message to a specific client
-func (cm *ConnectionManager) SendMessageToClient(message message.Message) error {
-	targetClient, exists := cm.GetClient(message.To)
+// ListClients returns every registered connection ordered by connection time
+func (cm *ConnectionManager) ListClients() []*client.Client {
+	cm.mutex.RLock()
+	clients := make([]*client.Client, 0, len(cm.clients))
+	for _, c := range cm.clients {
+		clients = append(clients, c)
+	}
+	cm.mutex.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+	return clients
+}
+
+// KickClient closes a connection with a policy violation close frame and unregisters it
+func (cm *ConnectionManager) KickClient(clientID, reason string) error {
+	target, exists := cm.GetClient(clientID)
 	if !exists {
-		return fmt.Errorf("client with ID %s not found", message.To)
+		return fmt.Errorf("%w: %s", ErrClientNotFound, clientID)
+	}
+
+	cm.logger.Info("kicking client", slog.String(logging.KeyClientID, clientID), slog.String("reason", reason))
+	closeConnection(target, websocket.ClosePolicyViolation, reason)
+	cm.UnregisterClient(clientID)
+	return nil
+}
+
+// Broadcast sends a copy of msg to every connection, addressing each copy to
+// its recipient, and returns how many connections it reached
+func (cm *ConnectionManager) Broadcast(msg message.Message) int {
+	if cm.backplane != nil {
+		if err := cm.publish(backplane.Envelope{Kind: backplane.KindBroadcast, Message: msg}); err != nil {
+			cm.logger.Warn("error publishing broadcast", logging.Err(err))
+		}
+	}
+	return cm.fanOut(cm.ListClients(), msg)
+}
+
+// BroadcastToPlayers sends a copy of msg to every connection of the given players
+func (cm *ConnectionManager) BroadcastToPlayers(playerIDs []string, msg message.Message) int {
+	cm.mutex.RLock()
+	targets := make([]*client.Client, 0, len(playerIDs))
+	var remote []string
+	for _, playerID := range playerIDs {
+		if len(cm.players[playerID]) == 0 {
+			remote = append(remote, playerID)
+		}
+		for _, c := range cm.players[playerID] {
+			targets = append(targets, c)
+		}
+	}
+	cm.mutex.RUnlock()
+
+	// Players not connected here may be connected to another node
+	if cm.backplane != nil {
+		for _, playerID := range remote {
+			msg.To = playerID
+			if err := cm.sendRemote(msg); err != nil && !errors.Is(err, ErrClientNotFound) {
+				cm.logger.Warn("error publishing broadcast", slog.String(logging.KeyPlayerID, playerID), logging.Err(err))
+			}
+		}
+	}
+
+	return cm.fanOut(targets, msg)
+}
+
+// BroadcastToQueue sends a copy of msg to every player waiting for a match
+func (cm *ConnectionManager) BroadcastToQueue(msg message.Message) int {
+	waiting := cm.matchmakingService.Waiting()
+	playerIDs := make([]string, 0, len(waiting))
+	for _, w := range waiting {
+		playerIDs = append(playerIDs, w.PlayerID)
+	}
+	return cm.BroadcastToPlayers(playerIDs, msg)
+}
+
+// BroadcastToSession sends a copy of msg to both players of a session and
+// relays it to the session's spectators
+func (cm *ConnectionManager) BroadcastToSession(session matchmaking.Session, msg message.Message) int {
+	delivered := cm.BroadcastToPlayers(session.Players(), msg)
+	cm.relayToSpectators(session, msg)
+	return delivered
+}
+
+// fanOut writes msg to each target. Callers snapshot the targets so that no
+// lock is held while writing to the network.
+func (cm *ConnectionManager) fanOut(targets []*client.Client, msg message.Message) int {
+	delivered := 0
+	for _, target := range targets {
+		msg.To = target.ID
+		if err := target.WriteMessage(msg); err != nil {
+			cm.metrics.SendFailed()
+			cm.logger.Debug("error broadcasting message", slog.String(logging.KeyClientID, target.ID), logging.Err(err))
+			continue
+		}
+		cm.metrics.MessageSent()
+		delivered++
+	}
+	return delivered
+}
+
+// GetPlayerClients returns every open connection of a player
+func (cm *ConnectionManager) GetPlayerClients(playerID string) []*client.Client {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	clients := make([]*client.Client, 0, len(cm.players[playerID]))
+	for _, c := range cm.players[playerID] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// GetPlayer returns the player behind any of its open connections
+func (cm *ConnectionManager) GetPlayer(playerID string) (*player.Player, bool) {
+	clients := cm.GetPlayerClients(playerID)
+	if len(clients) == 0 {
+		return nil, false
+	}
+	if clients[0].Player != nil {
+		return clients[0].Player, true
+	}
+	return &player.Player{ID: playerID}, true
+}
+
+// SendMessageToClient sends a message to a specific connection, or to every
+// connection of a player when addressed by player ID
+func (cm *ConnectionManager) SendMessageToClient(message message.Message) error {
+	err := cm.sendLocal(message)
+	if errors.Is(err, ErrClientNotFound) && cm.backplane != nil {
+		// The recipient may be connected to another node
+		err = cm.sendRemote(message)
+	}
+	if errors.Is(err, ErrClientNotFound) {
+		cm.metrics.SendFailed()
+	}
+	return err
+}
+
+// sendLocal writes message to the recipient's connections on this node
+func (cm *ConnectionManager) sendLocal(message message.Message) error {
+	targets := cm.resolve(message.To)
+	if len(targets) == 0 {
+		return fmt.Errorf("%w: %s", ErrClientNotFound, message.To)
+	}
+
+	var lastErr error
+	delivered := 0
+	for _, target := range targets {
+		if err := target.WriteMessage(message); err != nil {
+			cm.metrics.SendFailed()
+			lastErr = err
+			continue
+		}
+		cm.metrics.MessageSent()
+		delivered++
+	}
+	if delivered == 0 {
+		return lastErr
+	}
+	return nil
+}
+
+// resolve finds the recipients of a connection or player ID
+func (cm *ConnectionManager) resolve(id string) []*client.Client {
+	if target, exists := cm.GetClient(id); exists {
+		return []*client.Client{target}
+	}
+	return cm.GetPlayerClients(id)
+}
+
+// SetAuthenticator requires upgrade requests to carry a valid token
+func (cm *ConnectionManager) SetAuthenticator(authenticator *auth.Authenticator) {
+	cm.authenticator = authenticator
+}
+
+// SetUpgradeOptions configures origin checks, buffers, subprotocols and
+// compression for subsequent upgrades
+func (cm *ConnectionManager) SetUpgradeOptions(opts UpgradeOptions) {
+	cm.upgrader = newUpgrader(opts)
+	cm.upgradeOptions = opts
+}
+
+// SetRateLimiter limits connections per IP and the message and matchmaking
+// rates of each connection
+func (cm *ConnectionManager) SetRateLimiter(limiter *ratelimit.Limiter) {
+	cm.rateLimiter = limiter
+}
+
+// SetMessageLimits sets the maximum frame size and content length accepted
+// from clients; zero disables a limit
+func (cm *ConnectionManager) SetMessageLimits(maxFrameSize int64, maxContentLength int) {
+	cm.maxFrameSize = maxFrameSize
+	cm.maxContentLength = maxContentLength
+}
+
+// SetMetrics records connection and message metrics
+func (cm *ConnectionManager) SetMetrics(m *metrics.Metrics) {
+	cm.metrics = m
+	cm.delivery.Metrics = m
+}
+
+// SetLogger sets the logger used by the manager and its clients. Message
+// content is only logged when logContent is set.
+func (cm *ConnectionManager) SetLogger(logger *slog.Logger, logContent bool) {
+	cm.logger = logger
+	cm.logContent = logContent
+	cm.delivery.Logger = logger
+}
+
+// SetDeliveryRetry sets how often and how many times server events such as
+// session notifications are sent until the client acknowledges them; one
+// attempt disables retries
+func (cm *ConnectionManager) SetDeliveryRetry(interval time.Duration, maxAttempts int) {
+	if cm.delivery != nil {
+		cm.delivery.Stop()
+	}
+	cm.delivery = delivery.NewTracker(cm.SendMessageToClient, interval, maxAttempts)
+	cm.delivery.Metrics = cm.metrics
+	cm.delivery.Logger = cm.logger
+}
+
+// SendReliable sends a server event and resends it until the recipient
+// acknowledges it with an ackMessage carrying the event's ID. On a backplane,
+// events without an ID get one naming this node so that acks received by
+// other nodes come back here.
+func (cm *ConnectionManager) SendReliable(msg message.Message) error {
+	if msg.ID == "" && cm.backplane != nil {
+		msg.ID = reliableID(cm.nodeID)
 	}
+	return cm.delivery.Send(msg)
+}
+
+// SetRooms replaces the room manager, e.g. to keep room history
+func (cm *ConnectionManager) SetRooms(rooms *room.Manager) {
+	cm.rooms = rooms
+}
 
-	return targetClient.Connection.WriteJSON(message)
+// SetConnectionPolicy sets how additional connections of an already connected player are handled
+func (cm *ConnectionManager) SetConnectionPolicy(policy player.ConnectionPolicy) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.connectionPolicy = policy
 }
 
 // StartMatchmakingService start the matchmaking service
 func (cm *ConnectionManager) StartMatchmakingService() {
 	cm.matchmakingService.Start()
 }
+
+// closeConnection sends a close frame to the client and closes its connection
+func closeConnection(c *client.Client, code int, reason string) {
+	if c.Connection == nil {
+		return
+	}
+	_ = transport.WriteClose(c.Connection, code, reason)
+	c.Connection.Close()
+}
@@ -1,12 +1,15 @@
 package websocket
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"simple-multiplayer-service/internal/client"
 	"simple-multiplayer-service/internal/db/local"
 	"simple-multiplayer-service/internal/matchmaking"
 	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/player"
 )
 
 // TestConnectionManager tests the ConnectionManager functionality
@@ -49,3 +52,114 @@ func TestConnectionManager(t *testing.T) {
 		t.Errorf("Expected 0 clients, got %d", len(manager.clients))
 	}
 }
+
+// TestConnectionManagerPlayerConnections tests that a player can hold several connections
+func TestConnectionManagerPlayerConnections(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+
+	alice := &player.Player{ID: "alice", DisplayName: "Alice"}
+	tab1 := &client.Client{ID: "tab1", Player: alice}
+	tab2 := &client.Client{ID: "tab2", Player: alice}
+
+	if err := manager.RegisterClient(tab1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := manager.RegisterClient(tab2); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if clients := manager.GetPlayerClients("alice"); len(clients) != 2 {
+		t.Errorf("Expected 2 connections for alice, got %d", len(clients))
+	}
+	p, exists := manager.GetPlayer("alice")
+	if !exists || p.DisplayName != "Alice" {
+		t.Errorf("Expected to find player Alice, got %v", p)
+	}
+
+	// Closing one tab keeps the player in matchmaking
+	manager.UnregisterClient("tab1")
+	select {
+	case playerID := <-mmSvc.ClientDisconnects:
+		t.Errorf("Expected no disconnect while tab2 is open, got %s", playerID)
+	default:
+	}
+
+	// Closing the last tab takes the player out
+	manager.UnregisterClient("tab2")
+	select {
+	case playerID := <-mmSvc.ClientDisconnects:
+		if playerID != "alice" {
+			t.Errorf("Expected disconnect for 'alice', got '%s'", playerID)
+		}
+	default:
+		t.Error("Expected disconnect once the last connection closed")
+	}
+	if _, exists := manager.GetPlayer("alice"); exists {
+		t.Error("Expected alice to be gone after all connections closed")
+	}
+}
+
+// TestUnregisterWithFullDisconnectQueue tests that a player leaving while
+// matchmaking is behind doesn't hold the manager lock
+func TestUnregisterWithFullDisconnectQueue(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	mmSvc.ClientDisconnects = make(chan string)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.RegisterClient(&client.Client{ID: "bob-tab", Player: &player.Player{ID: "bob"}})
+
+	go manager.UnregisterClient("bob-tab")
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, exists := manager.GetClient("bob-tab"); !exists {
+				close(done)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the manager to stay usable while the disconnect waits")
+	}
+	if playerID := <-mmSvc.ClientDisconnects; playerID != "bob" {
+		t.Errorf("Expected disconnect for 'bob', got '%s'", playerID)
+	}
+}
+
+// TestConnectionManagerPolicies tests the reject-new and kick-old connection policies
+func TestConnectionManagerPolicies(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	bob := &player.Player{ID: "bob"}
+
+	// reject-new refuses the second connection
+	manager.SetConnectionPolicy(player.PolicyRejectNew)
+	if err := manager.RegisterClient(&client.Client{ID: "first", Player: bob}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := manager.RegisterClient(&client.Client{ID: "second", Player: bob}); !errors.Is(err, ErrPlayerAlreadyConnected) {
+		t.Errorf("Expected ErrPlayerAlreadyConnected, got %v", err)
+	}
+	if _, exists := manager.GetClient("second"); exists {
+		t.Error("Expected rejected connection not to be registered")
+	}
+
+	// kick-old replaces the existing connection
+	manager.SetConnectionPolicy(player.PolicyKickOld)
+	if err := manager.RegisterClient(&client.Client{ID: "third", Player: bob}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, exists := manager.GetClient("first"); exists {
+		t.Error("Expected old connection to be removed")
+	}
+	clients := manager.GetPlayerClients("bob")
+	if len(clients) != 1 || clients[0].ID != "third" {
+		t.Errorf("Expected only the new connection for bob, got %v", clients)
+	}
+}
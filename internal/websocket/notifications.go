@@ -0,0 +1,57 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+)
+
+// StartNotifications delivers the session notifications of the matchmaking
+// service to their players until ctx is done. This is the only reader of the
+// notification channel, so each notification reaches its players whichever
+// connection or node they are on, and starts its session once.
+func (cm *ConnectionManager) StartNotifications(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notif := <-cm.notificationService.Channel:
+			cm.notifySession(notif)
+		}
+	}
+}
+
+// notifySession sends a session notification to each of its players and
+// makes the session active once they were all told
+func (cm *ConnectionManager) notifySession(notif notification.SessionNotification) {
+	logger := cm.logger.With(slog.String(logging.KeySessionID, notif.SessionID))
+	content, err := json.Marshal(notif)
+	if err != nil {
+		logger.Error("error marshalling session", logging.Err(err))
+		return
+	}
+
+	logger.Info("handling session created notification")
+	delivered := true
+	for _, playerID := range notif.Players() {
+		logger.Debug("sending session notification", slog.String(logging.KeyRecipient, playerID))
+		err := cm.SendReliable(message.Message{From: "Server", To: playerID, Content: string(content)})
+		if err != nil {
+			logger.Warn("error sending session notification", slog.String(logging.KeyRecipient, playerID), logging.Err(err))
+			delivered = false
+		}
+	}
+	if !delivered {
+		return
+	}
+
+	cm.metrics.NotificationDelivered(notif.CreatedAt)
+	if _, err := cm.matchmakingService.Sessions.Transition(notif.SessionID, matchmaking.StatusActive); err != nil {
+		logger.Warn("error starting session", logging.Err(err))
+	}
+}
@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"simple-multiplayer-service/internal/backplane"
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/message"
+)
+
+// SetMessageStore keeps direct messages to offline players in store and
+// delivers them when the player next connects
+func (cm *ConnectionManager) SetMessageStore(store db.MessageStore) {
+	cm.messageStore = store
+}
+
+// DeliverMessage sends a player's direct message, storing it for later when
+// the recipient has no open connection and a message store is configured.
+// Only messages to authenticated players who connected before are stored, so
+// made-up recipients can't fill the store. It returns the receipt status for
+// the sender.
+func (cm *ConnectionManager) DeliverMessage(msg message.Message) (string, error) {
+	err := cm.SendMessageToClient(msg)
+	if err == nil {
+		return message.ReceiptDelivered, nil
+	}
+	if cm.messageStore == nil || !errors.Is(err, ErrClientNotFound) {
+		return message.ReceiptFailed, err
+	}
+
+	stored, err := cm.messageStore.StoreMessage(msg.To, msg)
+	if err != nil {
+		return message.ReceiptFailed, fmt.Errorf("storing message for %s: %w", msg.To, err)
+	}
+	cm.logger.Debug("stored message for offline player",
+		slog.String(logging.KeyPlayerID, msg.To),
+		slog.String("message_id", stored.ID))
+	return message.ReceiptStored, nil
+}
+
+// deliverOfflineMessages sends a newly connected client the messages its
+// player received while offline. They stay stored until acknowledged, so a
+// connection dropping before the ack gets them again next time. Anonymous
+// clients play under their connection ID and never collect messages.
+func (cm *ConnectionManager) deliverOfflineMessages(c *client.Client) {
+	if cm.messageStore == nil || c.PlayerID() == c.ID {
+		return
+	}
+	if err := cm.messageStore.AddPlayer(c.PlayerID()); err != nil {
+		cm.logger.Warn("error recording offline message recipient", slog.String(logging.KeyPlayerID, c.PlayerID()), logging.Err(err))
+	}
+
+	pending, err := cm.messageStore.PendingMessages(c.PlayerID())
+	if err != nil {
+		cm.logger.Warn("error loading offline messages", slog.String(logging.KeyPlayerID, c.PlayerID()), logging.Err(err))
+		return
+	}
+
+	for _, stored := range pending {
+		err := c.WriteMessage(message.NewServerMessage(c.ID, message.OfflineMessage{
+			Type:    message.OfflineMessageType,
+			ID:      stored.ID,
+			From:    stored.Message.From,
+			Content: stored.Message.Content,
+			SentAt:  stored.StoredAt,
+		}))
+		if err != nil {
+			cm.metrics.SendFailed()
+			cm.logger.Warn("error delivering offline message", slog.String(logging.KeyClientID, c.ID), logging.Err(err))
+			return
+		}
+		cm.metrics.MessageSent()
+	}
+	if len(pending) > 0 {
+		cm.logger.Info("delivered offline messages", slog.String(logging.KeyClientID, c.ID), slog.Int("messages", len(pending)))
+	}
+}
+
+// AckMessage stops resending a server event or deletes an offline message
+// the client has processed. Acks for events sent by another node go to that
+// node only.
+func (cm *ConnectionManager) AckMessage(c *client.Client, ack message.AckMessage) {
+	if cm.delivery.Ack(ack.ID, c.ID, c.PlayerID()) {
+		return
+	}
+	if cm.messageStore != nil {
+		err := cm.messageStore.AckMessage(c.PlayerID(), ack.ID)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, db.ErrMessageNotFound) {
+			cm.logger.Warn("error acknowledging offline message", slog.String(logging.KeyClientID, c.ID), slog.String("message_id", ack.ID), logging.Err(err))
+			return
+		}
+	}
+
+	origin, ok := reliableOrigin(ack.ID)
+	if cm.backplane == nil || !ok || origin == cm.nodeID {
+		cm.logger.Debug("ack for unknown message", slog.String(logging.KeyClientID, c.ID), slog.String("message_id", ack.ID))
+		return
+	}
+	err := cm.publishToNode(origin, backplane.Envelope{Kind: backplane.KindAck, Ack: &backplane.Ack{
+		MessageID:    ack.ID,
+		ConnectionID: c.ID,
+		PlayerID:     c.PlayerID(),
+	}})
+	if err != nil {
+		cm.logger.Warn("error publishing ack", slog.String(logging.KeyClientID, c.ID), slog.String("origin", origin), logging.Err(err))
+	}
+}
@@ -0,0 +1,140 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/auth"
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// TestOfflineMessages tests that messages to offline players are delivered on connect until acknowledged
+func TestOfflineMessages(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	store := local.NewMessageStore(10)
+	manager.SetMessageStore(store)
+
+	authenticator, err := auth.NewAuthenticator(auth.Options{HMACSecret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+	manager.SetAuthenticator(authenticator)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	dial := func(userID string) *websocket.Conn {
+		t.Helper()
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}).SignedString([]byte("secret"))
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatalf("Could not connect as %s: %v", userID, err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		var welcome message.Message
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Fatalf("Error reading welcome message: %v", err)
+		}
+		return conn
+	}
+	readOffline := func(conn *websocket.Conn) message.OfflineMessage {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg message.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Error reading offline message: %v", err)
+		}
+		var offline message.OfflineMessage
+		json.Unmarshal([]byte(msg.Content), &offline)
+		if offline.Type != message.OfflineMessageType {
+			t.Fatalf("Expected an offline message, got %s", msg.Content)
+		}
+		return offline
+	}
+
+	readReceipt := func(conn *websocket.Conn) message.Receipt {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg message.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Error reading receipt: %v", err)
+		}
+		var receipt message.Receipt
+		json.Unmarshal([]byte(msg.Content), &receipt)
+		return receipt
+	}
+
+	// Only players who connected before get a mailbox
+	bob := dial("bob")
+	if err := bob.WriteJSON(message.Message{ID: "m1", To: "nobody", Content: "hello?"}); err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+	if receipt := readReceipt(bob); receipt.ID != "m1" || receipt.Status != message.ReceiptFailed {
+		t.Errorf("Expected a failed receipt for an unknown player, got %+v", receipt)
+	}
+	if pending, _ := store.PendingMessages("nobody"); len(pending) != 0 {
+		t.Errorf("Expected nothing stored for an unknown player, got %v", pending)
+	}
+
+	// Bob writes to alice while she is offline
+	dial("alice").Close()
+	deadline := time.Now().Add(time.Second)
+	for len(manager.ListClients()) > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := bob.WriteJSON(message.Message{ID: "m2", To: "alice", Content: "see you later"}); err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+	if receipt := readReceipt(bob); receipt.ID != "m2" || receipt.Status != message.ReceiptStored {
+		t.Errorf("Expected a stored receipt, got %+v", receipt)
+	}
+	deadline = time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if pending, _ := store.PendingMessages("alice"); len(pending) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Alice gets it on connect, and again on reconnect until she acknowledges it
+	alice := dial("alice")
+	offline := readOffline(alice)
+	if offline.From != "bob" || offline.Content != "see you later" {
+		t.Errorf("Unexpected offline message %+v", offline)
+	}
+	alice.Close()
+
+	alice = dial("alice")
+	offline = readOffline(alice)
+	content, _ := json.Marshal(message.AckMessage{Type: message.AckMessageType, ID: offline.ID})
+	if err := alice.WriteJSON(message.Message{Content: string(content)}); err != nil {
+		t.Fatalf("Error sending ack: %v", err)
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if pending, _ := store.PendingMessages("alice"); len(pending) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected the acknowledged message to be deleted")
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"simple-multiplayer-service/internal/backplane"
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/db"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/message"
+)
+
+// DefaultPresenceTTL is how long a connection stays registered without a refresh
+const DefaultPresenceTTL = 30 * time.Second
+
+// SetPresence records which node each connection is open on in a registry
+// shared by the nodes, so messages for remote recipients go straight to
+// their node. Entries expire after ttl unless StartPresence refreshes them.
+func (cm *ConnectionManager) SetPresence(presence db.Presence, ttl time.Duration) {
+	cm.presence = presence
+	cm.presenceTTL = ttl
+}
+
+// StartPresence refreshes this node's connections in the registry until ctx is done
+func (cm *ConnectionManager) StartPresence(ctx context.Context) {
+	if cm.presence == nil {
+		return
+	}
+
+	ticker := time.NewTicker(cm.presenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, c := range cm.ListClients() {
+				cm.registerPresence(c)
+			}
+		}
+	}
+}
+
+// registerPresence records that c is open on this node
+func (cm *ConnectionManager) registerPresence(c *client.Client) {
+	if cm.presence == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := cm.presence.SetPresence(ctx, cm.nodeID, c.ID, c.PlayerID(), cm.presenceTTL); err != nil {
+		cm.logger.Warn("error registering presence", slog.String(logging.KeyClientID, c.ID), logging.Err(err))
+	}
+}
+
+// removePresence forgets a closed connection
+func (cm *ConnectionManager) removePresence(c *client.Client) {
+	if cm.presence == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := cm.presence.RemovePresence(ctx, c.ID, c.PlayerID()); err != nil {
+		cm.logger.Warn("error removing presence", slog.String(logging.KeyClientID, c.ID), logging.Err(err))
+	}
+}
+
+// sendRemote passes msg to the nodes holding its recipient. Without a
+// registry it is published to every node; with one, a recipient connected
+// nowhere gets ErrClientNotFound so the message can be stored for later.
+func (cm *ConnectionManager) sendRemote(msg message.Message) error {
+	if cm.presence == nil {
+		return cm.publish(backplane.Envelope{Kind: backplane.KindMessage, Message: msg})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	nodes, err := cm.recipientNodes(ctx, msg.To)
+	if err != nil {
+		cm.logger.Warn("error looking up presence, publishing to every node", slog.String(logging.KeyRecipient, msg.To), logging.Err(err))
+		return cm.publish(backplane.Envelope{Kind: backplane.KindMessage, Message: msg})
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("%w: %s", ErrClientNotFound, msg.To)
+	}
+
+	for _, nodeID := range nodes {
+		err = cm.backplane.PublishToNode(ctx, nodeID, backplane.Envelope{Kind: backplane.KindMessage, Origin: cm.nodeID, Message: msg})
+	}
+	return err
+}
+
+// recipientNodes finds the nodes holding a connection or player ID, other than this one
+func (cm *ConnectionManager) recipientNodes(ctx context.Context, id string) ([]string, error) {
+	nodeID, err := cm.presence.ConnectionNode(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []string{nodeID}
+	if nodeID == "" {
+		if nodes, err = cm.presence.PlayerNodes(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	remote := make([]string, 0, len(nodes))
+	for _, nodeID := range nodes {
+		if nodeID != cm.nodeID {
+			remote = append(remote, nodeID)
+		}
+	}
+	return remote, nil
+}
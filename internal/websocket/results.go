@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/results"
+)
+
+// ErrResultsDisabled answers result reports when no result service is set
+var ErrResultsDisabled = errors.New("results are disabled")
+
+// SetResults lets players report session results and read their match
+// history through svc
+func (cm *ConnectionManager) SetResults(svc *results.Service) {
+	cm.results = svc
+}
+
+// HandleResultReport records the result a player reports for one of its sessions
+func (cm *ConnectionManager) HandleResultReport(c *client.Client, report message.ResultReport) {
+	if cm.results == nil {
+		cm.rejectResultReport(c, report, ErrResultsDisabled)
+		return
+	}
+
+	status, err := cm.results.Report(report.SessionID, c.PlayerID(), report.Outcome())
+	if err != nil {
+		cm.rejectResultReport(c, report, err)
+		return
+	}
+	cm.sendToClient(c, message.NewServerMessage(c.ID, message.ResultReceived{
+		Type:      message.ResultReceivedType,
+		SessionID: report.SessionID,
+		Status:    status,
+	}))
+}
+
+// HandleMatchHistory answers a player with its last finished sessions
+func (cm *ConnectionManager) HandleMatchHistory(c *client.Client, request message.MatchHistoryRequest) {
+	if cm.results == nil {
+		cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeResultRefused, ErrResultsDisabled.Error()))
+		return
+	}
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = message.MaxMatchHistory
+	}
+	sessions, err := cm.results.History(c.PlayerID(), limit)
+	if err != nil {
+		cm.logger.Error("error reading match history", slog.String(logging.KeyPlayerID, c.PlayerID()), logging.Err(err))
+		cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeResultRefused, "match history unavailable"))
+		return
+	}
+
+	matches := make([]message.MatchRecord, 0, len(sessions))
+	for _, session := range sessions {
+		matches = append(matches, matchRecord(session))
+	}
+	cm.sendToClient(c, message.NewServerMessage(c.ID, message.MatchHistory{
+		Type:    message.MatchHistoryType,
+		Matches: matches,
+	}))
+}
+
+// rejectResultReport answers a result report that can't be recorded
+func (cm *ConnectionManager) rejectResultReport(c *client.Client, report message.ResultReport, err error) {
+	cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeResultRefused, err.Error()+": "+report.SessionID))
+}
+
+// matchRecord describes a finished session for match history
+func matchRecord(session matchmaking.Session) message.MatchRecord {
+	record := message.MatchRecord{
+		SessionID: session.SessionID,
+		Player1ID: session.Player1ID,
+		Player2ID: session.Player2ID,
+		Players:   session.Players(),
+		Status:    string(session.Status),
+	}
+	if session.EndedAt != nil {
+		record.EndedAt = *session.EndedAt
+	}
+	if session.Outcome != nil {
+		record.Outcome = *session.Outcome
+	}
+	return record
+}
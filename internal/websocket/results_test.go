@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/codec"
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/results"
+)
+
+// TestResults tests that players finish a session by reporting the same
+// result and then find it in their match history
+func TestResults(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	mmSvc := matchmaking.NewMatchmakingService(10, sessions, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetResults(results.NewService(sessions, mmSvc.Sessions, time.Minute))
+	mmSvc.Sessions.Create("reported-session", matchmaking.ModeMatchmaking, []string{"alice", "bob"})
+	mmSvc.Sessions.Transition("reported-session", matchmaking.StatusActive)
+
+	alice, _ := connectPipe(t, manager, "alice")
+	bob, _ := connectPipe(t, manager, "bob")
+	carol, _ := connectPipe(t, manager, "carol")
+
+	report := message.Message{Content: `{"type":"reportResult","session_id":"reported-session","winner_id":"bob"}`}
+	sendPipe(t, carol, report)
+	var refused message.Error
+	readContentPipe(t, carol, message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeResultRefused {
+		t.Errorf("Expected a report from outside the session to be refused, got %+v", refused)
+	}
+
+	sendPipe(t, alice, report)
+	var received message.ResultReceived
+	readContentPipe(t, alice, message.ResultReceivedType, &received)
+	if received.Status != message.ResultPending {
+		t.Errorf("Expected the first report to be pending, got %+v", received)
+	}
+
+	sendPipe(t, bob, report)
+	// Both players are told the outcome; bob also gets the receipt
+	var finished message.SessionFinished
+	readContentPipe(t, alice, message.SessionFinishedType, &finished)
+	if finished.Type != message.SessionFinishedType || finished.Outcome.WinnerID != "bob" || finished.Outcome.Resolution != message.ResolutionAgreed {
+		t.Errorf("Unexpected finish notice %+v", finished)
+	}
+	for i := 0; i < 2; i++ {
+		msg := readPipe(t, bob, codec.JSON)
+		if contentType := message.ContentType(msg.Content); contentType != message.SessionFinishedType && contentType != message.ResultReceivedType {
+			t.Errorf("Unexpected message to bob %+v", msg)
+		}
+	}
+
+	sendPipe(t, alice, message.Message{Content: `{"type":"matchHistory"}`})
+	var history message.MatchHistory
+	readContentPipe(t, alice, message.MatchHistoryType, &history)
+	if len(history.Matches) != 1 || history.Matches[0].SessionID != "reported-session" || history.Matches[0].Outcome.WinnerID != "bob" {
+		t.Errorf("Unexpected match history %+v", history)
+	}
+}
+
+// TestResultsLobbySession tests that a lobby session waits for every player's
+// report and lists them all in match history
+func TestResultsLobbySession(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	mmSvc := matchmaking.NewMatchmakingService(10, sessions, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetResults(results.NewService(sessions, mmSvc.Sessions, time.Minute))
+	mmSvc.Sessions.Create("reported-lobby", matchmaking.ModeLobby, []string{"gina", "hank", "ivan"})
+	mmSvc.Sessions.Transition("reported-lobby", matchmaking.StatusActive)
+
+	report := message.Message{Content: `{"type":"reportResult","session_id":"reported-lobby","winner_id":"ivan"}`}
+	for _, playerID := range []string{"gina", "hank"} {
+		conn, _ := connectPipe(t, manager, playerID)
+		sendPipe(t, conn, report)
+		var received message.ResultReceived
+		readContentPipe(t, conn, message.ResultReceivedType, &received)
+		if received.Status != message.ResultPending {
+			t.Errorf("Expected %s's report to be pending, got %+v", playerID, received)
+		}
+	}
+
+	ivan, _ := connectPipe(t, manager, "ivan")
+	sendPipe(t, ivan, report)
+	// ivan gets the receipt and the outcome in either order
+	for i := 0; i < 2; i++ {
+		msg := readPipe(t, ivan, codec.JSON)
+		switch message.ContentType(msg.Content) {
+		case message.ResultReceivedType:
+		case message.SessionFinishedType:
+			var finished message.SessionFinished
+			json.Unmarshal([]byte(msg.Content), &finished)
+			if finished.Outcome.WinnerID != "ivan" || finished.Outcome.Resolution != message.ResolutionAgreed {
+				t.Errorf("Unexpected finish notice %+v", finished)
+			}
+		default:
+			t.Errorf("Unexpected message to ivan %+v", msg)
+		}
+	}
+
+	sendPipe(t, ivan, message.Message{Content: `{"type":"matchHistory"}`})
+	var history message.MatchHistory
+	readContentPipe(t, ivan, message.MatchHistoryType, &history)
+	if len(history.Matches) != 1 || len(history.Matches[0].Players) != 3 || history.Matches[0].Players[2] != "ivan" {
+		t.Errorf("Expected the record to list every player, got %+v", history)
+	}
+}
@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"log/slog"
+	"time"
+
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/message"
+)
+
+// HandleRoomRequest joins, leaves, lists or posts to a room on behalf of a client
+func (cm *ConnectionManager) HandleRoomRequest(c *client.Client, request message.RoomRequest) {
+	member := roomMember(c)
+
+	switch request.Type {
+	case message.JoinRoomType:
+		members, history, joined := cm.rooms.Join(request.Room, member)
+		cm.sendToClient(c, message.NewServerMessage(c.ID, message.RoomJoined{
+			Type:    message.RoomJoinedType,
+			Room:    request.Room,
+			Members: members,
+			History: history,
+		}))
+		if joined {
+			cm.logger.Debug("client joined room", slog.String(logging.KeyClientID, c.ID), slog.String(logging.KeyRoom, request.Room))
+			cm.roomEvent(request.Room, message.RoomMemberJoinedType, member, others(members, c.ID))
+		}
+
+	case message.LeaveRoomType:
+		remaining, err := cm.rooms.Leave(request.Room, c.ID)
+		if err != nil {
+			cm.rejectRoomRequest(c, request, err)
+			return
+		}
+		cm.logger.Debug("client left room", slog.String(logging.KeyClientID, c.ID), slog.String(logging.KeyRoom, request.Room))
+		cm.roomEvent(request.Room, message.RoomMemberLeftType, member, append(remaining, member))
+
+	case message.ListRoomMembersType:
+		cm.sendToClient(c, message.NewServerMessage(c.ID, message.RoomMembers{
+			Type:    message.RoomMembersType,
+			Room:    request.Room,
+			Members: cm.rooms.Members(request.Room),
+		}))
+
+	case message.RoomMessageType:
+		roomMessage := message.RoomMessage{
+			Type:   message.RoomMessageType,
+			Room:   request.Room,
+			From:   member,
+			Text:   request.Text,
+			SentAt: time.Now().UTC(),
+		}
+		members, err := cm.rooms.Post(roomMessage)
+		if err != nil {
+			cm.rejectRoomRequest(c, request, err)
+			return
+		}
+		// The sender gets its own message back so every member sees the same order
+		msg := message.NewServerMessage("", roomMessage)
+		msg.From = member.PlayerID
+		cm.fanOut(cm.roomClients(members), msg)
+	}
+}
+
+// leaveRooms removes a closed connection from its rooms and tells the remaining members
+func (cm *ConnectionManager) leaveRooms(c *client.Client) {
+	member := roomMember(c)
+	for name, remaining := range cm.rooms.LeaveAll(c.ID) {
+		cm.roomEvent(name, message.RoomMemberLeftType, member, remaining)
+	}
+}
+
+// roomEvent tells recipients that member joined or left a room
+func (cm *ConnectionManager) roomEvent(name, eventType string, member message.RoomMember, recipients []message.RoomMember) {
+	cm.fanOut(cm.roomClients(recipients), message.NewServerMessage("", message.RoomMemberEvent{
+		Type:   eventType,
+		Room:   name,
+		Member: member,
+	}))
+}
+
+// roomClients resolves room members to their open connections
+func (cm *ConnectionManager) roomClients(members []message.RoomMember) []*client.Client {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	clients := make([]*client.Client, 0, len(members))
+	for _, member := range members {
+		if c, exists := cm.clients[member.ConnectionID]; exists {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// rejectRoomRequest answers a room request the client isn't allowed to make
+func (cm *ConnectionManager) rejectRoomRequest(c *client.Client, request message.RoomRequest, err error) {
+	cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeNotInRoom, err.Error()+": "+request.Room))
+}
+
+// sendToClient writes msg to a single connection
+func (cm *ConnectionManager) sendToClient(c *client.Client, msg message.Message) {
+	cm.fanOut([]*client.Client{c}, msg)
+}
+
+func roomMember(c *client.Client) message.RoomMember {
+	member := message.RoomMember{ConnectionID: c.ID, PlayerID: c.PlayerID()}
+	if c.Player != nil {
+		member.DisplayName = c.Player.DisplayName
+	}
+	return member
+}
+
+// others returns members without the given connection
+func others(members []message.RoomMember, connectionID string) []message.RoomMember {
+	filtered := make([]message.RoomMember, 0, len(members))
+	for _, member := range members {
+		if member.ConnectionID != connectionID {
+			filtered = append(filtered, member)
+		}
+	}
+	return filtered
+}
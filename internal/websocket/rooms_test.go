@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/room"
+
+	"github.com/gorilla/websocket"
+)
+
+// roomTestClient is a websocket connection speaking the room protocol
+type roomTestClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+	id   string
+}
+
+func dialRoomClient(t *testing.T, wsURL string) *roomTestClient {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var welcome message.Message
+	if err := conn.ReadJSON(&welcome); err != nil {
+		t.Fatalf("Error reading welcome message: %v", err)
+	}
+	return &roomTestClient{t: t, conn: conn, id: welcome.To}
+}
+
+func (c *roomTestClient) send(request message.RoomRequest) {
+	c.t.Helper()
+	content, _ := json.Marshal(request)
+	if err := c.conn.WriteJSON(message.Message{Content: string(content)}); err != nil {
+		c.t.Fatalf("Error sending room request: %v", err)
+	}
+}
+
+// expect reads the next message, checks its content type and decodes the content into v
+func (c *roomTestClient) expect(contentType string, v interface{}) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg message.Message
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		c.t.Fatalf("Error reading %s: %v", contentType, err)
+	}
+	if got := message.ContentType(msg.Content); got != contentType {
+		c.t.Fatalf("Expected %s, got %s", contentType, msg.Content)
+	}
+	if v != nil {
+		json.Unmarshal([]byte(msg.Content), v)
+	}
+}
+
+// TestRooms tests joining, posting to, listing and leaving chat rooms
+func TestRooms(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetRooms(room.NewManager(10))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(manager, w, r)
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	alice := dialRoomClient(t, wsURL)
+	bob := dialRoomClient(t, wsURL)
+
+	var joined message.RoomJoined
+	alice.send(message.RoomRequest{Type: message.JoinRoomType, Room: "lobby"})
+	alice.expect(message.RoomJoinedType, &joined)
+	if len(joined.Members) != 1 {
+		t.Errorf("Expected alice alone in the lobby, got %v", joined.Members)
+	}
+
+	bob.send(message.RoomRequest{Type: message.JoinRoomType, Room: "lobby"})
+	bob.expect(message.RoomJoinedType, &joined)
+	if len(joined.Members) != 2 {
+		t.Errorf("Expected 2 members after bob joined, got %v", joined.Members)
+	}
+	var event message.RoomMemberEvent
+	alice.expect(message.RoomMemberJoinedType, &event)
+	if event.Member.ConnectionID != bob.id {
+		t.Errorf("Expected bob to have joined, got %v", event.Member)
+	}
+
+	// A post reaches every member, the sender included
+	alice.send(message.RoomRequest{Type: message.RoomMessageType, Room: "lobby", Text: "hello"})
+	for _, c := range []*roomTestClient{alice, bob} {
+		var roomMessage message.RoomMessage
+		c.expect(message.RoomMessageType, &roomMessage)
+		if roomMessage.Text != "hello" || roomMessage.From.ConnectionID != alice.id {
+			t.Errorf("Unexpected room message %+v", roomMessage)
+		}
+	}
+
+	// Late joiners get the history
+	carol := dialRoomClient(t, wsURL)
+	carol.send(message.RoomRequest{Type: message.JoinRoomType, Room: "lobby"})
+	carol.expect(message.RoomJoinedType, &joined)
+	if len(joined.History) != 1 || joined.History[0].Text != "hello" {
+		t.Errorf("Expected history with one message, got %v", joined.History)
+	}
+	alice.expect(message.RoomMemberJoinedType, nil)
+	bob.expect(message.RoomMemberJoinedType, nil)
+
+	var members message.RoomMembers
+	carol.send(message.RoomRequest{Type: message.ListRoomMembersType, Room: "lobby"})
+	carol.expect(message.RoomMembersType, &members)
+	if len(members.Members) != 3 {
+		t.Errorf("Expected 3 members, got %v", members.Members)
+	}
+
+	// Posting to a room that wasn't joined is refused
+	var refused message.Error
+	carol.send(message.RoomRequest{Type: message.RoomMessageType, Room: "elsewhere", Text: "hi"})
+	carol.expect(message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeNotInRoom {
+		t.Errorf("Expected code %s, got %s", message.ErrorCodeNotInRoom, refused.Code)
+	}
+
+	// Leaving and disconnecting both tell the remaining members
+	carol.send(message.RoomRequest{Type: message.LeaveRoomType, Room: "lobby"})
+	carol.expect(message.RoomMemberLeftType, nil)
+	alice.expect(message.RoomMemberLeftType, nil)
+	bob.expect(message.RoomMemberLeftType, nil)
+
+	bob.conn.Close()
+	alice.expect(message.RoomMemberLeftType, &event)
+	if event.Member.ConnectionID != bob.id {
+		t.Errorf("Expected bob to have left, got %v", event.Member)
+	}
+}
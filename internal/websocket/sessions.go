@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+)
+
+// sessionChanged tells the players and spectators of a session when it
+// pauses, resumes, finishes or is abandoned. Players learn about new
+// sessions from the session notification, which also starts them.
+func (cm *ConnectionManager) sessionChanged(event matchmaking.SessionEvent) {
+	session := event.Session
+	switch {
+	case event.To == matchmaking.StatusProposed:
+		return
+	case event.From == matchmaking.StatusProposed && event.To == matchmaking.StatusActive:
+		return
+	case event.To == matchmaking.StatusFinished:
+		record := matchRecord(session)
+		cm.BroadcastToSession(session, message.NewServerMessage("", message.SessionFinished{
+			Type:      message.SessionFinishedType,
+			SessionID: session.SessionID,
+			EndedAt:   record.EndedAt,
+			Outcome:   record.Outcome,
+		}))
+	default:
+		cm.BroadcastToSession(session, message.NewServerMessage("", message.SessionStatus{
+			Type:      message.SessionStatusType,
+			SessionID: session.SessionID,
+			Status:    string(event.To),
+			Previous:  string(event.From),
+			At:        event.At,
+			Outcome:   session.Outcome,
+		}))
+	}
+
+	if event.To.Over() && cm.spectators != nil {
+		cm.spectators.End(session.SessionID, string(event.To))
+	}
+}
@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"simple-multiplayer-service/internal/client"
+	"simple-multiplayer-service/internal/logging"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/spectate"
+)
+
+var (
+	// ErrSpectatingDisabled answers spectator requests when no spectator manager is set
+	ErrSpectatingDisabled = errors.New("spectating is disabled")
+	// ErrSpectatorInput is returned when a spectator messages a player of the session it watches
+	ErrSpectatorInput = errors.New("spectators can't message the players they watch")
+)
+
+// SetSpectators lets clients watch sessions through spectators
+func (cm *ConnectionManager) SetSpectators(spectators *spectate.Manager) {
+	spectators.DeliverFunc = cm.deliverToSpectators
+	cm.spectators = spectators
+}
+
+// HandleSpectateRequest starts or stops a client spectating a session, or
+// lists the session's spectators
+func (cm *ConnectionManager) HandleSpectateRequest(c *client.Client, request message.SpectateRequest) {
+	if cm.spectators == nil {
+		cm.rejectSpectateRequest(c, request, ErrSpectatingDisabled)
+		return
+	}
+
+	switch request.Type {
+	case message.SpectateType:
+		session, spectators, err := cm.spectators.Watch(request.SessionID, spectator(c))
+		if err != nil {
+			cm.rejectSpectateRequest(c, request, err)
+			return
+		}
+		cm.logger.Debug("client spectating session", slog.String(logging.KeyClientID, c.ID), slog.String(logging.KeySessionID, session.SessionID))
+		cm.sendToClient(c, message.NewServerMessage(c.ID, message.Spectating{
+			Type:         message.SpectatingType,
+			SessionID:    session.SessionID,
+			Player1ID:    session.Player1ID,
+			Player2ID:    session.Player2ID,
+			Players:      session.Players(),
+			DelaySeconds: int(cm.spectators.Delay() / time.Second),
+			Spectators:   spectators,
+		}))
+
+	case message.StopSpectatingType:
+		if err := cm.spectators.Leave(request.SessionID, c.ID); err != nil {
+			cm.rejectSpectateRequest(c, request, err)
+			return
+		}
+		cm.logger.Debug("client stopped spectating session", slog.String(logging.KeyClientID, c.ID), slog.String(logging.KeySessionID, request.SessionID))
+		cm.sendToClient(c, message.NewServerMessage(c.ID, message.SpectatingStopped{
+			Type:      message.SpectatingStoppedType,
+			SessionID: request.SessionID,
+			Reason:    "stopped by client",
+		}))
+
+	case message.ListSpectatorsType:
+		cm.sendToClient(c, message.NewServerMessage(c.ID, message.Spectators{
+			Type:       message.SpectatorsType,
+			SessionID:  request.SessionID,
+			Spectators: cm.Spectators(request.SessionID),
+		}))
+	}
+}
+
+// Spectators returns the connections watching a session
+func (cm *ConnectionManager) Spectators(sessionID string) []message.Spectator {
+	if cm.spectators == nil {
+		return []message.Spectator{}
+	}
+	return cm.spectators.Spectators(sessionID)
+}
+
+// EndSession tells the players of a session that is over why it ended. Its
+// spectators were already stopped when the session moved to its final status.
+func (cm *ConnectionManager) EndSession(session matchmaking.Session, reason string) int {
+	return cm.BroadcastToPlayers(session.Players(), message.NewServerMessage("", message.SessionEnded{
+		Type:      message.SessionEndedType,
+		SessionID: session.SessionID,
+		Reason:    reason,
+	}))
+}
+
+// deliverFrom sends a direct message from c, refusing input from spectators
+// to the players they watch and relaying messages between the players of a
+// watched session to its spectators
+func (cm *ConnectionManager) deliverFrom(c *client.Client, msg message.Message) (string, error) {
+	if cm.spectators == nil {
+		return cm.DeliverMessage(msg)
+	}
+
+	recipient := msg.To
+	if target, exists := cm.GetClient(msg.To); exists {
+		recipient = target.PlayerID()
+	}
+	if session, watching := cm.spectators.Watching(c.ID); watching && session.HasPlayer(recipient) {
+		cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeSpectatorInput, ErrSpectatorInput.Error()))
+		return message.ReceiptFailed, ErrSpectatorInput
+	}
+
+	status, err := cm.DeliverMessage(msg)
+	if err == nil && status == message.ReceiptDelivered {
+		if sessionID, ok := cm.spectators.SessionBetween(msg.From, recipient); ok {
+			cm.spectators.Publish(sessionID, spectated(sessionID, msg, recipient))
+		}
+	}
+	return status, err
+}
+
+// relayToSpectators passes a message sent to the players of a session on to its spectators
+func (cm *ConnectionManager) relayToSpectators(session matchmaking.Session, msg message.Message) {
+	if cm.spectators == nil {
+		return
+	}
+	cm.spectators.Publish(session.SessionID, spectated(session.SessionID, msg, ""))
+}
+
+// stopSpectating stops a closed connection watching its session
+func (cm *ConnectionManager) stopSpectating(c *client.Client) {
+	if cm.spectators != nil {
+		cm.spectators.LeaveAll(c.ID)
+	}
+}
+
+// deliverToSpectators writes msg to the connections of spectators
+func (cm *ConnectionManager) deliverToSpectators(spectators []message.Spectator, msg message.Message) {
+	cm.mutex.RLock()
+	targets := make([]*client.Client, 0, len(spectators))
+	for _, s := range spectators {
+		if c, exists := cm.clients[s.ConnectionID]; exists {
+			targets = append(targets, c)
+		}
+	}
+	cm.mutex.RUnlock()
+	cm.fanOut(targets, msg)
+}
+
+// rejectSpectateRequest answers a spectator request that can't be served
+func (cm *ConnectionManager) rejectSpectateRequest(c *client.Client, request message.SpectateRequest, err error) {
+	cm.sendToClient(c, message.NewErrorMessage(c.ID, message.ErrorCodeSpectateRefused, err.Error()+": "+request.SessionID))
+}
+
+// spectated wraps a message sent within a session for its spectators
+func spectated(sessionID string, msg message.Message, to string) message.Message {
+	return message.NewServerMessage("", message.SpectatedMessage{
+		Type:      message.SpectatedMessageType,
+		SessionID: sessionID,
+		From:      msg.From,
+		To:        to,
+		Content:   msg.Content,
+		SentAt:    time.Now().UTC(),
+	})
+}
+
+func spectator(c *client.Client) message.Spectator {
+	s := message.Spectator{ConnectionID: c.ID, PlayerID: c.PlayerID()}
+	if c.Player != nil {
+		s.DisplayName = c.Player.DisplayName
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/codec"
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+	"simple-multiplayer-service/internal/spectate"
+	"simple-multiplayer-service/internal/transport"
+)
+
+// TestSpectators tests that spectators see the messages between the players
+// of a session but can't message them
+func TestSpectators(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	manager := NewConnectionManager(matchmaking.NewMatchmakingService(10, sessions, notifSvc), notifSvc)
+	manager.SetSpectators(spectate.NewManager(sessions, 1, 0))
+	sessions.CreateSession("spectated-session", matchmaking.ModeMatchmaking, []string{"alice", "bob"}, time.Now())
+
+	alice, _ := connectPipe(t, manager, "alice")
+	bob, _ := connectPipe(t, manager, "bob")
+	carol, carolClient := connectPipe(t, manager, "carol")
+	dave, _ := connectPipe(t, manager, "dave")
+
+	spectate := message.Message{Content: `{"type":"spectate","session_id":"spectated-session"}`}
+	sendPipe(t, carol, spectate)
+	var spectating message.Spectating
+	readContentPipe(t, carol, message.SpectatingType, &spectating)
+	if spectating.Type != message.SpectatingType || spectating.Player1ID != "alice" || len(spectating.Spectators) != 1 || spectating.Spectators[0].ConnectionID != carolClient.ID {
+		t.Errorf("Unexpected spectating confirmation %+v", spectating)
+	}
+
+	// Players can't watch their own session, and the session is full
+	for _, conn := range []*transport.PipeConn{alice, dave} {
+		sendPipe(t, conn, spectate)
+		var refused message.Error
+		readContentPipe(t, conn, message.ErrorType, &refused)
+		if refused.Code != message.ErrorCodeSpectateRefused {
+			t.Errorf("Expected the spectate request to be refused, got %+v", refused)
+		}
+	}
+
+	// Moves between the players reach the spectator
+	sendPipe(t, alice, message.Message{To: "bob", Content: "e2e4"})
+	if msg := readPipe(t, bob, codec.JSON); msg.Content != "e2e4" {
+		t.Errorf("Expected bob to get the move, got %+v", msg)
+	}
+	var relayed message.SpectatedMessage
+	readContentPipe(t, carol, message.SpectatedMessageType, &relayed)
+	if relayed.SessionID != "spectated-session" || relayed.From != "alice" || relayed.To != "bob" || relayed.Content != "e2e4" {
+		t.Errorf("Unexpected relayed message %+v", relayed)
+	}
+
+	// The spectator can't send input to the players
+	sendPipe(t, carol, message.Message{To: "alice", Content: "bob plays d5"})
+	var refused message.Error
+	readContentPipe(t, carol, message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeSpectatorInput {
+		t.Errorf("Expected spectator input to be refused, got %+v", refused)
+	}
+	sendPipe(t, bob, message.Message{To: "alice", Content: "d7d5"})
+	if msg := readPipe(t, alice, codec.JSON); msg.From != "bob" {
+		t.Errorf("Expected alice to get bob's move and nothing from the spectator, got %+v", msg)
+	}
+	readContentPipe(t, carol, message.SpectatedMessageType, &relayed)
+
+	sendPipe(t, dave, message.Message{Content: `{"type":"listSpectators","session_id":"spectated-session"}`})
+	var listed message.Spectators
+	readContentPipe(t, dave, message.SpectatorsType, &listed)
+	if len(listed.Spectators) != 1 || listed.Spectators[0].PlayerID != "carol" {
+		t.Errorf("Expected carol to be listed, got %+v", listed)
+	}
+
+	// Spectators leave when they disconnect
+	carol.Close()
+	deadline := time.Now().Add(time.Second)
+	for len(manager.Spectators("spectated-session")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the disconnected spectator to be removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSpectatorsLobbySession tests that every player of a lobby session is
+// a player to its spectators, not only the first two
+func TestSpectatorsLobbySession(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	sessions := local.DB{}
+	manager := NewConnectionManager(matchmaking.NewMatchmakingService(10, sessions, notifSvc), notifSvc)
+	manager.SetSpectators(spectate.NewManager(sessions, 0, 0))
+	sessions.CreateSession("spectated-lobby", matchmaking.ModeLobby, []string{"alice", "bob", "carol"}, time.Now())
+
+	alice, _ := connectPipe(t, manager, "alice")
+	carol, _ := connectPipe(t, manager, "carol")
+	dave, _ := connectPipe(t, manager, "dave")
+
+	sendPipe(t, dave, message.Message{Content: `{"type":"spectate","session_id":"spectated-lobby"}`})
+	var spectating message.Spectating
+	readContentPipe(t, dave, message.SpectatingType, &spectating)
+	if len(spectating.Players) != 3 || spectating.Players[2] != "carol" {
+		t.Errorf("Expected every player to be named, got %+v", spectating)
+	}
+
+	sendPipe(t, carol, message.Message{To: "alice", Content: "raise"})
+	readPipe(t, alice, codec.JSON)
+	var relayed message.SpectatedMessage
+	readContentPipe(t, dave, message.SpectatedMessageType, &relayed)
+	if relayed.From != "carol" || relayed.To != "alice" || relayed.Content != "raise" {
+		t.Errorf("Unexpected relayed message %+v", relayed)
+	}
+
+	sendPipe(t, dave, message.Message{To: "carol", Content: "alice is bluffing"})
+	var refused message.Error
+	readContentPipe(t, dave, message.ErrorType, &refused)
+	if refused.Code != message.ErrorCodeSpectatorInput {
+		t.Errorf("Expected spectator input to the third player to be refused, got %+v", refused)
+	}
+}
@@ -0,0 +1,337 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simple-multiplayer-service/internal/codec"
+	"simple-multiplayer-service/internal/logging"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// SSEConnectionTokenHeader carries the token from the connected event on
+// every POST of an SSE client
+const SSEConnectionTokenHeader = "X-Connection-Token"
+
+// sseKeepAliveInterval keeps proxies from closing idle streams
+const sseKeepAliveInterval = 15 * time.Second
+
+// Events written to SSE streams besides plain messages
+const (
+	sseEventConnected = "connected"
+	sseEventClose     = "close"
+)
+
+// errSSEClosed is returned by reads and writes on a closed SSE connection.
+// Going away is how a browser leaving ends a websocket too.
+var errSSEClosed = &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "stream closed"}
+
+// SSEConnected is the first event of an SSE stream. The client sends it
+// back in SSEConnectionTokenHeader to post messages on the connection.
+type SSEConnected struct {
+	ConnectionToken string `json:"connection_token"`
+}
+
+// SSEClose is the last event of a stream the server closes, carrying the
+// code and reason a websocket client would get in its close frame
+type SSEClose struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// sseEvent is a server-sent event; an empty name is a plain message
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+// sseConn is a client connection made of a server-sent event stream for
+// messages to the client and POST requests for messages from it. It
+// implements transport.Conn so the client can't tell it from a websocket.
+type sseConn struct {
+	token     string
+	addr      net.Addr
+	incoming  chan []byte
+	outgoing  chan sseEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	readLimit atomic.Int64
+}
+
+func newSSEConn(remoteAddr string) *sseConn {
+	addr, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		addr = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+	return &sseConn{
+		token:    uuid.New().String(),
+		addr:     net.TCPAddrFromAddrPort(addr),
+		incoming: make(chan []byte, 16),
+		outgoing: make(chan sseEvent, 64),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *sseConn) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-c.incoming:
+		return websocket.TextMessage, data, nil
+	case <-c.done:
+		return 0, nil, errSSEClosed
+	}
+}
+
+func (c *sseConn) WriteMessage(messageType int, data []byte) error {
+	return c.send(sseEvent{data: data})
+}
+
+// WriteClose ends the stream with a close event
+func (c *sseConn) WriteClose(code int, reason string) error {
+	encoded, _ := json.Marshal(SSEClose{Code: code, Reason: reason})
+	return c.send(sseEvent{name: sseEventClose, data: encoded})
+}
+
+func (c *sseConn) SetReadLimit(limit int64) {
+	c.readLimit.Store(limit)
+}
+
+func (c *sseConn) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *sseConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *sseConn) send(event sseEvent) error {
+	select {
+	case c.outgoing <- event:
+		return nil
+	case <-c.done:
+		return errSSEClosed
+	}
+}
+
+// sseRegistry finds the stream a POST belongs to by its connection token
+type sseRegistry struct {
+	mutex sync.Mutex
+	conns map[string]*sseConn
+}
+
+func (r *sseRegistry) add(conn *sseConn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[string]*sseConn)
+	}
+	r.conns[conn.token] = conn
+}
+
+func (r *sseRegistry) remove(token string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.conns, token)
+}
+
+func (r *sseRegistry) get(token string) (*sseConn, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	conn, ok := r.conns[token]
+	return conn, ok
+}
+
+// HandleSSE serves the fallback transport for networks that break websocket
+// upgrades. A GET opens a server-sent event stream delivering the messages a
+// websocket client would receive, starting with a connected event carrying
+// the connection token. Each POST with that token in SSEConnectionTokenHeader
+// sends one JSON message, as a websocket client would send a frame.
+func HandleSSE(manager *ConnectionManager, w http.ResponseWriter, r *http.Request) {
+	if !allowCrossOrigin(manager, w, r) {
+		manager.metrics.ConnectionRejected("origin")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		streamSSE(manager, w, r)
+	case http.MethodPost:
+		receiveSSE(manager, w, r)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// streamSSE registers a client on a new stream and writes its messages until
+// either side closes it
+func streamSSE(manager *ConnectionManager, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	a, ok := admit(manager, w, r, "sse")
+	if !ok {
+		return
+	}
+
+	conn := newSSEConn(r.RemoteAddr)
+	manager.sse.add(conn)
+	defer manager.sse.remove(conn.token)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	connected, _ := json.Marshal(SSEConnected{ConnectionToken: conn.token})
+	if err := writeSSEEvent(w, sseEvent{name: sseEventConnected, data: connected}); err != nil {
+		a.rateLimiter.Release()
+		return
+	}
+	flusher.Flush()
+
+	// Messages only go to SSE clients as JSON; there is no subprotocol to negotiate
+	if startClient(manager, conn, codec.JSON, a) == nil {
+		writePendingSSEEvents(w, conn)
+		flusher.Flush()
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case event := <-conn.outgoing:
+			err = writeSSEEvent(w, event)
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case <-conn.done:
+			writePendingSSEEvents(w, conn)
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			// The client went away; the read loop unregisters it
+			conn.Close()
+			return
+		}
+		if err != nil {
+			a.logger.Debug("error writing sse event", logging.Err(err))
+			conn.Close()
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// receiveSSE passes a POSTed message to the read loop of its stream's client
+func receiveSSE(manager *ConnectionManager, w http.ResponseWriter, r *http.Request) {
+	conn, ok := manager.sse.get(r.Header.Get(SSEConnectionTokenHeader))
+	if !ok {
+		http.Error(w, "unknown connection token", http.StatusNotFound)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if limit := conn.readLimit.Load(); limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			// Oversized websocket frames close the connection, so do oversized posts
+			conn.WriteClose(websocket.CloseMessageTooBig, "message too big")
+			conn.Close()
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case conn.incoming <- data:
+		w.WriteHeader(http.StatusAccepted)
+	case <-conn.done:
+		http.Error(w, "connection closed", http.StatusGone)
+	case <-r.Context().Done():
+	}
+}
+
+// writeSSEEvent writes event in the text/event-stream format, one data line
+// per line of its payload
+func writeSSEEvent(w io.Writer, event sseEvent) error {
+	var buf bytes.Buffer
+	if event.name != "" {
+		fmt.Fprintf(&buf, "event: %s\n", event.name)
+	}
+	for _, line := range strings.Split(string(event.data), "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writePendingSSEEvents writes what was queued before the connection closed,
+// such as the close event
+func writePendingSSEEvents(w io.Writer, conn *sseConn) {
+	for {
+		select {
+		case event := <-conn.outgoing:
+			if writeSSEEvent(w, event) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// allowCrossOrigin applies the websocket origin policy to SSE requests and
+// sets the CORS headers a browser needs to read the response
+func allowCrossOrigin(manager *ConnectionManager, w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	var allowed bool
+	if len(manager.upgradeOptions.AllowedOrigins) > 0 {
+		allowed = originChecker(manager.upgradeOptions.AllowedOrigins)(r)
+	} else {
+		// Same origin only, as the websocket upgrader does without an allow-list
+		originURL, err := url.Parse(origin)
+		allowed = err == nil && strings.EqualFold(originURL.Host, r.Host)
+	}
+	if !allowed {
+		manager.logger.Warn("rejecting sse request", slog.String("reason", "origin not allowed"), slog.String("origin", origin))
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+SSEConnectionTokenHeader)
+	w.Header().Add("Vary", "Origin")
+	return true
+}
@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-multiplayer-service/internal/db/local"
+	"simple-multiplayer-service/internal/matchmaking"
+	"simple-multiplayer-service/internal/message"
+	"simple-multiplayer-service/internal/notification"
+
+	"github.com/gorilla/websocket"
+)
+
+// sseClient reads a server-sent event stream and posts messages back
+type sseClient struct {
+	t      *testing.T
+	url    string
+	token  string
+	id     string
+	events chan sseEvent
+}
+
+// openSSE opens a stream, reading the connected event and the welcome message
+func openSSE(t *testing.T, baseURL string) *sseClient {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/sse")
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	c := &sseClient{t: t, url: baseURL + "/sse", events: make(chan sseEvent, 16)}
+	go func() {
+		defer close(c.events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				c.events <- event
+				event = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = append(event.data, strings.TrimPrefix(line, "data: ")...)
+			}
+		}
+	}()
+
+	var connected SSEConnected
+	if err := json.Unmarshal(c.next(sseEventConnected).data, &connected); err != nil || connected.ConnectionToken == "" {
+		t.Fatalf("Expected a connection token, got %v", err)
+	}
+	c.token = connected.ConnectionToken
+	c.id = c.read().To
+	return c
+}
+
+func (c *sseClient) next(name string) sseEvent {
+	c.t.Helper()
+	select {
+	case event, ok := <-c.events:
+		if !ok {
+			c.t.Fatalf("Stream ended waiting for a %q event", name)
+		}
+		if event.name != name {
+			c.t.Fatalf("Expected a %q event, got %q: %s", name, event.name, event.data)
+		}
+		return event
+	case <-time.After(time.Second):
+		c.t.Fatalf("Timed out waiting for a %q event", name)
+	}
+	return sseEvent{}
+}
+
+func (c *sseClient) read() message.Message {
+	c.t.Helper()
+	var msg message.Message
+	if err := json.Unmarshal(c.next("").data, &msg); err != nil {
+		c.t.Fatalf("Error decoding message: %v", err)
+	}
+	return msg
+}
+
+func (c *sseClient) post(body string) int {
+	c.t.Helper()
+	request, _ := http.NewRequest(http.MethodPost, c.url, strings.NewReader(body))
+	request.Header.Set(SSEConnectionTokenHeader, c.token)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		c.t.Fatalf("Error posting: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestSSETransport tests that SSE clients message and match with websocket clients
+func TestSSETransport(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	mmSvc := matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc)
+	manager := NewConnectionManager(mmSvc, notifSvc)
+	manager.SetMessageLimits(1024, 512)
+	go mmSvc.Start()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.StartNotifications(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) { HandleWebSocket(manager, w, r) })
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) { HandleSSE(manager, w, r) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sse := openSSE(t, server.URL)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer ws.Close()
+	var welcome message.Message
+	ws.ReadJSON(&welcome)
+	wsID := welcome.To
+
+	// Direct messages flow both ways between the transports
+	if status := sse.post(`{"to":"` + wsID + `","content":"hello over sse"}`); status != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", status)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var msg message.Message
+	if err := ws.ReadJSON(&msg); err != nil || msg.From != sse.id || msg.Content != "hello over sse" {
+		t.Errorf("Unexpected message on the websocket %+v, %v", msg, err)
+	}
+	ws.WriteJSON(message.Message{To: sse.id, Content: "hello over websocket"})
+	if msg := sse.read(); msg.From != wsID || msg.Content != "hello over websocket" {
+		t.Errorf("Unexpected message on the stream %+v", msg)
+	}
+
+	// Both get matched together
+	sse.post(`{"content":"{\"type\":\"matchmakingRequest\"}"}`)
+	ws.WriteJSON(message.Message{Content: `{"type":"matchmakingRequest"}`})
+	var session notification.SessionNotification
+	json.Unmarshal([]byte(sse.read().Content), &session)
+	if !(session.Player1ID == sse.id && session.Player2ID == wsID) && !(session.Player1ID == wsID && session.Player2ID == sse.id) {
+		t.Errorf("Expected a session between both clients, got %+v", session)
+	}
+
+	// Posts need the stream's token
+	request, _ := http.NewRequest(http.MethodPost, sse.url, strings.NewReader(`{"to":"x","content":"y"}`))
+	request.Header.Set(SSEConnectionTokenHeader, "wrong")
+	if resp, err := http.DefaultClient.Do(request); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown token, got %v, %v", resp, err)
+	}
+
+	// Invalid messages close the stream as they close a websocket
+	sse.post(`not json`)
+	var closeEvent SSEClose
+	json.Unmarshal(sse.next(sseEventClose).data, &closeEvent)
+	if closeEvent.Code != websocket.CloseInvalidFramePayloadData {
+		t.Errorf("Expected close code 1007, got %+v", closeEvent)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, exists := manager.GetClient(sse.id); !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the SSE client to be unregistered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := sse.post(`{"to":"x","content":"y"}`); status != http.StatusNotFound {
+		t.Errorf("Expected posts to a closed stream to fail, got %d", status)
+	}
+
+	// Oversized posts close the stream like oversized frames
+	big := openSSE(t, server.URL)
+	if status := big.post(`{"to":"x","content":"` + strings.Repeat("a", 2048) + `"}`); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", status)
+	}
+	json.Unmarshal(big.next(sseEventClose).data, &closeEvent)
+	if closeEvent.Code != websocket.CloseMessageTooBig {
+		t.Errorf("Expected close code 1009, got %+v", closeEvent)
+	}
+}
+
+// TestSSEOrigins tests that SSE requests follow the websocket origin policy
+func TestSSEOrigins(t *testing.T) {
+	notifSvc := notification.NewNotificationService()
+	manager := NewConnectionManager(matchmaking.NewMatchmakingService(10, local.DB{}, notifSvc), notifSvc)
+	opts := DefaultUpgradeOptions()
+	opts.AllowedOrigins = []string{"https://game.example.com"}
+	manager.SetUpgradeOptions(opts)
+
+	for origin, expected := range map[string]int{
+		"https://game.example.com": http.StatusNoContent,
+		"https://evil.example.com": http.StatusForbidden,
+	} {
+		request := httptest.NewRequest(http.MethodOptions, "/sse", nil)
+		request.Header.Set("Origin", origin)
+		recorder := httptest.NewRecorder()
+		HandleSSE(manager, recorder, request)
+		if recorder.Code != expected {
+			t.Errorf("%s: expected %d, got %d", origin, expected, recorder.Code)
+		}
+		if expected == http.StatusNoContent && recorder.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("%s: expected CORS headers, got %v", origin, recorder.Header())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// UpgradeOptions configures how HTTP requests are upgraded to WebSocket connections
+type UpgradeOptions struct {
+	// AllowedOrigins lists the origins allowed to connect, e.g.
+	// "https://example.com" or "https://*.example.com". "*" allows any
+	// origin; an empty list only allows same-origin requests.
+	AllowedOrigins    []string
+	ReadBufferSize    int
+	WriteBufferSize   int
+	Subprotocols      []string
+	EnableCompression bool
+	// CompressionLevel is the flate level of compressed frames, from -2 to 9;
+	// zero keeps the library default
+	CompressionLevel int
+	// CompressionThreshold is the smallest frame in bytes worth compressing,
+	// smaller frames are sent uncompressed
+	CompressionThreshold int
+}
+
+// DefaultCompressionThreshold is the smallest frame compressed by default.
+// Deflate framing costs more than it saves on frames much smaller than this.
+const DefaultCompressionThreshold = 512
+
+// DefaultUpgradeOptions returns the options used when none are configured
+func DefaultUpgradeOptions() UpgradeOptions {
+	return UpgradeOptions{
+		ReadBufferSize:       1024,
+		WriteBufferSize:      1024,
+		CompressionThreshold: DefaultCompressionThreshold,
+	}
+}
+
+// newUpgrader builds an upgrader enforcing the origin allow-list
+func newUpgrader(opts UpgradeOptions) *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:    opts.ReadBufferSize,
+		WriteBufferSize:   opts.WriteBufferSize,
+		Subprotocols:      opts.Subprotocols,
+		EnableCompression: opts.EnableCompression,
+	}
+	// A nil CheckOrigin makes gorilla only accept same-origin requests
+	if len(opts.AllowedOrigins) > 0 {
+		upgrader.CheckOrigin = originChecker(opts.AllowedOrigins)
+	}
+	return upgrader
+}
+
+// originChecker returns a CheckOrigin func accepting requests without an
+// Origin header (non-browser clients) and those matching an allowed origin
+func originChecker(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, pattern := range allowed {
+			if matchOrigin(pattern, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "*" {
+		return true
+	}
+	if strings.EqualFold(pattern, origin) {
+		return true
+	}
+
+	patternURL, err := url.Parse(pattern)
+	if err != nil {
+		return false
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(patternURL.Scheme, originURL.Scheme) {
+		return false
+	}
+
+	// "*.example.com" matches any subdomain but not example.com itself
+	if suffix, ok := strings.CutPrefix(patternURL.Host, "*."); ok {
+		host := strings.ToLower(originURL.Host)
+		return strings.HasSuffix(host, "."+strings.ToLower(suffix))
+	}
+	return strings.EqualFold(patternURL.Host, originURL.Host)
+}